@@ -24,6 +24,19 @@ local buffer_state = {
 	current_win = nil,
 }
 
+-- Filetypes that never get completions or serve as context
+---@type table<string, boolean>
+local skip_filetypes = { [""] = true, help = true, qf = true, netrw = true, fugitive = true, NvimTree = true }
+
+-- Check the buffer-local skip rules (everything but the floating window check)
+---@param bufnr integer
+---@return boolean
+local function skip_buffer(bufnr)
+	return not vim.api.nvim_get_option_value("modifiable", { buf = bufnr })
+		or vim.api.nvim_get_option_value("readonly", { buf = bufnr })
+		or skip_filetypes[vim.api.nvim_get_option_value("filetype", { buf = bufnr })] == true
+end
+
 -- Function to update buffer state (called when buffer/window changes)
 local function update_buffer_state()
 	---@type integer
@@ -55,23 +68,42 @@ local function update_buffer_state()
 	buffer_state.is_readonly = vim.api.nvim_get_option_value("readonly", { buf = current_buf })
 	buffer_state.filetype = vim.api.nvim_get_option_value("filetype", { buf = current_buf })
 
-	-- Check if we should skip this buffer type
-	---@type string[]
-	local skip_filetypes = { "", "help", "qf", "netrw", "fugitive", "NvimTree" }
-	---@type boolean
-	local should_skip_filetype = false
-	for _, ft in ipairs(skip_filetypes) do
-		if buffer_state.filetype == ft then
-			should_skip_filetype = true
-			break
-		end
-	end
-
 	-- Combined check: should we skip idle completions for this buffer?
 	buffer_state.should_skip = buffer_state.is_floating_window
 		or not buffer_state.is_modifiable
 		or buffer_state.is_readonly
-		or should_skip_filetype
+		or skip_filetypes[buffer_state.filetype] == true
+end
+
+-- Git toplevel per directory (false when the directory is not in a repository)
+---@type table<string, string|false>
+local git_roots = {}
+
+-- Find the git toplevel containing dir
+---@param dir string
+---@return string|nil
+local function git_root(dir)
+	if git_roots[dir] == nil then
+		local marker = vim.fs.find(".git", { path = dir, upward = true })[1]
+		git_roots[dir] = marker and vim.fs.dirname(marker) or false
+	end
+	return git_roots[dir] or nil
+end
+
+-- Find the deepest root_dir of the LSP clients attached to bufnr that contains name
+---@param bufnr integer
+---@param name string
+---@return string|nil
+local function lsp_root(bufnr, name)
+	local get_clients = vim.lsp.get_clients or vim.lsp.get_active_clients
+	local best = nil
+	for _, client in ipairs(get_clients({ bufnr = bufnr })) do
+		local root = client.config and client.config.root_dir
+		if root and name:sub(1, #root + 1) == root .. "/" and (not best or #root > #best) then
+			best = root
+		end
+	end
+	return best
 end
 
 -- Public API
@@ -87,4 +119,116 @@ function buffer.should_skip()
 	return buffer_state.should_skip
 end
 
+-- Workspace root of the current buffer: the root_dir of an attached LSP
+-- client, else the git toplevel, else the working directory of the window.
+-- Called by the daemon on every sync.
+---@return string
+function buffer.workspace_root()
+	local bufnr = vim.api.nvim_get_current_buf()
+	local name = vim.api.nvim_buf_get_name(bufnr)
+	local cwd = vim.fn.getcwd(0)
+	if name == "" then
+		return cwd
+	end
+
+	return lsp_root(bufnr, name) or git_root(vim.fs.dirname(name)) or cwd
+end
+
+-- Text of up to max_buffers other listed buffers in the workspace, max_lines
+-- lines around the last cursor position in each, most recently used first.
+-- Buffers that should_skip would skip for their own options are left out.
+-- Called by the daemon on every request when provider.context_buffers > 0.
+---@param max_buffers integer
+---@param max_lines integer
+---@return { name: string, lines: string[] }[]
+function buffer.context_buffers(max_buffers, max_lines)
+	local current = vim.api.nvim_get_current_buf()
+	local infos = vim.fn.getbufinfo({ buflisted = 1, bufloaded = 1 })
+	table.sort(infos, function(a, b)
+		return a.lastused > b.lastused
+	end)
+
+	local root = buffer.workspace_root()
+	local result = {}
+	for _, info in ipairs(infos) do
+		if #result >= max_buffers then
+			break
+		end
+		if
+			info.bufnr ~= current
+			and info.name:sub(1, #root + 1) == root .. "/"
+			and vim.bo[info.bufnr].buftype == ""
+			and not skip_buffer(info.bufnr)
+		then
+			-- Row of the last cursor position, 0 when the buffer was never entered
+			local row = vim.api.nvim_buf_get_mark(info.bufnr, '"')[1]
+			local first = math.max(math.min(row - 1 - math.floor(max_lines / 2), info.linecount - max_lines), 0)
+			table.insert(result, {
+				name = info.name,
+				lines = vim.api.nvim_buf_get_lines(info.bufnr, first, first + max_lines, false),
+			})
+		end
+	end
+	return result
+end
+
+-- First whole-word use of word in each other listed buffer of the workspace,
+-- most recently used first. line is 0 for buffers without a use. Unlike the
+-- files on disk, unsaved changes are seen.
+-- Called by the daemon to follow a rename into other files.
+---@param word string
+---@return table[]
+function buffer.find_word(word)
+	local current = vim.api.nvim_get_current_buf()
+	local infos = vim.fn.getbufinfo({ buflisted = 1, bufloaded = 1 })
+	table.sort(infos, function(a, b)
+		return a.lastused > b.lastused
+	end)
+
+	-- Word characters match the daemon's: letters, digits, _ and non-ASCII bytes
+	local pattern = "%f[%w_\128-\255]" .. vim.pesc(word) .. "%f[^%w_\128-\255]"
+	local root = buffer.workspace_root()
+	local result = {}
+	for _, info in ipairs(infos) do
+		if
+			info.bufnr ~= current
+			and info.name:sub(1, #root + 1) == root .. "/"
+			and vim.bo[info.bufnr].buftype == ""
+		then
+			local use = { name = info.name, line = 0, text = "" }
+			for i, line in ipairs(vim.api.nvim_buf_get_lines(info.bufnr, 0, -1, false)) do
+				if line:find(pattern) then
+					use.line = i
+					use.text = line
+					break
+				end
+			end
+			table.insert(result, use)
+		end
+	end
+	return result
+end
+
+-- Switch the current window to path with the cursor at the start of line.
+-- A window already showing the file is reused; otherwise the file is edited in
+-- the current window, or in a split when the current buffer cannot be left.
+-- Called by the daemon when a jump into another file is accepted.
+---@param path string Absolute file path
+---@param line integer 1-indexed line
+function buffer.open_file(path, line)
+	vim.cmd("normal! m'")
+
+	local bufnr = vim.fn.bufnr(path)
+	local win = bufnr ~= -1 and vim.fn.bufwinid(bufnr) or -1
+	if win ~= -1 then
+		vim.api.nvim_set_current_win(win)
+	elseif not pcall(vim.cmd, "edit " .. vim.fn.fnameescape(path)) then
+		vim.cmd("split " .. vim.fn.fnameescape(path))
+	end
+
+	local last = vim.api.nvim_buf_line_count(0)
+	vim.api.nvim_win_set_cursor(0, { math.min(math.max(line, 1), last), 0 })
+	vim.cmd("normal! ^zz")
+end
+
 return buffer
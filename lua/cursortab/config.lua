@@ -32,6 +32,18 @@
 ---@field suffix string FIM suffix token (e.g., "<|fim_suffix|>")
 ---@field middle string FIM middle token (e.g., "<|fim_middle|>")
 
+---@class CursortabCircuitBreakerConfig
+---@field failures integer Consecutive failures that stop requests to the server (0 = off)
+---@field cooldown integer Time in ms requests stay stopped before the server is probed
+
+---@class CursortabSamplingConfig
+---@field min_p number|nil Drop tokens below this fraction of the top token's probability (0 to 1)
+---@field repetition_penalty number|nil Penalty on repeated tokens (> 1 discourages loops)
+---@field presence_penalty number|nil Penalty on tokens already in the output (-2 to 2)
+---@field seed integer|nil Seed for reproducible sampling
+---@field grammar string|nil GBNF grammar constraining the output (llama.cpp)
+---@field logit_bias table<string|integer, number>|nil Bias added to the logits of token IDs (-100 to 100)
+
 ---@class CursortabProviderConfig
 ---@field type string
 ---@field url string
@@ -39,10 +51,44 @@
 ---@field temperature number
 ---@field max_tokens integer Max tokens to generate (also used to derive input context size)
 ---@field top_k integer
+---@field candidates integer Completions requested per request; more than 1 turns off streaming
 ---@field completion_timeout integer
+---@field max_concurrent integer Requests sent to the provider server at once (0 = no limit)
+---@field max_retries integer Retries of requests the server turns away with 429 or 503
+---@field circuit_breaker CursortabCircuitBreakerConfig
 ---@field max_diff_history_tokens integer
+---@field diff_history_files integer Recently edited files whose diffs are sent (1 = current file only)
+---@field context_buffers integer Other open buffers whose text is sent as context (infill provider, 0 = none)
 ---@field completion_path string API endpoint path (e.g., "/v1/completions")
+---@field api string Wire format of the endpoint: "completions", "chat", "fim" or "ollama"
+---@field headers table<string, string> Extra HTTP headers sent with every request
+---@field api_key_env string|nil Environment variable holding the API key (sent as a bearer token)
+---@field api_key_file string|nil File holding the API key (sent as a bearer token)
 ---@field fim_tokens CursortabFIMTokensConfig|nil FIM tokens configuration (optional)
+---@field fim_mode string How the FIM provider sends the text around the cursor: "tokens" or "server"
+---@field keep_alive string|nil How long Ollama keeps the model loaded after a request (e.g., "30m")
+---@field sampling CursortabSamplingConfig Extra sampling parameters (unset ones are left to the server)
+---@field extra_body table<string, any> Fields merged into every request body, overriding the built ones
+---@field cursor_target_marker string Output line prefix the model names its next edit location with ("" = off)
+---@field fallbacks table[] Providers tried in order when this one skips, fails, or times out
+---@field fallback_timeout integer Max ms to wait for a provider's first output before falling back (0 = no limit)
+
+---@class CursortabRule
+---@field pattern string|nil Glob matched against the workspace-relative path (e.g., "*.md", "vendor/**")
+---@field filetype string|nil Neovim filetype (e.g., "markdown")
+---@field provider string|table|nil Provider type, or provider table overriding fields of `provider`
+---@field disabled boolean|nil Turn completions off for matching files
+
+---@class CursortabHistoryConfig
+---@field persist boolean Keep edit history on disk across daemon restarts
+---@field dir string|nil Directory for the history (default: stdpath("cache") .. "/cursortab/history")
+---@field max_size_mb integer Size cap for the stored history
+
+---@class CursortabKeymapsConfig
+---@field next_candidate string|false Show the next completion candidate
+---@field prev_candidate string|false Show the previous completion candidate
+---@field accept_word string|false Accept the next word of the completion
+---@field accept_line string|false Accept the next line of the completion
 
 ---@class CursortabDebugConfig
 ---@field immediate_shutdown boolean
@@ -53,6 +99,9 @@
 ---@field ui CursortabUIConfig
 ---@field behavior CursortabBehaviorConfig
 ---@field provider CursortabProviderConfig
+---@field rules CursortabRule[]
+---@field history CursortabHistoryConfig
+---@field keymaps CursortabKeymapsConfig
 ---@field debug CursortabDebugConfig
 
 -- Default configuration
@@ -88,20 +137,55 @@ local default_config = {
 	},
 
 	provider = {
-		type = "inline", -- "inline", "fim", "sweep", or "zeta"
+		type = "inline", -- "inline", "fim", "infill", "sweep", "zeta", or "nextedit"
 		url = "http://localhost:8000", -- URL of the provider server
 		model = "", -- Model name
 		temperature = 0.0, -- Sampling temperature
 		max_tokens = 512, -- Max tokens to generate
 		top_k = 50, -- Top-k sampling
+		candidates = 1, -- Completions requested per request, cycled with keymaps.next_candidate (> 1 turns off streaming)
 		completion_timeout = 5000, -- Timeout in ms for completion requests
+		max_concurrent = 0, -- Requests sent to the provider server at once (0 = no limit)
+		max_retries = 2, -- Retries of requests the server turns away with 429 or 503
+		circuit_breaker = {
+			failures = 5, -- Consecutive failures that stop requests to the server (0 = off)
+			cooldown = 10000, -- Time in ms requests stay stopped before the server is probed
+		},
 		max_diff_history_tokens = 512, -- Max tokens for diff history (0 = no limit)
+		diff_history_files = 1, -- Recently edited files included in diff history (1 = current file only)
+		context_buffers = 0, -- Other open buffers whose text is sent as context (infill provider, 0 = none)
 		completion_path = "/v1/completions", -- API endpoint path
+		api = "completions", -- Wire format: "completions", "chat" (use with completion_path = "/v1/chat/completions"), "fim" (with "/v1/fim/completions") or "ollama"
+		headers = {}, -- Extra HTTP headers (e.g., { ["OpenAI-Organization"] = "org-id" })
+		api_key_env = nil, -- Environment variable holding the API key
+		api_key_file = nil, -- File holding the API key
 		fim_tokens = { -- FIM tokens (for FIM provider)
 			prefix = "<|fim_prefix|>",
 			suffix = "<|fim_suffix|>",
 			middle = "<|fim_middle|>",
 		},
+		fim_mode = "tokens", -- "tokens" joins prefix and suffix with fim_tokens, "server" sends them as prompt and suffix
+		keep_alive = nil, -- How long Ollama keeps the model loaded after a request (e.g., "30m"; api = "ollama" only)
+		sampling = {}, -- Extra sampling parameters (e.g., { repetition_penalty = 1.1, min_p = 0.05 })
+		extra_body = {}, -- Fields merged into every request body (e.g., { cache_prompt = false })
+		cursor_target_marker = "", -- Output line prefix a next-edit model names its next edit location with ("" = off)
+		fallbacks = {}, -- Fallback providers (e.g., { { type = "sweep", url = "http://gpu-box:8000" } }), unset fields inherit from above
+		fallback_timeout = 0, -- Max ms to wait for a provider's first output before trying the next (0 = no limit)
+	},
+
+	rules = {}, -- Per-file provider overrides, first match wins (e.g., { { filetype = "markdown", provider = "inline" } })
+
+	history = {
+		persist = false, -- Keep edit history on disk so it survives daemon restarts
+		dir = nil, -- History directory (nil = stdpath("cache") .. "/cursortab/history")
+		max_size_mb = 16, -- Size cap for the stored history
+	},
+
+	keymaps = {
+		next_candidate = false, -- Show the next completion candidate (e.g., "<M-]>"; false = unmapped)
+		prev_candidate = false, -- Show the previous completion candidate (e.g., "<M-[>"; false = unmapped)
+		accept_word = false, -- Accept the next word of the completion (e.g., "<M-Right>"; false = unmapped)
+		accept_line = false, -- Accept the next line of the completion (e.g., "<M-C-Right>"; false = unmapped)
 	},
 
 	debug = {
@@ -249,7 +333,9 @@ local function migrate_deprecated_config(user_config)
 end
 
 -- Valid values for enum-like config options
-local valid_provider_types = { inline = true, fim = true, sweep = true, zeta = true }
+local valid_provider_types = { inline = true, fim = true, infill = true, sweep = true, zeta = true, nextedit = true }
+local valid_apis = { completions = true, chat = true, fim = true, ollama = true }
+local valid_fim_modes = { tokens = true, server = true }
 local valid_log_levels = { trace = true, debug = true, info = true, warn = true, error = true }
 
 -- Validate configuration values
@@ -259,7 +345,7 @@ local function validate_config(cfg)
 	if cfg.provider and cfg.provider.type then
 		if not valid_provider_types[cfg.provider.type] then
 			error(string.format(
-				"[cursortab.nvim] Invalid provider.type '%s'. Must be one of: inline, fim, sweep, zeta",
+				"[cursortab.nvim] Invalid provider.type '%s'. Must be one of: inline, fim, infill, sweep, zeta, nextedit",
 				cfg.provider.type
 			))
 		end
@@ -293,6 +379,33 @@ local function validate_config(cfg)
 		if cfg.provider.max_diff_history_tokens and cfg.provider.max_diff_history_tokens < 0 then
 			error("[cursortab.nvim] provider.max_diff_history_tokens must be >= 0")
 		end
+		if cfg.provider.diff_history_files and cfg.provider.diff_history_files < 1 then
+			error("[cursortab.nvim] provider.diff_history_files must be >= 1")
+		end
+		if cfg.provider.context_buffers and cfg.provider.context_buffers < 0 then
+			error("[cursortab.nvim] provider.context_buffers must be >= 0")
+		end
+		if cfg.provider.candidates and cfg.provider.candidates < 1 then
+			error("[cursortab.nvim] provider.candidates must be >= 1")
+		end
+		if cfg.provider.max_concurrent and cfg.provider.max_concurrent < 0 then
+			error("[cursortab.nvim] provider.max_concurrent must be >= 0")
+		end
+		if cfg.provider.max_retries and cfg.provider.max_retries < 0 then
+			error("[cursortab.nvim] provider.max_retries must be >= 0")
+		end
+		local breaker = cfg.provider.circuit_breaker
+		if breaker ~= nil then
+			if type(breaker) ~= "table" then
+				error("[cursortab.nvim] provider.circuit_breaker must be a table with failures and cooldown fields")
+			end
+			if breaker.failures and breaker.failures < 0 then
+				error("[cursortab.nvim] provider.circuit_breaker.failures must be >= 0")
+			end
+			if breaker.cooldown and breaker.cooldown < 0 then
+				error("[cursortab.nvim] provider.circuit_breaker.cooldown must be >= 0")
+			end
+		end
 		if cfg.provider.max_context_tokens ~= nil then
 			vim.schedule(function()
 				vim.notify(
@@ -305,6 +418,116 @@ local function validate_config(cfg)
 		if cfg.provider.completion_path and not cfg.provider.completion_path:match("^/") then
 			error("[cursortab.nvim] provider.completion_path must start with '/'")
 		end
+		if cfg.provider.api and not valid_apis[cfg.provider.api] then
+			error(string.format(
+				"[cursortab.nvim] Invalid provider.api '%s'. Must be one of: completions, chat, fim, ollama",
+				cfg.provider.api
+			))
+		end
+		if cfg.provider.headers ~= nil then
+			if type(cfg.provider.headers) ~= "table" then
+				error("[cursortab.nvim] provider.headers must be a table of header names to values")
+			end
+			for name, value in pairs(cfg.provider.headers) do
+				if type(name) ~= "string" or type(value) ~= "string" then
+					error("[cursortab.nvim] provider.headers keys and values must be strings")
+				end
+			end
+		end
+		if cfg.provider.api_key_env ~= nil and cfg.provider.api_key_file ~= nil then
+			error("[cursortab.nvim] provider.api_key_env and provider.api_key_file are mutually exclusive")
+		end
+		if cfg.provider.fallback_timeout and cfg.provider.fallback_timeout < 0 then
+			error("[cursortab.nvim] provider.fallback_timeout must be >= 0")
+		end
+		if cfg.provider.fallbacks ~= nil then
+			local fallbacks = cfg.provider.fallbacks
+			if type(fallbacks) ~= "table" or #fallbacks ~= vim.tbl_count(fallbacks) then
+				error("[cursortab.nvim] provider.fallbacks must be a list of provider tables")
+			end
+			for i, fallback in ipairs(fallbacks) do
+				if type(fallback) ~= "table" then
+					error(string.format("[cursortab.nvim] provider.fallbacks[%d] must be a table", i))
+				end
+				if fallback.type and not valid_provider_types[fallback.type] then
+					error(string.format(
+						"[cursortab.nvim] Invalid provider.fallbacks[%d].type '%s'. Must be one of: inline, fim, infill, sweep, zeta, nextedit",
+						i,
+						fallback.type
+					))
+				end
+				if fallback.api and not valid_apis[fallback.api] then
+					error(string.format(
+						"[cursortab.nvim] Invalid provider.fallbacks[%d].api '%s'. Must be one of: completions, chat, fim, ollama",
+						i,
+						fallback.api
+					))
+				end
+				if fallback.completion_path and not fallback.completion_path:match("^/") then
+					error(string.format("[cursortab.nvim] provider.fallbacks[%d].completion_path must start with '/'", i))
+				end
+				if fallback.api_key_env ~= nil and fallback.api_key_file ~= nil then
+					error(string.format(
+						"[cursortab.nvim] provider.fallbacks[%d].api_key_env and api_key_file are mutually exclusive",
+						i
+					))
+				end
+				if fallback.fallbacks ~= nil then
+					error(string.format("[cursortab.nvim] provider.fallbacks[%d].fallbacks: fallbacks cannot be nested", i))
+				end
+			end
+		end
+		if cfg.provider.keep_alive ~= nil and type(cfg.provider.keep_alive) ~= "string" then
+			error('[cursortab.nvim] provider.keep_alive must be a duration string (e.g., "30m")')
+		end
+		local sampling = cfg.provider.sampling
+		if sampling ~= nil then
+			if type(sampling) ~= "table" then
+				error("[cursortab.nvim] provider.sampling must be a table of sampling parameters")
+			end
+			if sampling.min_p and (sampling.min_p < 0 or sampling.min_p > 1) then
+				error("[cursortab.nvim] provider.sampling.min_p must be between 0 and 1")
+			end
+			if sampling.repetition_penalty and sampling.repetition_penalty <= 0 then
+				error("[cursortab.nvim] provider.sampling.repetition_penalty must be > 0")
+			end
+			if sampling.presence_penalty and (sampling.presence_penalty < -2 or sampling.presence_penalty > 2) then
+				error("[cursortab.nvim] provider.sampling.presence_penalty must be between -2 and 2")
+			end
+			if sampling.seed and sampling.seed ~= math.floor(sampling.seed) then
+				error("[cursortab.nvim] provider.sampling.seed must be an integer")
+			end
+			if sampling.grammar ~= nil and type(sampling.grammar) ~= "string" then
+				error("[cursortab.nvim] provider.sampling.grammar must be a string")
+			end
+			if sampling.logit_bias ~= nil then
+				if type(sampling.logit_bias) ~= "table" then
+					error("[cursortab.nvim] provider.sampling.logit_bias must be a table of token IDs to biases")
+				end
+				for token, bias in pairs(sampling.logit_bias) do
+					if not tonumber(token) or type(bias) ~= "number" or bias < -100 or bias > 100 then
+						error("[cursortab.nvim] provider.sampling.logit_bias must map token IDs to biases between -100 and 100")
+					end
+				end
+			end
+		end
+		if cfg.provider.extra_body ~= nil then
+			if type(cfg.provider.extra_body) ~= "table" then
+				error("[cursortab.nvim] provider.extra_body must be a table of request body fields")
+			end
+			if cfg.provider.extra_body.stream ~= nil then
+				error("[cursortab.nvim] provider.extra_body.stream cannot be set; streaming is chosen by the provider")
+			end
+		end
+		if cfg.provider.fim_mode and not valid_fim_modes[cfg.provider.fim_mode] then
+			error(string.format(
+				"[cursortab.nvim] Invalid provider.fim_mode '%s'. Must be one of: tokens, server",
+				cfg.provider.fim_mode
+			))
+		end
+		if cfg.provider.fim_mode == "server" and cfg.provider.api == "chat" then
+			error('[cursortab.nvim] provider.fim_mode "server" needs api completions, fim, or ollama')
+		end
 		if cfg.provider.fim_tokens ~= nil then
 			if type(cfg.provider.fim_tokens) ~= "table" then
 				error("[cursortab.nvim] provider.fim_tokens must be a table with prefix, suffix, and middle fields")
@@ -321,6 +544,53 @@ local function validate_config(cfg)
 			end
 		end
 	end
+
+	-- Validate history settings
+	if cfg.history then
+		if cfg.history.max_size_mb and cfg.history.max_size_mb <= 0 then
+			error("[cursortab.nvim] history.max_size_mb must be > 0")
+		end
+		if cfg.history.dir ~= nil and type(cfg.history.dir) ~= "string" then
+			error("[cursortab.nvim] history.dir must be a string")
+		end
+	end
+
+	-- Validate keymaps
+	if cfg.keymaps then
+		for name, lhs in pairs(cfg.keymaps) do
+			if lhs ~= false and type(lhs) ~= "string" then
+				error(string.format("[cursortab.nvim] keymaps.%s must be a key sequence or false", name))
+			end
+		end
+	end
+
+	-- Validate per-file rules
+	if cfg.rules ~= nil then
+		if type(cfg.rules) ~= "table" or #cfg.rules ~= vim.tbl_count(cfg.rules) then
+			error("[cursortab.nvim] rules must be a list of rule tables")
+		end
+		for i, rule in ipairs(cfg.rules) do
+			if type(rule) ~= "table" then
+				error(string.format("[cursortab.nvim] rules[%d] must be a table", i))
+			end
+			if rule.pattern == nil and rule.filetype == nil then
+				error(string.format("[cursortab.nvim] rules[%d] needs a pattern or a filetype", i))
+			end
+			if not rule.disabled then
+				local rule_type = type(rule.provider) == "table" and rule.provider.type or rule.provider
+				if rule.provider == nil then
+					error(string.format("[cursortab.nvim] rules[%d] needs a provider unless disabled = true", i))
+				end
+				if rule_type ~= nil and not valid_provider_types[rule_type] then
+					error(string.format(
+						"[cursortab.nvim] Invalid rules[%d].provider type '%s'. Must be one of: inline, fim, infill, sweep, zeta, nextedit",
+						i,
+						tostring(rule_type)
+					))
+				end
+			end
+		end
+	end
 end
 
 ---@class ConfigModule
@@ -18,6 +18,148 @@ local function is_process_running(pid)
 	return vim.v.shell_error == 0
 end
 
+-- Fill the fields a provider override leaves unset from the base provider.
+-- Fallbacks are never inherited.
+---@param base CursortabProviderConfig
+---@param override table
+---@return CursortabProviderConfig
+local function inherit_provider(base, override)
+	local merged = vim.tbl_extend("force", base, { fallbacks = {}, fallback_timeout = 0 })
+	if override.api_key_env ~= nil or override.api_key_file ~= nil then
+		-- An override with its own key source replaces the base's
+		merged.api_key_env, merged.api_key_file = nil, nil
+	end
+	return vim.tbl_deep_extend("force", merged, override)
+end
+
+-- Build the daemon-side sampling config (matches Go SamplingConfig struct).
+-- Token IDs may be given as numbers, but JSON object keys must be strings.
+---@param s CursortabSamplingConfig|nil
+---@return table
+local function sampling_payload(s)
+	local payload = vim.tbl_extend("force", vim.empty_dict(), s or {})
+	if s and s.logit_bias and not vim.tbl_isempty(s.logit_bias) then
+		local logit_bias = vim.empty_dict()
+		for token, bias in pairs(s.logit_bias) do
+			logit_bias[tostring(token)] = bias
+		end
+		payload.logit_bias = logit_bias
+	else
+		payload.logit_bias = nil
+	end
+	return payload
+end
+
+-- Build the daemon-side provider config (matches Go ProviderConfig struct)
+---@param p CursortabProviderConfig
+---@return table
+local function provider_payload(p)
+	local fallbacks = {}
+	for _, fallback in ipairs(p.fallbacks or {}) do
+		table.insert(fallbacks, provider_payload(inherit_provider(p, fallback)))
+	end
+
+	return {
+		type = p.type,
+		url = p.url,
+		model = p.model,
+		temperature = p.temperature,
+		max_tokens = p.max_tokens,
+		top_k = p.top_k,
+		candidates = p.candidates,
+		completion_timeout = p.completion_timeout,
+		max_concurrent = p.max_concurrent,
+		max_retries = p.max_retries,
+		circuit_breaker = p.circuit_breaker,
+		max_diff_history_tokens = p.max_diff_history_tokens,
+		diff_history_files = p.diff_history_files,
+		context_buffers = p.context_buffers,
+		completion_path = p.completion_path,
+		api = p.api,
+		-- Empty Lua tables encode as JSON arrays; the daemon expects an object
+		headers = vim.tbl_isempty(p.headers or {}) and vim.empty_dict() or p.headers,
+		api_key_env = p.api_key_env,
+		api_key_file = p.api_key_file and vim.fn.expand(p.api_key_file) or nil,
+		fim_tokens = p.fim_tokens,
+		fim_mode = p.fim_mode,
+		keep_alive = p.keep_alive,
+		sampling = sampling_payload(p.sampling),
+		extra_body = vim.tbl_isempty(p.extra_body or {}) and vim.empty_dict() or p.extra_body,
+		cursor_target_marker = p.cursor_target_marker,
+		fallbacks = fallbacks,
+		fallback_timeout = p.fallback_timeout,
+	}
+end
+
+-- Build the daemon-side per-file rules (matches Go ProviderRuleConfig struct).
+-- Rule providers inherit every field they don't set from the main provider.
+---@param rules CursortabRule[]
+---@param p CursortabProviderConfig
+---@return table
+local function rules_payload(rules, p)
+	local payload = {}
+	for _, rule in ipairs(rules or {}) do
+		local entry = {
+			pattern = rule.pattern,
+			filetype = rule.filetype,
+			disabled = rule.disabled == true,
+		}
+		if not entry.disabled then
+			local override = type(rule.provider) == "string" and { type = rule.provider } or rule.provider or {}
+			entry.provider = provider_payload(inherit_provider(p, override))
+		end
+		table.insert(payload, entry)
+	end
+	return payload
+end
+
+-- Build the JSON configuration (matches Go Config struct).
+-- Note: UI config is Lua-only (for highlights), not sent to Go daemon
+---@return string
+local function config_json()
+	local cfg = config.get()
+	return vim.json.encode({
+		ns_id = ns_id,
+		log_level = cfg.log_level,
+		behavior = {
+			idle_completion_delay = cfg.behavior.idle_completion_delay,
+			text_change_debounce = cfg.behavior.text_change_debounce,
+			cursor_prediction = {
+				enabled = cfg.behavior.cursor_prediction.enabled,
+				auto_advance = cfg.behavior.cursor_prediction.auto_advance,
+				proximity_threshold = cfg.behavior.cursor_prediction.proximity_threshold,
+			},
+		},
+		provider = provider_payload(cfg.provider),
+		rules = rules_payload(cfg.rules, cfg.provider),
+		history = {
+			persist = cfg.history.persist,
+			dir = vim.fn.fnamemodify(vim.fn.expand(cfg.history.dir or (vim.fn.stdpath("cache") .. "/cursortab/history")), ":p"),
+			max_size_mb = cfg.history.max_size_mb,
+		},
+		debug = {
+			immediate_shutdown = cfg.debug.immediate_shutdown,
+		},
+	})
+end
+
+-- Send this client's config to the daemon. The daemon takes the client's
+-- namespace from it, and the reply lists the settings an already running
+-- daemon was started with differently, which are warned about.
+---@param json_config string
+local function check_config(json_config)
+	local ok, diff = pcall(vim.fn.rpcrequest, chan, "cursortab_check_config", json_config)
+	if not ok or type(diff) ~= "table" or #diff == 0 then
+		return
+	end
+	vim.notify(
+		"cursortab daemon is running with a different config ("
+			.. table.concat(diff, ", ")
+			.. ").\nRun :CursortabReload to apply this config.",
+		vim.log.levels.WARN
+	)
+end
+
 -- Start the daemon process
 local function start_daemon()
 	local plugin_dir = vim.fn.fnamemodify(debug.getinfo(1, "S").source:sub(2), ":h:h:h")
@@ -43,38 +185,7 @@ local function start_daemon()
 		return false
 	end
 
-	-- Create JSON configuration (matches Go Config struct)
-	-- Note: UI config is Lua-only (for highlights), not sent to Go daemon
-	local cfg = config.get()
-	local json_config = vim.json.encode({
-		ns_id = ns_id,
-		log_level = cfg.log_level,
-		behavior = {
-			idle_completion_delay = cfg.behavior.idle_completion_delay,
-			text_change_debounce = cfg.behavior.text_change_debounce,
-			cursor_prediction = {
-				enabled = cfg.behavior.cursor_prediction.enabled,
-				auto_advance = cfg.behavior.cursor_prediction.auto_advance,
-				proximity_threshold = cfg.behavior.cursor_prediction.proximity_threshold,
-			},
-		},
-		provider = {
-			type = cfg.provider.type,
-			url = cfg.provider.url,
-			model = cfg.provider.model,
-			temperature = cfg.provider.temperature,
-			max_tokens = cfg.provider.max_tokens,
-			top_k = cfg.provider.top_k,
-			completion_timeout = cfg.provider.completion_timeout,
-			max_diff_history_tokens = cfg.provider.max_diff_history_tokens,
-			completion_path = cfg.provider.completion_path,
-			fim_tokens = cfg.provider.fim_tokens,
-		},
-		debug = {
-			immediate_shutdown = cfg.debug.immediate_shutdown,
-		},
-	})
-
+	local json_config = config_json()
 	local env = vim.fn.environ()
 	env.CURSORTAB_CONFIG = json_config
 
@@ -128,6 +239,10 @@ local function start_daemon()
 		env = env,
 	})
 
+	if chan > 0 then
+		check_config(json_config)
+	end
+
 	return chan > 0
 end
 
@@ -322,6 +437,73 @@ function daemon.stop_daemon()
 	return true, "Daemon stopped (forced kill after timeout)"
 end
 
+-- Send the current config to the running daemon
+---@return boolean success
+---@return string message
+function daemon.reload_config()
+	if not chan or chan <= 0 then
+		if not start_daemon() then
+			return false, "Failed to connect to cursortab daemon"
+		end
+	end
+
+	local ok, err = pcall(vim.fn.rpcrequest, chan, "cursortab_reload_config", config_json())
+	if not ok then
+		return false, "Config reload failed: " .. tostring(err)
+	end
+	return true, "Cursortab config reloaded"
+end
+
+-- Fetch the circuit breaker state of each provider server from the daemon.
+-- Does not start the daemon.
+---@return table[]|nil providers
+---@return string|nil error
+function daemon.get_provider_status()
+	if not chan or chan <= 0 then
+		return nil, "Not connected to the daemon"
+	end
+
+	local ok, providers = pcall(vim.fn.rpcrequest, chan, "cursortab_status")
+	if not ok then
+		return nil, "Could not fetch provider status: " .. tostring(providers)
+	end
+	return providers, nil
+end
+
+-- Fetch the completion metrics recorded by the daemon
+---@return table|nil stats
+---@return string|nil error
+function daemon.get_stats()
+	if not chan or chan <= 0 then
+		if not start_daemon() then
+			return nil, "Failed to connect to cursortab daemon"
+		end
+	end
+
+	local ok, stats = pcall(vim.fn.rpcrequest, chan, "cursortab_stats")
+	if not ok then
+		return nil, "Could not fetch stats: " .. tostring(stats)
+	end
+	return stats, nil
+end
+
+-- Discard the completion metrics recorded by the daemon
+---@return boolean success
+---@return string message
+function daemon.reset_stats()
+	if not chan or chan <= 0 then
+		if not start_daemon() then
+			return false, "Failed to connect to cursortab daemon"
+		end
+	end
+
+	local ok, err = pcall(vim.fn.rpcrequest, chan, "cursortab_reset_stats")
+	if not ok then
+		return false, "Could not reset stats: " .. tostring(err)
+	end
+	return true, "Cursortab stats reset"
+end
+
 -- Force start daemon (for use after stop_daemon)
 function daemon.force_start()
 	return start_daemon()
@@ -1,6 +1,7 @@
 -- Event handling and autocommands for cursortab.nvim
 
 local buffer = require("cursortab.buffer")
+local config = require("cursortab.config")
 local daemon = require("cursortab.daemon")
 local ui = require("cursortab.ui")
 
@@ -41,6 +42,78 @@ local function on_tab()
 	end
 end
 
+-- What a key cursortab maps in mode does when no completion is shown: the
+-- global mapping it replaced, looked up before mapping it, or else the key
+-- itself. Called from expr mappings, so it returns the keys to insert.
+---@param mode string
+---@param lhs string
+---@return fun(): string
+local function key_fallback(mode, lhs)
+	local existing = vim.fn.maparg(lhs, mode, false, true)
+	if vim.tbl_isempty(existing) or existing.buffer == 1 then
+		local keys = vim.api.nvim_replace_termcodes(lhs, true, false, true)
+		return function()
+			return keys
+		end
+	end
+
+	return function()
+		local rhs = existing.rhs
+		if existing.callback then
+			if existing.expr ~= 1 then
+				-- Text is locked while an expr mapping is evaluated
+				vim.schedule(existing.callback)
+				return ""
+			end
+			rhs = existing.callback()
+		elseif existing.expr == 1 then
+			rhs = vim.api.nvim_eval((rhs:gsub("<[Ss][Ii][Dd]>", "<SNR>" .. existing.sid .. "_")))
+		end
+		if type(rhs) ~= "string" or rhs == "" then
+			return ""
+		end
+		if existing.callback == nil or existing.replace_keycodes == 1 then
+			rhs = vim.api.nvim_replace_termcodes(rhs, true, false, true)
+		end
+		vim.api.nvim_feedkeys(rhs, existing.noremap == 1 and "n" or "m", false)
+		return ""
+	end
+end
+
+-- Candidate cycling key handler: cycles while a completion is shown,
+-- otherwise the key keeps its usual meaning
+---@param event_name string
+---@param fallback fun(): string
+---@return fun(): string
+local function on_cycle(event_name, fallback)
+	return function()
+		if ui.has_cursor_prediction() or ui.has_completion() then
+			daemon.send_event_immediate(event_name)
+			return ""
+		end
+		return fallback()
+	end
+end
+
+-- Partial accept key handler: applies part of the completion on screen,
+-- otherwise the key keeps its usual meaning
+---@param event_name string
+---@param fallback fun(): string
+---@return fun(): string
+local function on_partial_accept(event_name, fallback)
+	return function()
+		if ui.has_completion() then
+			-- Suppress the immediate text change and cursor movement caused by applying the text
+			skip_next_text_changed = true
+			skip_next_cursor_moved = true
+			-- Not debounced so each key press accepts another word or line
+			daemon.send_event_immediate(event_name)
+			return ""
+		end
+		return fallback()
+	end
+end
+
 -- Escape key handler
 ---@return string
 local function on_escape()
@@ -141,6 +214,23 @@ function events.setup()
 	vim.keymap.set("n", "<Tab>", on_tab, { noremap = true, silent = true, expr = true })
 	vim.keymap.set("n", "<Esc>", on_escape, { noremap = true, silent = true, expr = true })
 
+	local keymaps = config.get().keymaps
+	local keymap_opts = { noremap = true, silent = true, expr = true }
+	for event_name, lhs in pairs({ next_candidate = keymaps.next_candidate, prev_candidate = keymaps.prev_candidate }) do
+		if lhs then
+			for _, mode in ipairs({ "i", "n" }) do
+				vim.keymap.set(mode, lhs, on_cycle(event_name, key_fallback(mode, lhs)), keymap_opts)
+			end
+		end
+	end
+	for event_name, lhs in pairs({ accept_word = keymaps.accept_word, accept_line = keymaps.accept_line }) do
+		if lhs then
+			for _, mode in ipairs({ "i", "n" }) do
+				vim.keymap.set(mode, lhs, on_partial_accept(event_name, key_fallback(mode, lhs)), keymap_opts)
+			end
+		end
+	end
+
 	-- Set up autocommand to close completions/predictions on certain events
 	vim.api.nvim_create_autocmd({ "ModeChanged", "CmdlineEnter", "CmdwinEnter", "BufEnter" }, {
 		callback = function(args)
@@ -28,6 +28,13 @@ function M.on_cursor_prediction_ready(line_num)
 	ui.show_cursor_prediction(line_num)
 end
 
+---RPC callback: called when a cursor prediction into another file is ready
+---@param path string File path relative to the workspace
+---@param line_num integer Predicted line number (1-indexed)
+function M.on_file_prediction_ready(path, line_num)
+	ui.show_file_prediction(path, line_num)
+end
+
 -- Public API functions for users
 
 ---Toggle cursortab functionality on/off
@@ -119,6 +126,28 @@ function M.status()
 		table.insert(status_lines, "  • Channel ID: " .. channel_status.channel_id)
 	end
 
+	local providers = channel_status.connected and daemon.get_provider_status() or nil
+	if providers and #providers > 0 then
+		table.insert(status_lines, "")
+		table.insert(status_lines, "Provider Servers:")
+		for _, p in ipairs(providers) do
+			local breaker = p.breaker or {}
+			local state
+			if breaker.state == "open" then
+				state = string.format(
+					"✗ Unreachable (%d failures), next attempt in %ds",
+					breaker.failures or 0,
+					math.ceil((breaker.retry_in_ms or 0) / 1000)
+				)
+			elseif breaker.state == "half_open" then
+				state = "… Probing"
+			else
+				state = "✓ OK"
+			end
+			table.insert(status_lines, string.format("  • %s (%s): %s", p.name, p.url, state))
+		end
+	end
+
 	-- Create scratch window using UI module
 	ui.create_scratch_window("Cursortab Status", status_lines, {
 		size_mode = "fit_content",
@@ -127,6 +156,134 @@ function M.status()
 	vim.notify("Cursortab status displayed", vim.log.levels.INFO)
 end
 
+---Format the latency quantile q of a histogram from the daemon
+---@param hist table Histogram with counts per bucket
+---@param bounds integer[] Bucket upper bounds in milliseconds
+---@param q number Quantile between 0 and 1
+---@return string
+local function format_quantile(hist, bounds, q)
+	local target = math.ceil(hist.count * q)
+	local seen = 0
+	for i, count in ipairs(hist.counts) do
+		seen = seen + count
+		if seen >= target then
+			if bounds[i] then
+				return "≤" .. bounds[i] .. "ms"
+			end
+			return ">" .. bounds[#bounds] .. "ms"
+		end
+	end
+	return "-"
+end
+
+---Format a latency histogram from the daemon
+---@param hist table
+---@param bounds integer[]
+---@return string
+local function format_latency(hist, bounds)
+	if type(hist) ~= "table" or not hist.count or hist.count == 0 then
+		return "no data"
+	end
+	return string.format(
+		"p50 %s, p90 %s, avg %dms (%d)",
+		format_quantile(hist, bounds, 0.5),
+		format_quantile(hist, bounds, 0.9),
+		math.floor(hist.sum_ms / hist.count),
+		hist.count
+	)
+end
+
+---Format completion outcomes from the daemon
+---@param o table
+---@return string
+local function format_outcomes(o)
+	local rate = "-"
+	if o.shown > 0 then
+		rate = string.format("%d%%", math.floor((o.accepted + o.typed) * 100 / o.shown + 0.5))
+	end
+	return string.format(
+		"shown %d, accepted %d, typed %d, rejected %d (acceptance %s)",
+		o.shown,
+		o.accepted,
+		o.typed,
+		o.rejected,
+		rate
+	)
+end
+
+---Return the keys of a table in sorted order
+---@param t table
+---@return string[]
+local function sorted_keys(t)
+	local keys = {}
+	for key in pairs(type(t) == "table" and t or {}) do
+		table.insert(keys, key)
+	end
+	table.sort(keys)
+	return keys
+end
+
+---Show completion metrics in a floating window, or reset them
+---@param action string|nil "reset" to discard the recorded metrics
+function M.stats(action)
+	if action == "reset" then
+		local ok, message = daemon.reset_stats()
+		vim.notify(message, ok and vim.log.levels.INFO or vim.log.levels.ERROR)
+		return
+	end
+	if action then
+		vim.notify("Unknown CursortabStats argument: " .. action, vim.log.levels.ERROR)
+		return
+	end
+
+	local stats, err = daemon.get_stats()
+	if not stats then
+		vim.notify(err, vim.log.levels.ERROR)
+		return
+	end
+
+	local bounds = stats.buckets_ms or {}
+	local lines = {
+		"Completion stats since " .. os.date("%Y-%m-%d %H:%M", stats.since),
+		"",
+		"Providers:",
+	}
+
+	local providers = sorted_keys(stats.providers)
+	if #providers == 0 then
+		table.insert(lines, "  No completions recorded yet")
+	end
+	for _, name in ipairs(providers) do
+		local p = stats.providers[name]
+		table.insert(lines, "  " .. name)
+		table.insert(lines, string.format("    • Requests: %d (%d errors, %d from cache)", p.requests, p.errors, p.cache_hits or 0))
+		table.insert(lines, "    • First line: " .. format_latency(p.first_line, bounds))
+		table.insert(lines, "    • Completion: " .. format_latency(p.total, bounds))
+		table.insert(lines, "    • Outcomes: " .. format_outcomes(p.outcomes))
+	end
+
+	if type(stats.stages) == "table" and #stats.stages > 0 then
+		table.insert(lines, "")
+		table.insert(lines, "By stage:")
+		for i, o in ipairs(stats.stages) do
+			table.insert(lines, string.format("  • Stage %d: %s", i, format_outcomes(o)))
+		end
+	end
+
+	local file_types = sorted_keys(stats.file_types)
+	if #file_types > 0 then
+		table.insert(lines, "")
+		table.insert(lines, "By filetype:")
+		for _, ft in ipairs(file_types) do
+			table.insert(lines, string.format("  • %s: %s", ft, format_outcomes(stats.file_types[ft])))
+		end
+	end
+
+	ui.create_scratch_window("Cursortab Stats", lines, {
+		size_mode = "fit_content",
+	})
+end
+
 ---Restart cursortab daemon
 function M.restart()
 	vim.notify("Restarting cursortab daemon...", vim.log.levels.INFO)
@@ -151,6 +308,16 @@ function M.restart()
 	end, 200)
 end
 
+function M.reload()
+	local ok, message = daemon.reload_config()
+	vim.notify(message, ok and vim.log.levels.INFO or vim.log.levels.ERROR)
+end
+
+---Revert the most recently accepted completion and drop it from the edit history
+function M.revert()
+	daemon.send_event_immediate("revert")
+end
+
 ---Setup cursortab with user configuration
 ---@param user_config table|nil User configuration overrides
 function M.setup(user_config)
@@ -179,6 +346,24 @@ function M.setup(user_config)
 		M.restart()
 	end, { desc = "Restart cursortab daemon" })
 
+	vim.api.nvim_create_user_command("CursortabReload", function()
+		M.reload()
+	end, { desc = "Apply the current config to the running cursortab daemon" })
+
+	vim.api.nvim_create_user_command("CursortabRevert", function()
+		M.revert()
+	end, { desc = "Revert the last accepted completion" })
+
+	vim.api.nvim_create_user_command("CursortabStats", function(opts)
+		M.stats(opts.args ~= "" and opts.args or nil)
+	end, {
+		nargs = "?",
+		complete = function()
+			return { "reset" }
+		end,
+		desc = "Show cursortab completion metrics, or reset them",
+	})
+
 	-- Setup highlight groups
 	config.setup_highlights()
 
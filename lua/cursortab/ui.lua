@@ -622,6 +622,46 @@ local function show_completion(diff_result)
 	end
 end
 
+-- Show jump text in a floating window centered at the top or bottom of win
+---@param win integer Window to place the indicator in
+---@param display_text string
+---@param at_bottom boolean
+local function open_jump_window(win, display_text, at_bottom)
+	---@type integer
+	local win_width = vim.api.nvim_win_get_width(win)
+	---@type integer
+	local win_height = vim.api.nvim_win_get_height(win)
+
+	-- Create a scratch buffer for the arrow indicator
+	absolute_jump_buf = vim.api.nvim_create_buf(false, true)
+	vim.api.nvim_buf_set_lines(absolute_jump_buf, 0, -1, false, { display_text })
+	vim.api.nvim_set_option_value("modifiable", false, { buf = absolute_jump_buf })
+
+	-- Calculate position - center horizontally, top or bottom vertically
+	---@type integer
+	local text_width = vim.fn.strdisplaywidth(display_text)
+	---@type integer
+	local col = math.max(0, math.floor((win_width - text_width) / 2))
+	---@type integer
+	local row = at_bottom and (win_height - 2) or 1 -- Bottom or top with some padding
+
+	-- Create floating window for absolute positioning
+	absolute_jump_win = vim.api.nvim_open_win(absolute_jump_buf, false, {
+		relative = "win",
+		win = win,
+		row = row,
+		col = col,
+		width = text_width,
+		height = 1,
+		style = "minimal",
+		zindex = 1,
+		focusable = false,
+	})
+
+	-- Set window background to match cursortabhl_jump_text highlight
+	vim.api.nvim_set_option_value("winhighlight", "Normal:cursortabhl_jump_text", { win = absolute_jump_win })
+end
+
 -- Function to show cursor prediction jump text (called from Go)
 ---@param line_num integer Predicted line number (1-indexed)
 local function show_cursor_prediction(line_num)
@@ -679,11 +719,6 @@ local function show_cursor_prediction(line_num)
 		jump_text_buf = current_buf
 	else
 		-- Line is not visible - show directional arrow with distance
-		---@type integer
-		local win_width = vim.api.nvim_win_get_width(current_win)
-		---@type integer
-		local win_height = vim.api.nvim_win_get_height(current_win)
-
 		-- Determine direction and calculate distance
 		---@type boolean
 		local is_below = nvim_line_num > last_visible_line
@@ -697,35 +732,25 @@ local function show_cursor_prediction(line_num)
 			display_text = display_text .. "(" .. distance .. " lines) "
 		end
 
-		-- Create a scratch buffer for the arrow indicator
-		absolute_jump_buf = vim.api.nvim_create_buf(false, true)
-		vim.api.nvim_buf_set_lines(absolute_jump_buf, 0, -1, false, { display_text })
-		vim.api.nvim_set_option_value("modifiable", false, { buf = absolute_jump_buf })
+		open_jump_window(current_win, display_text, is_below)
+	end
+end
 
-		-- Calculate position - center horizontally, top or bottom vertically
-		---@type integer
-		local text_width = vim.fn.strdisplaywidth(display_text)
-		---@type integer
-		local col = math.max(0, math.floor((win_width - text_width) / 2))
-		---@type integer
-		local row = is_below and (win_height - 2) or 1 -- Bottom or top with some padding
-
-		-- Create floating window for absolute positioning
-		absolute_jump_win = vim.api.nvim_open_win(absolute_jump_buf, false, {
-			relative = "win",
-			win = current_win,
-			row = row,
-			col = col,
-			width = text_width,
-			height = 1,
-			style = "minimal",
-			zindex = 1,
-			focusable = false,
-		})
+-- Function to show a jump indicator to a line in another file (called from Go)
+---@param path string File path relative to the workspace
+---@param line_num integer Predicted line number (1-indexed)
+local function show_file_prediction(path, line_num)
+	---@type integer
+	local current_win = vim.api.nvim_get_current_win()
 
-		-- Set window background to match cursortabhl_jump_text highlight
-		vim.api.nvim_set_option_value("winhighlight", "Normal:cursortabhl_jump_text", { win = absolute_jump_win })
+	-- Don't show preview in floating windows
+	if vim.api.nvim_win_get_config(current_win).relative ~= "" then
+		return
 	end
+
+	---@type CursortabConfig
+	local cfg = config.get()
+	open_jump_window(current_win, cfg.ui.jump.text .. "→ " .. path .. ":" .. line_num .. " ", true)
 end
 
 -- Public API
@@ -753,6 +778,15 @@ function ui.show_cursor_prediction(line_num)
 	show_cursor_prediction(line_num)
 end
 
+-- Show jump text to a line in another file
+---@param path string File path relative to the workspace
+---@param line_num integer Predicted line number (1-indexed)
+function ui.show_file_prediction(path, line_num)
+	has_cursor_prediction = true
+	ui.ensure_close_all()
+	show_file_prediction(path, line_num)
+end
+
 -- Close all UI elements and reset state (for on_reject)
 function ui.close_all()
 	ui.ensure_close_all()
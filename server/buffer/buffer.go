@@ -7,14 +7,29 @@ import (
 	"encoding/json"
 	"fmt"
 	"path/filepath"
+	"slices"
 	"strings"
+	"sync/atomic"
 
 	"github.com/neovim/go-client/nvim"
 	"github.com/sergi/go-diff/diffmatchpatch"
 )
 
 type Config struct {
-	NsID int
+	NsID int // Extmark namespace of the client; each Neovim instance has its own
+}
+
+// maxAcceptedEdits is how many accepted completions can be reverted
+const maxAcceptedEdits = 10
+
+// acceptedEdit is a committed completion that can still be reverted
+type acceptedEdit struct {
+	before        []string // Buffer lines before the completion was applied
+	after         []string // Buffer lines right after it was applied
+	previousLines []string // previousLines before the commit
+	originalLines []string // originalLines before the commit
+	historyLen    int      // len(diffHistories) before the commit
+	historyEnd    int      // len(diffHistories) after the commit
 }
 
 type NvimBuffer struct {
@@ -22,9 +37,11 @@ type NvimBuffer struct {
 
 	// Private state
 	lines         []string
-	row           int // 1-indexed
-	col           int // 0-indexed
-	path          string
+	row           int    // 1-indexed
+	col           int    // 0-indexed
+	path          string // Relative to workspacePath when inside it
+	workspacePath string // Workspace root reported by the client
+	fileType      string // Neovim filetype
 	version       int
 	diffHistories []*types.DiffEntry // Structured diff history for provider consumption
 	previousLines []string           // Buffer content before the most recent edit (for sweep provider)
@@ -38,17 +55,20 @@ type NvimBuffer struct {
 	viewportTop    int // First visible line (1-indexed)
 	viewportBottom int // Last visible line (1-indexed)
 
-	config Config
+	nsID atomic.Int64 // Set from Config.NsID, updated by SetNamespace from the RPC goroutine
 
 	// Pending completion state (committed only on accept)
 	pendingStartLine        int
 	pendingEndLineInclusive int
 	pendingLines            []string
 	hasPending              bool
+
+	// Accepted completions that can be reverted, most recent last
+	accepted []*acceptedEdit
 }
 
 func New(config Config) *NvimBuffer {
-	return &NvimBuffer{
+	b := &NvimBuffer{
 		lines:                   []string{},
 		row:                     1,
 		col:                     0,
@@ -60,12 +80,19 @@ func New(config Config) *NvimBuffer {
 		lastModifiedLine:        -1,
 		id:                      nvim.Buffer(0),
 		scrollOffsetX:           0,
-		config:                  config,
 		pendingStartLine:        0,
 		pendingEndLineInclusive: 0,
 		pendingLines:            nil,
 		hasPending:              false,
 	}
+	b.nsID.Store(int64(config.NsID))
+	return b
+}
+
+// SetNamespace sets the client's extmark namespace, which is only known once
+// the client has sent its config
+func (b *NvimBuffer) SetNamespace(nsID int) {
+	b.nsID.Store(int64(nsID))
 }
 
 // SetClient stores the nvim client for all buffer operations
@@ -83,6 +110,10 @@ func (b *NvimBuffer) Col() int { return b.col }
 
 func (b *NvimBuffer) Path() string { return b.path }
 
+func (b *NvimBuffer) WorkspacePath() string { return b.workspacePath }
+
+func (b *NvimBuffer) FileType() string { return b.fileType }
+
 func (b *NvimBuffer) Version() int { return b.version }
 
 func (b *NvimBuffer) ViewportBounds() (top, bottom int) {
@@ -122,9 +153,13 @@ func (b *NvimBuffer) SetFileContext(previousLines, originalLines []string, diffH
 		b.originalLines = make([]string, len(originalLines))
 		copy(b.originalLines, originalLines)
 	}
+
+	// Accepted edits refer to the history that was just replaced
+	b.accepted = nil
 }
 
-// Sync reads current state from the editor
+// Sync reads current state from the editor. The workspace root is reported by
+// the client for the current buffer; workspacePath is used when it reports none.
 func (b *NvimBuffer) Sync(workspacePath string) (*SyncResult, error) {
 	defer logger.Trace("buffer.Sync")()
 	if b.client == nil {
@@ -141,6 +176,8 @@ func (b *NvimBuffer) Sync(workspacePath string) (*SyncResult, error) {
 	var cursor [2]int
 	var scrollOffset int
 	var viewportBounds [2]int
+	var fileType string
+	var workspaceRoot string
 
 	batch.CurrentBuffer(&currentBuf)
 	batch.BufferName(nvim.Buffer(0), &path) // Use 0 for current buffer
@@ -159,6 +196,11 @@ func (b *NvimBuffer) Sync(workspacePath string) (*SyncResult, error) {
 		return {vim.fn.line("w0"), vim.fn.line("w$")}
 	`, &viewportBounds, nil)
 
+	batch.ExecLua(`return vim.bo.filetype`, &fileType, nil)
+
+	// Root of the project the buffer belongs to (LSP root, git toplevel or cwd)
+	batch.ExecLua(`return require("cursortab.buffer").workspace_root()`, &workspaceRoot, nil)
+
 	if err := batch.Execute(); err != nil {
 		logger.Error("error executing sync batch: %v", err)
 		return nil, err
@@ -171,19 +213,26 @@ func (b *NvimBuffer) Sync(workspacePath string) (*SyncResult, error) {
 
 	// Store old path before updating
 	oldPath := b.path
+	oldWorkspace := b.workspacePath
+
+	if workspaceRoot == "" {
+		workspaceRoot = workspacePath
+	}
+	b.workspacePath = workspaceRoot
 
 	// Update buffer state
 	b.lines = linesStr
 	b.row = cursor[0]              // Line (vertical position, 1-based in nvim cursor)
 	b.col = cursor[1]              // Column (horizontal position, 0-based in nvim cursor)
 	b.scrollOffsetX = scrollOffset // Horizontal scroll offset
+	b.fileType = fileType
 
 	// Update viewport bounds (1-indexed)
 	b.viewportTop = viewportBounds[0]
 	b.viewportBottom = viewportBounds[1]
 
 	// Convert absolute path to relative workspace path
-	relativePath := makeRelativeToWorkspace(path, workspacePath)
+	relativePath := makeRelativeToWorkspace(path, workspaceRoot)
 	b.path = relativePath
 
 	// Handle buffer change
@@ -194,22 +243,105 @@ func (b *NvimBuffer) Sync(workspacePath string) (*SyncResult, error) {
 		b.id = currentBuf
 		b.lastModifiedLine = -1
 		b.version = 0
+		b.accepted = nil
 
 		return &SyncResult{
 			BufferChanged: true,
 			OldPath:       oldPath,
 			NewPath:       relativePath,
+			OldWorkspace:  oldWorkspace,
+			NewWorkspace:  workspaceRoot,
 		}, nil
 	}
 
 	// Same buffer - no change
 	return &SyncResult{
-		BufferChanged: false,
-		OldPath:       oldPath,
-		NewPath:       relativePath,
+		BufferChanged:   false,
+		OldPath:         oldPath,
+		NewPath:         relativePath,
+		OldWorkspace:    oldWorkspace,
+		NewWorkspace:    workspaceRoot,
+		HistoryReverted: b.reconcileUndo(),
 	}, nil
 }
 
+// reconcileUndo rolls back the history of accepted completions the user
+// undid in the editor, detected by the buffer being back to its content from
+// before the completion. Returns true if any history was rolled back.
+func (b *NvimBuffer) reconcileUndo() bool {
+	reverted := false
+	for len(b.accepted) > 0 {
+		edit := b.accepted[len(b.accepted)-1]
+		if len(b.diffHistories) != edit.historyEnd {
+			// History moved on (user edits were committed), nothing to match
+			b.accepted = nil
+			break
+		}
+		if !slices.Equal(b.lines, edit.before) {
+			break
+		}
+		logger.Debug("accepted completion undone, rolling back %d diff entries", edit.historyEnd-edit.historyLen)
+		b.rollback(edit)
+		reverted = true
+	}
+	return reverted
+}
+
+// rollback restores the history from before edit and drops it and any later
+// accepted edits
+func (b *NvimBuffer) rollback(edit *acceptedEdit) {
+	b.diffHistories = b.diffHistories[:edit.historyLen]
+	b.previousLines = edit.previousLines
+	b.originalLines = edit.originalLines
+	b.accepted = b.accepted[:len(b.accepted)-1]
+	b.version++
+}
+
+// RevertLastAccept restores the buffer lines from before the most recently
+// accepted completion and rolls back its history. Returns false without
+// changing anything if there is no completion to revert or the buffer has
+// changed since it was accepted.
+func (b *NvimBuffer) RevertLastAccept() (bool, error) {
+	if b.client == nil {
+		return false, fmt.Errorf("nvim client not set")
+	}
+	if len(b.accepted) == 0 {
+		return false, nil
+	}
+	edit := b.accepted[len(b.accepted)-1]
+	if len(b.diffHistories) != edit.historyEnd || !slices.Equal(b.lines, edit.after) {
+		return false, nil
+	}
+
+	// Only replace the lines the completion changed
+	start := 0
+	for start < len(edit.before) && start < len(edit.after) && edit.before[start] == edit.after[start] {
+		start++
+	}
+	endBefore, endAfter := len(edit.before), len(edit.after)
+	for endBefore > start && endAfter > start && edit.before[endBefore-1] == edit.after[endAfter-1] {
+		endBefore--
+		endAfter--
+	}
+
+	placeBytes := make([][]byte, endBefore-start)
+	for i, line := range edit.before[start:endBefore] {
+		placeBytes[i] = []byte(line)
+	}
+
+	batch := b.client.NewBatch()
+	b.clearNamespace(batch, int(b.nsID.Load()))
+	batch.SetBufferLines(b.id, start, endAfter, false, placeBytes)
+	applyCursorMove(batch, max(1, min(start+1, len(edit.before))), 0, false, false)
+	if err := batch.Execute(); err != nil {
+		return false, err
+	}
+
+	b.lines = append([]string{}, edit.before...)
+	b.rollback(edit)
+	return true, nil
+}
+
 // Helper function to convert absolute path to relative workspace path
 func makeRelativeToWorkspace(absolutePath, workspacePath string) string {
 	absolutePath = filepath.Clean(absolutePath)
@@ -217,6 +349,9 @@ func makeRelativeToWorkspace(absolutePath, workspacePath string) string {
 
 	// If the file is within the workspace, make it relative
 	if relativePath, found := strings.CutPrefix(absolutePath, workspacePath); found {
+		if !strings.HasPrefix(relativePath, string(filepath.Separator)) && !strings.HasSuffix(workspacePath, string(filepath.Separator)) {
+			return absolutePath // Sibling directory sharing a name prefix
+		}
 		relativePath = strings.TrimPrefix(relativePath, string(filepath.Separator))
 		return relativePath
 	}
@@ -301,6 +436,36 @@ func (b *NvimBuffer) PrepareCompletion(startLine, endLineInc int, lines []string
 	return &nvimBatch{batch: applyBatch}
 }
 
+// ApplyEdit replaces lines in the buffer right away, moves the cursor to
+// (row, col) and commits the edit like an accepted completion. Used to apply
+// part of a completion while the rest stays on screen.
+func (b *NvimBuffer) ApplyEdit(startLine, endLineInc int, lines []string, row, col int) error {
+	if b.client == nil {
+		return fmt.Errorf("nvim client not set")
+	}
+
+	batch := b.client.NewBatch()
+	b.clearNamespace(batch, int(b.nsID.Load()))
+
+	placeBytes := make([][]byte, len(lines))
+	for i, line := range lines {
+		placeBytes[i] = []byte(line)
+	}
+	batch.SetBufferLines(b.id, startLine-1, endLineInc, false, placeBytes)
+	applyCursorMove(batch, row, col, false, false)
+
+	if err := batch.Execute(); err != nil {
+		return err
+	}
+
+	b.pendingStartLine = startLine
+	b.pendingEndLineInclusive = endLineInc
+	b.pendingLines = append([]string{}, lines...)
+	b.hasPending = true
+	b.CommitPending()
+	return nil
+}
+
 // CommitPending applies the pending edit to buffer state, increments version,
 // and appends structured diff entries showing before/after content. No-op if no pending edit.
 func (b *NvimBuffer) CommitPending() {
@@ -318,6 +483,13 @@ func (b *NvimBuffer) CommitPending() {
 		originalRangeLines = append(originalRangeLines, b.originalLines[i-1])
 	}
 
+	edit := &acceptedEdit{
+		before:        append([]string{}, b.lines...),
+		previousLines: b.previousLines,
+		originalLines: b.originalLines,
+		historyLen:    len(b.diffHistories),
+	}
+
 	// Extract granular diffs - one DiffEntry per contiguous changed region
 	diffEntries := extractGranularDiffs(originalRangeLines, lines)
 	b.diffHistories = append(b.diffHistories, diffEntries...)
@@ -345,6 +517,14 @@ func (b *NvimBuffer) CommitPending() {
 	copy(b.lines, newLines)
 	b.version++
 
+	// Remember the commit so it can be reverted
+	edit.after = append([]string{}, newLines...)
+	edit.historyEnd = len(b.diffHistories)
+	b.accepted = append(b.accepted, edit)
+	if len(b.accepted) > maxAcceptedEdits {
+		b.accepted = b.accepted[1:]
+	}
+
 	// Clear pending
 	b.pendingStartLine = 0
 	b.pendingEndLineInclusive = 0
@@ -403,6 +583,17 @@ func (b *NvimBuffer) ShowCursorTarget(line int) error {
 	return nil
 }
 
+// ShowFileTarget displays a cursor prediction indicator for a line in
+// another file
+func (b *NvimBuffer) ShowFileTarget(path string, line int) error {
+	if b.client == nil {
+		return fmt.Errorf("nvim client not set")
+	}
+	logger.Debug("sending to lua on_file_prediction_ready: path=%s line=%d", path, line)
+	b.executeLuaFunction("require('cursortab').on_file_prediction_ready(...)", path, line)
+	return nil
+}
+
 // ClearUI clears the completion UI
 func (b *NvimBuffer) ClearUI() error {
 	if b.client == nil {
@@ -430,6 +621,18 @@ func (b *NvimBuffer) MoveCursor(line int, center bool, mark bool) error {
 	return batch.Execute()
 }
 
+// OpenFile switches to the file at path with the cursor on line. The buffer
+// is picked up as the current one on the next Sync.
+func (b *NvimBuffer) OpenFile(path string, line int) error {
+	if b.client == nil {
+		return fmt.Errorf("nvim client not set")
+	}
+
+	batch := b.client.NewBatch()
+	batch.ExecLua(`require("cursortab.buffer").open_file(...)`, nil, path, line)
+	return batch.Execute()
+}
+
 // LinterErrors retrieves Neovim diagnostics for the current buffer and returns them in provider format
 func (b *NvimBuffer) LinterErrors() *types.LinterErrors {
 	if b.client == nil {
@@ -546,6 +749,65 @@ func (b *NvimBuffer) LinterErrors() *types.LinterErrors {
 	}
 }
 
+// ContextBuffers returns the text of up to maxBuffers other open buffers,
+// maxLines lines around the last cursor position in each, most recently used first
+func (b *NvimBuffer) ContextBuffers(maxBuffers, maxLines int) []*types.FileChunk {
+	if b.client == nil {
+		return nil
+	}
+
+	var buffers []struct {
+		Name  string   `msgpack:"name"`
+		Lines []string `msgpack:"lines"`
+	}
+	batch := b.client.NewBatch()
+	batch.ExecLua(`return require("cursortab.buffer").context_buffers(...)`, &buffers, maxBuffers, maxLines)
+	if err := batch.Execute(); err != nil {
+		logger.Error("error getting context buffers: %v", err)
+		return nil
+	}
+
+	chunks := make([]*types.FileChunk, 0, len(buffers))
+	for _, buf := range buffers {
+		chunks = append(chunks, &types.FileChunk{
+			FileName: makeRelativeToWorkspace(buf.Name, b.workspacePath),
+			Text:     strings.Join(buf.Lines, "\n"),
+		})
+	}
+	return chunks
+}
+
+// FindWord returns the first whole-word use of word in each other open
+// buffer of the workspace, most recently used first. Unlike the files on
+// disk, the buffers include unsaved changes.
+func (b *NvimBuffer) FindWord(word string) []*WordUse {
+	if b.client == nil {
+		return nil
+	}
+
+	var buffers []struct {
+		Name string `msgpack:"name"`
+		Line int    `msgpack:"line"`
+		Text string `msgpack:"text"`
+	}
+	batch := b.client.NewBatch()
+	batch.ExecLua(`return require("cursortab.buffer").find_word(...)`, &buffers, word)
+	if err := batch.Execute(); err != nil {
+		logger.Error("error searching open buffers: %v", err)
+		return nil
+	}
+
+	uses := make([]*WordUse, 0, len(buffers))
+	for _, buf := range buffers {
+		uses = append(uses, &WordUse{
+			Path: makeRelativeToWorkspace(buf.Name, b.workspacePath),
+			Line: buf.Line,
+			Text: buf.Text,
+		})
+	}
+	return uses
+}
+
 // RegisterEventHandler registers a handler for nvim RPC events
 func (b *NvimBuffer) RegisterEventHandler(handler func(event string)) error {
 	if b.client == nil {
@@ -597,7 +859,7 @@ func (b *NvimBuffer) getApplyBatch(startLine, endLineInclusive int, lines []stri
 	// Create apply batch for the completion
 	applyBatch := b.client.NewBatch()
 
-	b.clearNamespace(applyBatch, b.config.NsID)
+	b.clearNamespace(applyBatch, int(b.nsID.Load()))
 
 	placeBytes := make([][]byte, len(lines))
 	for i, line := range lines {
@@ -70,6 +70,82 @@ func TestCommitUserEdits_UpdatesPreviousLines(t *testing.T) {
 
 // --- HasChanges Tests ---
 
+// acceptForTest commits a completion replacing startLine..endLineInc the way
+// an accept does, without an editor
+func acceptForTest(buf *NvimBuffer, startLine, endLineInc int, lines []string) {
+	buf.pendingStartLine = startLine
+	buf.pendingEndLineInclusive = endLineInc
+	buf.pendingLines = lines
+	buf.hasPending = true
+	buf.CommitPending()
+}
+
+func TestReconcileUndo_RollsBackHistory(t *testing.T) {
+	buf := New(Config{NsID: 1})
+	buf.lines = []string{"a", "b"}
+	buf.originalLines = []string{"a", "b"}
+	buf.diffHistories = []*types.DiffEntry{{Original: "x", Updated: "y"}}
+
+	acceptForTest(buf, 2, 2, []string{"b2", "c"})
+	assert.Len(t, 2, buf.diffHistories, "accept recorded")
+	version := buf.version
+
+	// Still showing the completion: nothing to reconcile
+	assert.False(t, buf.reconcileUndo(), "no undo")
+
+	// Undo in the editor brings back the old lines
+	buf.lines = []string{"a", "b"}
+	assert.True(t, buf.reconcileUndo(), "undo detected")
+	assert.Len(t, 1, buf.diffHistories, "accepted diff removed")
+	assert.Equal(t, "y", buf.diffHistories[0].Updated, "older history kept")
+	assert.Equal(t, []string{"a", "b"}, buf.originalLines, "checkpoint restored")
+	assert.Greater(t, buf.version, version, "version bumped")
+	assert.Len(t, 0, buf.accepted, "edit dropped")
+}
+
+func TestReconcileUndo_SeveralAccepts(t *testing.T) {
+	buf := New(Config{NsID: 1})
+	buf.lines = []string{"a"}
+	buf.originalLines = []string{"a"}
+
+	acceptForTest(buf, 1, 1, []string{"a1"})
+	acceptForTest(buf, 1, 1, []string{"a2"})
+	assert.Len(t, 2, buf.diffHistories, "two accepts")
+
+	buf.lines = []string{"a1"}
+	assert.True(t, buf.reconcileUndo(), "second accept undone")
+	assert.Len(t, 1, buf.diffHistories, "one accept left")
+
+	buf.lines = []string{"a"}
+	assert.True(t, buf.reconcileUndo(), "first accept undone")
+	assert.Len(t, 0, buf.diffHistories, "history empty")
+}
+
+func TestReconcileUndo_UserEditsCommitted(t *testing.T) {
+	buf := New(Config{NsID: 1})
+	buf.lines = []string{"a"}
+	buf.originalLines = []string{"a"}
+
+	acceptForTest(buf, 1, 1, []string{"a1"})
+	buf.lines = []string{"a1 typed"}
+	buf.CommitUserEdits()
+
+	buf.lines = []string{"a"}
+	assert.False(t, buf.reconcileUndo(), "history moved on")
+	assert.Len(t, 2, buf.diffHistories, "history kept")
+	assert.Len(t, 0, buf.accepted, "stale edits dropped")
+}
+
+func TestSetFileContext_DropsAcceptedEdits(t *testing.T) {
+	buf := New(Config{NsID: 1})
+	buf.lines = []string{"a"}
+	buf.originalLines = []string{"a"}
+	acceptForTest(buf, 1, 1, []string{"a1"})
+
+	buf.SetFileContext(nil, []string{"other"}, nil)
+	assert.Len(t, 0, buf.accepted, "edits of the previous file dropped")
+}
+
 func TestHasChanges_NoChanges(t *testing.T) {
 	buf := New(Config{NsID: 1})
 	buf.lines = []string{"line 1", "line 2", "line 3"}
@@ -230,6 +306,18 @@ func TestMakeRelativeToWorkspace(t *testing.T) {
 			workspacePath: "/home/user/project",
 			want:          "main.go",
 		},
+		{
+			name:          "sibling directory with same prefix",
+			absolutePath:  "/home/user/project-b/main.go",
+			workspacePath: "/home/user/project",
+			want:          "/home/user/project-b/main.go",
+		},
+		{
+			name:          "workspace with trailing slash",
+			absolutePath:  "/home/user/project/main.go",
+			workspacePath: "/home/user/project/",
+			want:          "main.go",
+		},
 	}
 
 	for _, tt := range tests {
@@ -10,4 +10,15 @@ type SyncResult struct {
 	BufferChanged bool
 	OldPath       string
 	NewPath       string
+	OldWorkspace  string // Workspace root OldPath is relative to
+	NewWorkspace  string // Workspace root NewPath is relative to
+
+	HistoryReverted bool // An accepted completion was undone and its history rolled back
+}
+
+// WordUse is the first use of a word in an open buffer
+type WordUse struct {
+	Path string // Relative to the workspace
+	Line int    // 1-indexed, 0 when the buffer has no use
+	Text string
 }
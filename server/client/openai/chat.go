@@ -0,0 +1,132 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"cursortab/logger"
+)
+
+// ChatMessage is a single message in a chat-completions conversation
+type ChatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// ChatCompletionRequest matches the OpenAI Chat Completion API format
+type ChatCompletionRequest struct {
+	Model       string        `json:"model"`
+	Messages    []ChatMessage `json:"messages"`
+	Temperature float64       `json:"temperature"`
+	MaxTokens   int           `json:"max_tokens"`
+	TopK        int           `json:"top_k,omitempty"`
+	Stop        []string      `json:"stop,omitempty"`
+	N           int           `json:"n"`
+	Stream      bool          `json:"stream"`
+	Sampling
+}
+
+// ChatCompletionResponse matches the OpenAI Chat Completion API response format
+type ChatCompletionResponse struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Created int64  `json:"created"`
+	Model   string `json:"model"`
+	Choices []struct {
+		Index        int         `json:"index"`
+		Message      ChatMessage `json:"message"`
+		FinishReason string      `json:"finish_reason"`
+	} `json:"choices"`
+	Usage struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+		TotalTokens      int `json:"total_tokens"`
+	} `json:"usage"`
+}
+
+// ChatClient is an OpenAI-compatible client for the chat-completions endpoint.
+// It accepts the same CompletionRequest as Client and wraps the prompt as a
+// single user message, so providers keep their text-completion prompt builders.
+type ChatClient struct {
+	*Client
+}
+
+// NewChatClient creates a new OpenAI-compatible chat-completions client
+func NewChatClient(url, completionPath string) *ChatClient {
+	return &ChatClient{Client: NewClient(url, completionPath)}
+}
+
+// toChatRequest converts a text-completion request into a chat request
+func toChatRequest(req *CompletionRequest) *ChatCompletionRequest {
+	return &ChatCompletionRequest{
+		Model:       req.Model,
+		Messages:    []ChatMessage{{Role: "user", Content: req.Prompt}},
+		Temperature: req.Temperature,
+		MaxTokens:   req.MaxTokens,
+		TopK:        req.TopK,
+		Stop:        req.Stop,
+		N:           req.N,
+		Stream:      req.Stream,
+		Sampling:    req.Sampling,
+	}
+}
+
+// DoCompletion sends a non-streaming chat request and returns the reply in
+// text-completion form (message content is exposed as choice text)
+func (c *ChatClient) DoCompletion(ctx context.Context, req *CompletionRequest) (*CompletionResponse, error) {
+	defer logger.Trace("openai.ChatClient.DoCompletion")()
+	req.Stream = false
+
+	body, err := c.doRequest(ctx, toChatRequest(req))
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeChatResponse(body)
+}
+
+// decodeChatResponse decodes a chat-completions response body into
+// text-completion form (message content is exposed as choice text)
+func decodeChatResponse(body []byte) (*CompletionResponse, error) {
+	var chatResp ChatCompletionResponse
+	if err := json.Unmarshal(body, &chatResp); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	resp := &CompletionResponse{
+		ID:      chatResp.ID,
+		Object:  chatResp.Object,
+		Created: chatResp.Created,
+		Model:   chatResp.Model,
+	}
+	for _, choice := range chatResp.Choices {
+		resp.Choices = append(resp.Choices, struct {
+			Index        int    `json:"index"`
+			Text         string `json:"text"`
+			Logprobs     any    `json:"logprobs"`
+			FinishReason string `json:"finish_reason"`
+		}{
+			Index:        choice.Index,
+			Text:         choice.Message.Content,
+			FinishReason: choice.FinishReason,
+		})
+	}
+	resp.Usage = chatResp.Usage
+
+	return resp, nil
+}
+
+// DoLineStream sends a streaming chat request and returns lines as they complete.
+// Same semantics as Client.DoLineStream; chunks are read from choices[].delta.content.
+func (c *ChatClient) DoLineStream(ctx context.Context, req *CompletionRequest, maxLines int, stopTokens []string) *LineStream {
+	req.Stream = true
+	return c.startLineStream(ctx, toChatRequest(req), maxLines, stopTokens)
+}
+
+// DoTokenStream sends a streaming chat request and emits cumulative text after each token.
+// Same semantics as Client.DoTokenStream; chunks are read from choices[].delta.content.
+func (c *ChatClient) DoTokenStream(ctx context.Context, req *CompletionRequest, maxChars int, stopTokens []string) *LineStream {
+	req.Stream = true
+	return c.startTokenStream(ctx, toChatRequest(req), maxChars, stopTokens)
+}
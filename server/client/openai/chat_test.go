@@ -0,0 +1,126 @@
+package openai
+
+import (
+	"context"
+	"cursortab/assert"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestChatDoCompletion_Success(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/chat/completions", r.URL.Path, "request path")
+
+		body, _ := io.ReadAll(r.Body)
+		var req ChatCompletionRequest
+		json.Unmarshal(body, &req)
+
+		assert.False(t, req.Stream, "Stream should be false")
+		assert.Equal(t, 1, len(req.Messages), "messages length")
+		assert.Equal(t, "user", req.Messages[0].Role, "message role")
+		assert.Equal(t, "hello", req.Messages[0].Content, "message content")
+		assert.Equal(t, []string{"</s>"}, req.Stop, "stop tokens")
+
+		w.Write([]byte(`{"id":"chat-id","choices":[{"index":0,"message":{"role":"assistant","content":"completion text"},"finish_reason":"stop"}]}`))
+	}))
+	defer server.Close()
+
+	client := NewChatClient(server.URL, "/v1/chat/completions")
+
+	resp, err := client.DoCompletion(context.Background(), &CompletionRequest{
+		Model:  "test-model",
+		Prompt: "hello",
+		Stop:   []string{"</s>"},
+	})
+
+	assert.NoError(t, err, "DoCompletion")
+	assert.Equal(t, "chat-id", resp.ID, "ID")
+	assert.Equal(t, 1, len(resp.Choices), "Choices length")
+	assert.Equal(t, "completion text", resp.Choices[0].Text, "Text")
+	assert.Equal(t, "stop", resp.Choices[0].FinishReason, "FinishReason")
+}
+
+func TestChatDoCompletion_HTTPError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	client := NewChatClient(server.URL, "/v1/chat/completions")
+
+	_, err := client.DoCompletion(context.Background(), &CompletionRequest{Prompt: "hello"})
+
+	assert.Error(t, err, "Expected error for HTTP 404")
+}
+
+func TestChatDoLineStream_DeltaContent(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var req ChatCompletionRequest
+		json.Unmarshal(body, &req)
+		assert.True(t, req.Stream, "Stream should be true")
+
+		flusher, _ := w.(http.Flusher)
+		w.Header().Set("Content-Type", "text/event-stream")
+
+		events := []string{
+			`{"id":"1","choices":[{"index":0,"delta":{"role":"assistant"}}]}`,
+			`{"id":"2","choices":[{"index":0,"delta":{"content":"line 1\nli"}}]}`,
+			`{"id":"3","choices":[{"index":0,"delta":{"content":"ne 2\n"},"finish_reason":"stop"}]}`,
+		}
+		for _, evt := range events {
+			w.Write([]byte("data: " + evt + "\n\n"))
+			flusher.Flush()
+		}
+		w.Write([]byte("data: [DONE]\n\n"))
+		flusher.Flush()
+	}))
+	defer server.Close()
+
+	client := NewChatClient(server.URL, "/v1/chat/completions")
+
+	stream := client.DoLineStream(context.Background(), &CompletionRequest{Prompt: "hello"}, 0, nil)
+
+	var lines []string
+	for line := range stream.LinesChan() {
+		lines = append(lines, line)
+	}
+	result := <-stream.DoneChan()
+
+	assert.Equal(t, []string{"line 1", "line 2"}, lines, "lines")
+	assert.Equal(t, "line 1\nline 2\n", result.Text, "result text")
+	assert.Equal(t, "stop", result.FinishReason, "finish reason")
+}
+
+func TestChatDoTokenStream_StopToken(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flusher, _ := w.(http.Flusher)
+		w.Header().Set("Content-Type", "text/event-stream")
+
+		events := []string{
+			`{"id":"1","choices":[{"index":0,"delta":{"content":"foo"}}]}`,
+			`{"id":"2","choices":[{"index":0,"delta":{"content":"bar\nbaz"}}]}`,
+		}
+		for _, evt := range events {
+			w.Write([]byte("data: " + evt + "\n\n"))
+			flusher.Flush()
+		}
+	}))
+	defer server.Close()
+
+	client := NewChatClient(server.URL, "/v1/chat/completions")
+
+	stream := client.DoTokenStream(context.Background(), &CompletionRequest{Prompt: "hello"}, 0, []string{"\n"})
+
+	var emitted []string
+	for text := range stream.LinesChan() {
+		emitted = append(emitted, text)
+	}
+	result := <-stream.DoneChan()
+
+	assert.Equal(t, []string{"foo", "foobar"}, emitted, "cumulative emissions")
+	assert.Equal(t, "foobar", result.Text, "result text")
+}
@@ -0,0 +1,74 @@
+package openai
+
+import (
+	"context"
+
+	"cursortab/logger"
+)
+
+// FIMCompletionRequest matches the Mistral FIM Completion API format
+// (/v1/fim/completions), which rejects fields it does not know.
+// Fields it does support can still be sent through Client.ExtraBody.
+type FIMCompletionRequest struct {
+	Model       string   `json:"model"`
+	Prompt      string   `json:"prompt"`
+	Suffix      string   `json:"suffix"`
+	Temperature float64  `json:"temperature"`
+	MaxTokens   int      `json:"max_tokens,omitempty"`
+	Stop        []string `json:"stop,omitempty"`
+	Stream      bool     `json:"stream"`
+}
+
+// FIMClient is a client for Mistral-style fill-in-the-middle endpoints
+// (Codestral's /v1/fim/completions), which take the text around the cursor
+// as prompt and suffix and reply in chat-completions form. It accepts the
+// same CompletionRequest as Client; TopK, N and Sampling are not sent.
+type FIMClient struct {
+	*Client
+}
+
+// NewFIMClient creates a new FIM-completions client
+func NewFIMClient(url, completionPath string) *FIMClient {
+	return &FIMClient{Client: NewClient(url, completionPath)}
+}
+
+// toFIMRequest converts a completion request into a FIM request
+func toFIMRequest(req *CompletionRequest) *FIMCompletionRequest {
+	return &FIMCompletionRequest{
+		Model:       req.Model,
+		Prompt:      req.Prompt,
+		Suffix:      req.Suffix,
+		Temperature: req.Temperature,
+		MaxTokens:   req.MaxTokens,
+		Stop:        req.Stop,
+		Stream:      req.Stream,
+	}
+}
+
+// DoCompletion sends a non-streaming FIM request and returns the reply in
+// text-completion form (message content is exposed as choice text)
+func (c *FIMClient) DoCompletion(ctx context.Context, req *CompletionRequest) (*CompletionResponse, error) {
+	defer logger.Trace("openai.FIMClient.DoCompletion")()
+	req.Stream = false
+
+	body, err := c.doRequest(ctx, toFIMRequest(req))
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeChatResponse(body)
+}
+
+// DoLineStream sends a streaming FIM request and returns lines as they complete.
+// Same semantics as Client.DoLineStream; chunks are read from choices[].delta.content.
+func (c *FIMClient) DoLineStream(ctx context.Context, req *CompletionRequest, maxLines int, stopTokens []string) *LineStream {
+	req.Stream = true
+	return c.startLineStream(ctx, toFIMRequest(req), maxLines, stopTokens)
+}
+
+// DoTokenStream sends a streaming FIM request and emits cumulative text after each token.
+// Same semantics as Client.DoTokenStream; chunks are read from choices[].delta.content.
+func (c *FIMClient) DoTokenStream(ctx context.Context, req *CompletionRequest, maxChars int, stopTokens []string) *LineStream {
+	req.Stream = true
+	return c.startTokenStream(ctx, toFIMRequest(req), maxChars, stopTokens)
+}
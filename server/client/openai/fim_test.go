@@ -0,0 +1,64 @@
+package openai
+
+import (
+	"context"
+	"cursortab/assert"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFIMDoCompletion_Success(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/fim/completions", r.URL.Path, "request path")
+
+		body, _ := io.ReadAll(r.Body)
+		var fields map[string]any
+		json.Unmarshal(body, &fields)
+
+		assert.Equal(t, "func ", fields["prompt"], "prompt")
+		assert.Equal(t, "\n}", fields["suffix"], "suffix")
+		_, hasN := fields["n"]
+		assert.False(t, hasN, "n not sent")
+		_, hasTopK := fields["top_k"]
+		assert.False(t, hasTopK, "top_k not sent")
+
+		w.Write([]byte(`{"id":"fim-id","choices":[{"index":0,"message":{"role":"assistant","content":"main() {"},"finish_reason":"stop"}]}`))
+	}))
+	defer server.Close()
+
+	client := NewFIMClient(server.URL, "/v1/fim/completions")
+
+	resp, err := client.DoCompletion(context.Background(), &CompletionRequest{
+		Model:  "codestral-latest",
+		Prompt: "func ",
+		Suffix: "\n}",
+		TopK:   50,
+		N:      1,
+	})
+
+	assert.NoError(t, err, "DoCompletion")
+	assert.Equal(t, "fim-id", resp.ID, "ID")
+	assert.Equal(t, 1, len(resp.Choices), "Choices length")
+	assert.Equal(t, "main() {", resp.Choices[0].Text, "Text")
+}
+
+func TestDoCompletion_SendsSuffix(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var req CompletionRequest
+		json.Unmarshal(body, &req)
+		assert.Equal(t, "\n}", req.Suffix, "suffix")
+
+		w.Write([]byte(`{"choices":[]}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, DefaultCompletionPath)
+
+	_, err := client.DoCompletion(context.Background(), &CompletionRequest{Prompt: "func ", Suffix: "\n}"})
+
+	assert.NoError(t, err, "DoCompletion")
+}
@@ -0,0 +1,150 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"cursortab/logger"
+)
+
+// DefaultInfillPath is the llama.cpp server's infill endpoint
+const DefaultInfillPath = "/infill"
+
+// InfillChunk is a piece of another file sent as context with an infill request
+type InfillChunk struct {
+	Filename string `json:"filename"`
+	Text     string `json:"text"`
+}
+
+// InfillRequest matches the llama.cpp /infill API format
+type InfillRequest struct {
+	Model       string        `json:"model,omitempty"`
+	InputPrefix string        `json:"input_prefix"`
+	InputSuffix string        `json:"input_suffix"`
+	InputExtra  []InfillChunk `json:"input_extra,omitempty"`
+	Prompt      string        `json:"prompt"` // Start of the cursor line, placed after the middle token
+	Temperature float64       `json:"temperature"`
+	NPredict    int           `json:"n_predict,omitempty"`
+	TopK        int           `json:"top_k,omitempty"`
+	Stop        []string      `json:"stop,omitempty"`
+	CachePrompt bool          `json:"cache_prompt"`
+	Stream      bool          `json:"stream"`
+
+	MinP            *float64           `json:"min_p,omitempty"`
+	RepeatPenalty   *float64           `json:"repeat_penalty,omitempty"`
+	PresencePenalty *float64           `json:"presence_penalty,omitempty"`
+	Seed            *int64             `json:"seed,omitempty"`
+	Grammar         string             `json:"grammar,omitempty"`
+	LogitBias       map[string]float64 `json:"logit_bias,omitempty"`
+}
+
+// InfillResponse matches the llama.cpp /infill API response format
+type InfillResponse struct {
+	Content         string `json:"content"`
+	Model           string `json:"model"`
+	StopType        string `json:"stop_type"`
+	TokensPredicted int    `json:"tokens_predicted"`
+	TokensEvaluated int    `json:"tokens_evaluated"`
+}
+
+// InfillClient is a client for the llama.cpp server's native /infill endpoint,
+// which applies the model's FIM tokens itself. It accepts the same
+// CompletionRequest as Client, reading the text before the cursor from Prompt
+// and the text after it from Suffix. /infill returns a single completion, so
+// N is ignored.
+type InfillClient struct {
+	*Client
+}
+
+// NewInfillClient creates a new llama.cpp infill client
+func NewInfillClient(url, completionPath string) *InfillClient {
+	return &InfillClient{Client: NewClient(url, completionPath)}
+}
+
+// toInfillRequest converts a completion request into an infill request. The
+// start of the cursor line goes in the prompt rather than the prefix, as the
+// llama.cpp server expects, and the prompt is cached so the KV cache is reused
+// across requests.
+func toInfillRequest(req *CompletionRequest) *InfillRequest {
+	prefix, prompt := "", req.Prompt
+	if i := strings.LastIndexByte(req.Prompt, '\n'); i != -1 {
+		prefix, prompt = req.Prompt[:i+1], req.Prompt[i+1:]
+	}
+	return &InfillRequest{
+		Model:       req.Model,
+		InputPrefix: prefix,
+		InputSuffix: req.Suffix,
+		InputExtra:  req.InputExtra,
+		Prompt:      prompt,
+		Temperature: req.Temperature,
+		NPredict:    req.MaxTokens,
+		TopK:        req.TopK,
+		Stop:        req.Stop,
+		CachePrompt: true,
+		Stream:      req.Stream,
+
+		MinP:            req.MinP,
+		RepeatPenalty:   req.RepetitionPenalty,
+		PresencePenalty: req.PresencePenalty,
+		Seed:            req.Seed,
+		Grammar:         req.Grammar,
+		LogitBias:       req.LogitBias,
+	}
+}
+
+// stopTypeFinishReason maps a llama.cpp stop type to an OpenAI finish reason
+func stopTypeFinishReason(stopType string) string {
+	if stopType == "limit" {
+		return "length"
+	}
+	return "stop"
+}
+
+// DoCompletion sends a non-streaming infill request and returns the
+// completion in text-completion form
+func (c *InfillClient) DoCompletion(ctx context.Context, req *CompletionRequest) (*CompletionResponse, error) {
+	defer logger.Trace("openai.InfillClient.DoCompletion")()
+	req.Stream = false
+
+	body, err := c.doRequest(ctx, toInfillRequest(req))
+	if err != nil {
+		return nil, err
+	}
+
+	var infillResp InfillResponse
+	if err := json.Unmarshal(body, &infillResp); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	resp := &CompletionResponse{Model: infillResp.Model}
+	resp.Choices = append(resp.Choices, struct {
+		Index        int    `json:"index"`
+		Text         string `json:"text"`
+		Logprobs     any    `json:"logprobs"`
+		FinishReason string `json:"finish_reason"`
+	}{
+		Text:         infillResp.Content,
+		FinishReason: stopTypeFinishReason(infillResp.StopType),
+	})
+	resp.Usage.PromptTokens = infillResp.TokensEvaluated
+	resp.Usage.CompletionTokens = infillResp.TokensPredicted
+	resp.Usage.TotalTokens = infillResp.TokensEvaluated + infillResp.TokensPredicted
+
+	return resp, nil
+}
+
+// DoLineStream sends a streaming infill request and returns lines as they complete.
+// Same semantics as Client.DoLineStream; chunks are read from content.
+func (c *InfillClient) DoLineStream(ctx context.Context, req *CompletionRequest, maxLines int, stopTokens []string) *LineStream {
+	req.Stream = true
+	return c.startLineStream(ctx, toInfillRequest(req), maxLines, stopTokens)
+}
+
+// DoTokenStream sends a streaming infill request and emits cumulative text after each token.
+// Same semantics as Client.DoTokenStream; chunks are read from content.
+func (c *InfillClient) DoTokenStream(ctx context.Context, req *CompletionRequest, maxChars int, stopTokens []string) *LineStream {
+	req.Stream = true
+	return c.startTokenStream(ctx, toInfillRequest(req), maxChars, stopTokens)
+}
@@ -0,0 +1,99 @@
+package openai
+
+import (
+	"context"
+	"cursortab/assert"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestInfillDoCompletion_Success(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/infill", r.URL.Path, "request path")
+
+		body, _ := io.ReadAll(r.Body)
+		var req InfillRequest
+		json.Unmarshal(body, &req)
+
+		assert.False(t, req.Stream, "Stream should be false")
+		assert.Equal(t, "package main\n\n", req.InputPrefix, "input prefix")
+		assert.Equal(t, "func ", req.Prompt, "cursor line prefix")
+		assert.Equal(t, "\n", req.InputSuffix, "input suffix")
+		assert.Equal(t, []InfillChunk{{Filename: "util.go", Text: "func helper() {}\n"}}, req.InputExtra, "input extra")
+		assert.Equal(t, 64, req.NPredict, "n_predict")
+		assert.True(t, req.CachePrompt, "cache_prompt")
+
+		w.Write([]byte(`{"content":"main() {}","model":"test-model","stop_type":"limit","tokens_predicted":4,"tokens_evaluated":10}`))
+	}))
+	defer server.Close()
+
+	client := NewInfillClient(server.URL, DefaultInfillPath)
+
+	resp, err := client.DoCompletion(context.Background(), &CompletionRequest{
+		Model:      "test-model",
+		Prompt:     "package main\n\nfunc ",
+		Suffix:     "\n",
+		InputExtra: []InfillChunk{{Filename: "util.go", Text: "func helper() {}\n"}},
+		MaxTokens:  64,
+	})
+
+	assert.NoError(t, err, "DoCompletion")
+	assert.Equal(t, 1, len(resp.Choices), "Choices length")
+	assert.Equal(t, "main() {}", resp.Choices[0].Text, "Text")
+	assert.Equal(t, "length", resp.Choices[0].FinishReason, "FinishReason")
+	assert.Equal(t, 14, resp.Usage.TotalTokens, "total tokens")
+}
+
+func TestInfillDoLineStream_Content(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var req InfillRequest
+		json.Unmarshal(body, &req)
+		assert.True(t, req.Stream, "Stream should be true")
+
+		flusher, _ := w.(http.Flusher)
+		w.Header().Set("Content-Type", "text/event-stream")
+
+		events := []string{
+			`{"content":"line 1\nli","stop":false}`,
+			`{"content":"ne 2\n","stop":false}`,
+			`{"content":"","stop":true,"stop_type":"eos"}`,
+		}
+		for _, evt := range events {
+			w.Write([]byte("data: " + evt + "\n\n"))
+			flusher.Flush()
+		}
+	}))
+	defer server.Close()
+
+	client := NewInfillClient(server.URL, DefaultInfillPath)
+
+	stream := client.DoLineStream(context.Background(), &CompletionRequest{Prompt: "hello"}, 0, nil)
+
+	var lines []string
+	for line := range stream.LinesChan() {
+		lines = append(lines, line)
+	}
+	result := <-stream.DoneChan()
+
+	assert.Equal(t, []string{"line 1", "line 2"}, lines, "lines")
+	assert.Equal(t, "line 1\nline 2\n", result.Text, "result text")
+	assert.Equal(t, "stop", result.FinishReason, "finish reason")
+}
+
+func TestInfillRequest_Sampling(t *testing.T) {
+	penalty, seed := 1.1, int64(7)
+
+	req := toInfillRequest(&CompletionRequest{
+		Prompt:   "x",
+		Sampling: Sampling{RepetitionPenalty: &penalty, Seed: &seed, Grammar: "root ::= [a-z]+"},
+	})
+
+	assert.Equal(t, &penalty, req.RepeatPenalty, "repetition penalty sent as repeat_penalty")
+	assert.Equal(t, &seed, req.Seed, "seed")
+	assert.Equal(t, "root ::= [a-z]+", req.Grammar, "grammar")
+	assert.Nil(t, req.MinP, "unset min_p")
+}
@@ -0,0 +1,132 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"cursortab/logger"
+)
+
+// DefaultOllamaPath is Ollama's native generate endpoint
+const DefaultOllamaPath = "/api/generate"
+
+// OllamaOptions holds the model options of an Ollama generate request
+type OllamaOptions struct {
+	Temperature     float64  `json:"temperature"`
+	NumPredict      int      `json:"num_predict,omitempty"`
+	TopK            int      `json:"top_k,omitempty"`
+	Stop            []string `json:"stop,omitempty"`
+	MinP            *float64 `json:"min_p,omitempty"`
+	RepeatPenalty   *float64 `json:"repeat_penalty,omitempty"`
+	PresencePenalty *float64 `json:"presence_penalty,omitempty"`
+	Seed            *int64   `json:"seed,omitempty"`
+}
+
+// OllamaGenerateRequest matches the Ollama /api/generate API format
+type OllamaGenerateRequest struct {
+	Model     string        `json:"model"`
+	Prompt    string        `json:"prompt"`
+	Suffix    string        `json:"suffix,omitempty"`
+	Raw       bool          `json:"raw"`
+	Stream    bool          `json:"stream"`
+	Options   OllamaOptions `json:"options"`
+	KeepAlive string        `json:"keep_alive,omitempty"`
+}
+
+// OllamaGenerateResponse matches the Ollama /api/generate API response format
+type OllamaGenerateResponse struct {
+	Model           string `json:"model"`
+	Response        string `json:"response"`
+	Done            bool   `json:"done"`
+	DoneReason      string `json:"done_reason"`
+	PromptEvalCount int    `json:"prompt_eval_count"`
+	EvalCount       int    `json:"eval_count"`
+}
+
+// OllamaClient is a client for Ollama's native /api/generate endpoint, which
+// streams newline-delimited JSON. It accepts the same CompletionRequest as
+// Client. Prompts are sent raw, bypassing the model's template, unless a
+// Suffix is set, in which case the template applies the model's FIM tokens.
+// /api/generate returns a single completion, so N is ignored, and Ollama has
+// no grammar or logit bias, so those are not sent.
+type OllamaClient struct {
+	*Client
+	KeepAlive string // How long the model stays loaded after a request ("" = server default)
+}
+
+// NewOllamaClient creates a new Ollama client
+func NewOllamaClient(url, completionPath string) *OllamaClient {
+	client := NewClient(url, completionPath)
+	client.format = streamNDJSON
+	return &OllamaClient{Client: client}
+}
+
+// toOllamaRequest converts a completion request into a generate request
+func (c *OllamaClient) toOllamaRequest(req *CompletionRequest) *OllamaGenerateRequest {
+	return &OllamaGenerateRequest{
+		Model:  req.Model,
+		Prompt: req.Prompt,
+		Suffix: req.Suffix,
+		Raw:    req.Suffix == "",
+		Stream: req.Stream,
+		Options: OllamaOptions{
+			Temperature:     req.Temperature,
+			NumPredict:      req.MaxTokens,
+			TopK:            req.TopK,
+			Stop:            req.Stop,
+			MinP:            req.MinP,
+			RepeatPenalty:   req.RepetitionPenalty,
+			PresencePenalty: req.PresencePenalty,
+			Seed:            req.Seed,
+		},
+		KeepAlive: c.KeepAlive,
+	}
+}
+
+// DoCompletion sends a non-streaming generate request and returns the
+// completion in text-completion form
+func (c *OllamaClient) DoCompletion(ctx context.Context, req *CompletionRequest) (*CompletionResponse, error) {
+	defer logger.Trace("openai.OllamaClient.DoCompletion")()
+	req.Stream = false
+
+	body, err := c.doRequest(ctx, c.toOllamaRequest(req))
+	if err != nil {
+		return nil, err
+	}
+
+	var ollamaResp OllamaGenerateResponse
+	if err := json.Unmarshal(body, &ollamaResp); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	resp := &CompletionResponse{Model: ollamaResp.Model}
+	resp.Choices = append(resp.Choices, struct {
+		Index        int    `json:"index"`
+		Text         string `json:"text"`
+		Logprobs     any    `json:"logprobs"`
+		FinishReason string `json:"finish_reason"`
+	}{
+		Text:         ollamaResp.Response,
+		FinishReason: ollamaResp.DoneReason,
+	})
+	resp.Usage.PromptTokens = ollamaResp.PromptEvalCount
+	resp.Usage.CompletionTokens = ollamaResp.EvalCount
+	resp.Usage.TotalTokens = ollamaResp.PromptEvalCount + ollamaResp.EvalCount
+
+	return resp, nil
+}
+
+// DoLineStream sends a streaming generate request and returns lines as they complete.
+// Same semantics as Client.DoLineStream; chunks are read from response.
+func (c *OllamaClient) DoLineStream(ctx context.Context, req *CompletionRequest, maxLines int, stopTokens []string) *LineStream {
+	req.Stream = true
+	return c.startLineStream(ctx, c.toOllamaRequest(req), maxLines, stopTokens)
+}
+
+// DoTokenStream sends a streaming generate request and emits cumulative text after each token.
+// Same semantics as Client.DoTokenStream; chunks are read from response.
+func (c *OllamaClient) DoTokenStream(ctx context.Context, req *CompletionRequest, maxChars int, stopTokens []string) *LineStream {
+	req.Stream = true
+	return c.startTokenStream(ctx, c.toOllamaRequest(req), maxChars, stopTokens)
+}
@@ -0,0 +1,153 @@
+package openai
+
+import (
+	"context"
+	"cursortab/assert"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newOllamaStreamServer answers with the given NDJSON lines
+func newOllamaStreamServer(t *testing.T, lines []string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/x-ndjson", r.Header.Get("Accept"), "accept header")
+
+		body, _ := io.ReadAll(r.Body)
+		var req OllamaGenerateRequest
+		json.Unmarshal(body, &req)
+		assert.True(t, req.Stream, "Stream should be true")
+
+		flusher, _ := w.(http.Flusher)
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		for _, line := range lines {
+			w.Write([]byte(line + "\n"))
+			flusher.Flush()
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestOllamaDoCompletion_Success(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/generate", r.URL.Path, "request path")
+
+		body, _ := io.ReadAll(r.Body)
+		var req OllamaGenerateRequest
+		json.Unmarshal(body, &req)
+
+		assert.False(t, req.Stream, "Stream should be false")
+		assert.True(t, req.Raw, "raw prompt")
+		assert.Equal(t, "<|prompt|>", req.Prompt, "prompt")
+		assert.Equal(t, 128, req.Options.NumPredict, "num_predict")
+		assert.Equal(t, 40, req.Options.TopK, "top_k")
+		assert.Equal(t, "30m", req.KeepAlive, "keep_alive")
+
+		w.Write([]byte(`{"model":"zeta","response":"completion text","done":true,"done_reason":"length","prompt_eval_count":10,"eval_count":128}`))
+	}))
+	defer server.Close()
+
+	client := NewOllamaClient(server.URL, DefaultOllamaPath)
+	client.KeepAlive = "30m"
+
+	resp, err := client.DoCompletion(context.Background(), &CompletionRequest{
+		Model:     "zeta",
+		Prompt:    "<|prompt|>",
+		MaxTokens: 128,
+		TopK:      40,
+	})
+
+	assert.NoError(t, err, "DoCompletion")
+	assert.Equal(t, 1, len(resp.Choices), "Choices length")
+	assert.Equal(t, "completion text", resp.Choices[0].Text, "Text")
+	assert.Equal(t, "length", resp.Choices[0].FinishReason, "FinishReason")
+	assert.Equal(t, 138, resp.Usage.TotalTokens, "total tokens")
+}
+
+func TestOllamaRequest_SuffixUsesTemplate(t *testing.T) {
+	client := NewOllamaClient("", DefaultOllamaPath)
+
+	req := client.toOllamaRequest(&CompletionRequest{Prompt: "func ", Suffix: "\n}"})
+
+	assert.False(t, req.Raw, "template applies the FIM tokens")
+	assert.Equal(t, "\n}", req.Suffix, "suffix")
+}
+
+func TestOllamaDoLineStream_NDJSON(t *testing.T) {
+	server := newOllamaStreamServer(t, []string{
+		`{"response":"line 1\nli","done":false}`,
+		`{"response":"ne 2\n","done":false}`,
+		`{"response":"","done":true,"done_reason":"stop"}`,
+	})
+
+	client := NewOllamaClient(server.URL, DefaultOllamaPath)
+
+	stream := client.DoLineStream(context.Background(), &CompletionRequest{Prompt: "hello"}, 0, nil)
+
+	var lines []string
+	for line := range stream.LinesChan() {
+		lines = append(lines, line)
+	}
+	result := <-stream.DoneChan()
+
+	assert.Equal(t, []string{"line 1", "line 2"}, lines, "lines")
+	assert.Equal(t, "line 1\nline 2\n", result.Text, "result text")
+	assert.Equal(t, "stop", result.FinishReason, "finish reason")
+}
+
+func TestOllamaDoLineStream_MaxLines(t *testing.T) {
+	server := newOllamaStreamServer(t, []string{
+		`{"response":"a\nb\n","done":false}`,
+		`{"response":"c\n","done":false}`,
+	})
+
+	client := NewOllamaClient(server.URL, DefaultOllamaPath)
+
+	stream := client.DoLineStream(context.Background(), &CompletionRequest{Prompt: "hello"}, 2, nil)
+
+	var lines []string
+	for line := range stream.LinesChan() {
+		lines = append(lines, line)
+	}
+	result := <-stream.DoneChan()
+
+	assert.Equal(t, []string{"a", "b"}, lines, "lines up to the limit")
+	assert.True(t, result.StoppedEarly, "stopped early")
+}
+
+func TestOllamaDoTokenStream_StopToken(t *testing.T) {
+	server := newOllamaStreamServer(t, []string{
+		`{"response":"foo","done":false}`,
+		`{"response":"bar\nbaz","done":false}`,
+	})
+
+	client := NewOllamaClient(server.URL, DefaultOllamaPath)
+
+	stream := client.DoTokenStream(context.Background(), &CompletionRequest{Prompt: "hello"}, 0, []string{"\n"})
+
+	var emitted []string
+	for text := range stream.LinesChan() {
+		emitted = append(emitted, text)
+	}
+	result := <-stream.DoneChan()
+
+	assert.Equal(t, []string{"foo", "foobar"}, emitted, "cumulative emissions")
+	assert.Equal(t, "foobar", result.Text, "result text")
+}
+
+func TestOllamaRequest_Sampling(t *testing.T) {
+	client := NewOllamaClient("", DefaultOllamaPath)
+	minP, penalty := 0.05, 1.1
+
+	req := client.toOllamaRequest(&CompletionRequest{
+		Prompt:   "x",
+		Sampling: Sampling{MinP: &minP, RepetitionPenalty: &penalty},
+	})
+
+	assert.Equal(t, &minP, req.Options.MinP, "min_p")
+	assert.Equal(t, &penalty, req.Options.RepeatPenalty, "repetition penalty sent as repeat_penalty")
+	assert.Nil(t, req.Options.Seed, "unset seed")
+}
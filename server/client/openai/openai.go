@@ -2,13 +2,13 @@ package openai
 
 import (
 	"bufio"
-	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"strings"
+	"time"
 
 	"cursortab/logger"
 )
@@ -24,6 +24,12 @@ type CompletionRequest struct {
 	N           int      `json:"n"`
 	Echo        bool     `json:"echo"`
 	Stream      bool     `json:"stream"`
+	Sampling
+
+	// Fill-in-the-middle context for servers that apply the FIM tokens
+	// themselves. Prompt holds the text before the cursor when Suffix is set.
+	Suffix     string        `json:"suffix,omitempty"`      // Text after the cursor
+	InputExtra []InfillChunk `json:"input_extra,omitempty"` // Context from other files (InfillClient only)
 }
 
 // CompletionResponse matches the OpenAI Completion API response format
@@ -52,10 +58,83 @@ type StreamChunk struct {
 	Created int64  `json:"created"`
 	Model   string `json:"model"`
 	Choices []struct {
-		Index        int    `json:"index"`
-		Text         string `json:"text"`
+		Index int    `json:"index"`
+		Text  string `json:"text"`
+		Delta struct {
+			Content string `json:"content"`
+		} `json:"delta"` // Set instead of Text by chat-completions servers
 		FinishReason string `json:"finish_reason"`
 	} `json:"choices"`
+
+	// Set instead of Choices by llama.cpp's native endpoints (/infill)
+	Content  string `json:"content"`
+	Stop     bool   `json:"stop"`
+	StopType string `json:"stop_type"` // "eos", "word" or "limit", set on the last chunk
+
+	// Set instead of Choices by Ollama's native API (/api/generate)
+	Response   string `json:"response"`
+	Done       bool   `json:"done"`
+	DoneReason string `json:"done_reason"` // "stop" or "length", set on the last chunk
+}
+
+// text returns the content carried by the chunk, whichever schema the server
+// used (text-completions "text", chat-completions "delta.content", llama.cpp
+// "content" or Ollama "response")
+func (c *StreamChunk) text() string {
+	if len(c.Choices) == 0 {
+		return c.Content + c.Response
+	}
+	return c.Choices[0].Text + c.Choices[0].Delta.Content
+}
+
+// empty reports whether the chunk carries neither content nor the end of the stream
+func (c *StreamChunk) empty() bool {
+	return len(c.Choices) == 0 && c.text() == "" && !c.Stop && !c.Done
+}
+
+// finishReason returns the finish reason carried by the chunk, if any
+func (c *StreamChunk) finishReason() string {
+	if len(c.Choices) > 0 {
+		return c.Choices[0].FinishReason
+	}
+	if c.Stop {
+		return stopTypeFinishReason(c.StopType)
+	}
+	return c.DoneReason
+}
+
+// streamFormat is how a streaming response frames its chunks
+type streamFormat int
+
+const (
+	streamSSE    streamFormat = iota // Server-sent events ("data: {...}"), ended by "data: [DONE]"
+	streamNDJSON                     // One JSON object per line
+)
+
+// payload returns the JSON carried by a line of a streaming response ("" for
+// lines without one), or done at the end-of-stream marker
+func (f streamFormat) payload(line string) (data string, done bool) {
+	if f == streamNDJSON {
+		return strings.TrimSpace(line), false
+	}
+
+	// Skip empty lines, comments and other SSE fields
+	if line == "data: [DONE]" {
+		return "", true
+	}
+	data, found := strings.CutPrefix(line, "data: ")
+	if !found {
+		return "", false
+	}
+	return data, false
+}
+
+// accept returns the Accept header sent with streaming requests
+func (f streamFormat) accept() string {
+	if f == streamNDJSON {
+		return "application/x-ndjson"
+	}
+	return "text/event-stream"
 }
 
 // StreamResult contains the result of a streaming completion
@@ -81,12 +160,34 @@ type LineStream struct {
 	cancel func()              // Cancel the stream early
 }
 
+// NewLineStream creates a stream over channels fed by the caller, for clients
+// that relay another client's streams
+func NewLineStream(lines <-chan string, done <-chan StreamResult, cancel func()) *LineStream {
+	return &LineStream{
+		lines:  lines,
+		done:   done,
+		cancel: cancel,
+	}
+}
+
 // LinesChan returns the channel for receiving lines (implements engine.LineStream)
 func (s *LineStream) LinesChan() <-chan string { return s.lines }
 
 // DoneChan returns the channel for completion signal (implements engine.LineStream)
 func (s *LineStream) DoneChan() <-chan StreamResult { return s.done }
 
+// FinishReason returns why the stream ended, once its lines channel is closed
+// (implements engine.LineStream). Returns "" if the result was already taken
+// from DoneChan.
+func (s *LineStream) FinishReason() string {
+	select {
+	case result := <-s.done:
+		return result.FinishReason
+	default:
+		return ""
+	}
+}
+
 // Cancel cancels the stream early (implements engine.LineStream)
 func (s *LineStream) Cancel() {
 	if s.cancel != nil {
@@ -102,6 +203,13 @@ type Client struct {
 	HTTPClient     *http.Client
 	URL            string
 	CompletionPath string
+	Headers        map[string]string // Extra headers sent with every request
+	APIKey         string            // Sent as "Authorization: Bearer <key>" unless Headers sets Authorization
+	Retry          RetryPolicy       // Retries of requests turned away with 429 or 503
+	Breaker        *Breaker          // Fails requests fast while the server is down (nil = none)
+	ExtraBody      map[string]any    // Fields merged into every request body, overriding the built ones
+
+	format streamFormat // Framing of streaming responses
 }
 
 // NewClient creates a new OpenAI-compatible client
@@ -113,6 +221,15 @@ func NewClient(url, completionPath string) *Client {
 	}
 }
 
+// CircuitBreaker returns the client's circuit breaker, nil if it has none
+func (c *Client) CircuitBreaker() *Breaker { return c.Breaker }
+
+// SetCircuitBreaker replaces the client's circuit breaker
+func (c *Client) SetCircuitBreaker(breaker *Breaker) { c.Breaker = breaker }
+
+// Endpoint returns the URL requests are sent to
+func (c *Client) Endpoint() string { return c.URL + c.CompletionPath }
+
 // DoCompletion sends a non-streaming completion request
 func (c *Client) DoCompletion(ctx context.Context, req *CompletionRequest) (*CompletionResponse, error) {
 	defer logger.Trace("openai.DoCompletion")()
@@ -135,6 +252,12 @@ func (c *Client) DoCompletion(ctx context.Context, req *CompletionRequest) (*Com
 // Lines are emitted when a newline is encountered. Stop tokens trigger stream completion.
 // maxLines: stop after receiving this many lines (0 = no limit)
 func (c *Client) DoLineStream(ctx context.Context, req *CompletionRequest, maxLines int, stopTokens []string) *LineStream {
+	req.Stream = true
+	return c.startLineStream(ctx, req, maxLines, stopTokens)
+}
+
+// startLineStream runs a line stream for an already-built request body
+func (c *Client) startLineStream(ctx context.Context, body any, maxLines int, stopTokens []string) *LineStream {
 	linesChan := make(chan string, 100)
 	doneChan := make(chan StreamResult, 1)
 
@@ -150,7 +273,7 @@ func (c *Client) DoLineStream(ctx context.Context, req *CompletionRequest, maxLi
 		defer close(linesChan)
 		defer close(doneChan)
 
-		result := c.runLineStream(ctx, req, linesChan, maxLines, stopTokens)
+		result := c.runLineStream(ctx, body, linesChan, maxLines, stopTokens)
 		doneChan <- result
 	}()
 
@@ -158,46 +281,19 @@ func (c *Client) DoLineStream(ctx context.Context, req *CompletionRequest, maxLi
 }
 
 // runLineStream executes the streaming request and sends lines to the channel
-func (c *Client) runLineStream(ctx context.Context, req *CompletionRequest, lines chan<- string, maxLines int, stopTokens []string) StreamResult {
+func (c *Client) runLineStream(ctx context.Context, body any, lines chan<- string, maxLines int, stopTokens []string) StreamResult {
 	defer logger.Trace("openai.runLineStream")()
-	req.Stream = true
 
-	// Marshal the request without HTML escaping
-	var reqBodyBuf bytes.Buffer
-	encoder := json.NewEncoder(&reqBodyBuf)
-	encoder.SetEscapeHTML(false)
-	if err := encoder.Encode(req); err != nil {
-		logger.Error("line stream: failed to marshal request: %v", err)
-		return StreamResult{FinishReason: "error"}
-	}
-
-	// Create HTTP request
-	httpReq, err := http.NewRequestWithContext(ctx, "POST", c.URL+c.CompletionPath, &reqBodyBuf)
-	if err != nil {
-		logger.Error("line stream: failed to create request: %v", err)
-		return StreamResult{FinishReason: "error"}
-	}
-	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("Accept", "text/event-stream")
-
-	// Send the request
-	resp, err := c.HTTPClient.Do(httpReq)
+	resp, err := c.openStream(ctx, body)
 	if err != nil {
 		if ctx.Err() != nil {
 			return StreamResult{FinishReason: "cancelled"}
 		}
-		logger.Error("line stream: failed to send request: %v", err)
+		logger.Error("line stream: %v", err)
 		return StreamResult{FinishReason: "error"}
 	}
 	defer resp.Body.Close()
 
-	// Check status code
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		logger.Error("line stream: request failed with status %d: %s", resp.StatusCode, string(body))
-		return StreamResult{FinishReason: "error"}
-	}
-
 	return c.processLineStream(ctx, resp.Body, lines, maxLines, stopTokens)
 }
 
@@ -228,24 +324,14 @@ func (c *Client) processLineStream(ctx context.Context, body io.Reader, lines ch
 		default:
 		}
 
-		line := scanner.Text()
-
-		// Skip empty lines and comments
-		if line == "" || strings.HasPrefix(line, ":") {
-			continue
-		}
-
-		// Check for end of stream
-		if line == "data: [DONE]" {
+		jsonData, done := c.format.payload(scanner.Text())
+		if done {
 			break
 		}
-
-		// Parse SSE data line
-		if !strings.HasPrefix(line, "data: ") {
+		if jsonData == "" {
 			continue
 		}
 
-		jsonData := strings.TrimPrefix(line, "data: ")
 		var chunk StreamChunk
 		if err := json.Unmarshal([]byte(jsonData), &chunk); err != nil {
 			logger.Debug("line stream: failed to parse chunk: %v", err)
@@ -253,8 +339,8 @@ func (c *Client) processLineStream(ctx context.Context, body io.Reader, lines ch
 		}
 
 		// Extract text from chunk
-		if len(chunk.Choices) > 0 {
-			text := chunk.Choices[0].Text
+		if !chunk.empty() {
+			text := chunk.text()
 
 			// Check for stop tokens in the text
 			for token := range stopTokenSet {
@@ -313,8 +399,8 @@ func (c *Client) processLineStream(ctx context.Context, body io.Reader, lines ch
 			}
 
 			// Capture finish reason if present
-			if chunk.Choices[0].FinishReason != "" {
-				finishReason = chunk.Choices[0].FinishReason
+			if reason := chunk.finishReason(); reason != "" {
+				finishReason = reason
 			}
 		}
 	}
@@ -344,6 +430,12 @@ func (c *Client) processLineStream(ctx context.Context, body io.Reader, lines ch
 // maxChars: stop after receiving this many characters (0 = no limit)
 // stopTokens: stop tokens that terminate the stream (e.g., "\n" for inline completion)
 func (c *Client) DoTokenStream(ctx context.Context, req *CompletionRequest, maxChars int, stopTokens []string) *LineStream {
+	req.Stream = true
+	return c.startTokenStream(ctx, req, maxChars, stopTokens)
+}
+
+// startTokenStream runs a token stream for an already-built request body
+func (c *Client) startTokenStream(ctx context.Context, body any, maxChars int, stopTokens []string) *LineStream {
 	linesChan := make(chan string, 100)
 	doneChan := make(chan StreamResult, 1)
 
@@ -359,7 +451,7 @@ func (c *Client) DoTokenStream(ctx context.Context, req *CompletionRequest, maxC
 		defer close(linesChan)
 		defer close(doneChan)
 
-		result := c.runTokenStream(ctx, req, linesChan, maxChars, stopTokens)
+		result := c.runTokenStream(ctx, body, linesChan, maxChars, stopTokens)
 		doneChan <- result
 	}()
 
@@ -367,46 +459,19 @@ func (c *Client) DoTokenStream(ctx context.Context, req *CompletionRequest, maxC
 }
 
 // runTokenStream executes the streaming request and sends cumulative text to the channel
-func (c *Client) runTokenStream(ctx context.Context, req *CompletionRequest, textChan chan<- string, maxChars int, stopTokens []string) StreamResult {
+func (c *Client) runTokenStream(ctx context.Context, body any, textChan chan<- string, maxChars int, stopTokens []string) StreamResult {
 	defer logger.Trace("openai.runTokenStream")()
-	req.Stream = true
-
-	// Marshal the request without HTML escaping
-	var reqBodyBuf bytes.Buffer
-	encoder := json.NewEncoder(&reqBodyBuf)
-	encoder.SetEscapeHTML(false)
-	if err := encoder.Encode(req); err != nil {
-		logger.Error("token stream: failed to marshal request: %v", err)
-		return StreamResult{FinishReason: "error"}
-	}
-
-	// Create HTTP request
-	httpReq, err := http.NewRequestWithContext(ctx, "POST", c.URL+c.CompletionPath, &reqBodyBuf)
-	if err != nil {
-		logger.Error("token stream: failed to create request: %v", err)
-		return StreamResult{FinishReason: "error"}
-	}
-	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("Accept", "text/event-stream")
 
-	// Send the request
-	resp, err := c.HTTPClient.Do(httpReq)
+	resp, err := c.openStream(ctx, body)
 	if err != nil {
 		if ctx.Err() != nil {
 			return StreamResult{FinishReason: "cancelled"}
 		}
-		logger.Error("token stream: failed to send request: %v", err)
+		logger.Error("token stream: %v", err)
 		return StreamResult{FinishReason: "error"}
 	}
 	defer resp.Body.Close()
 
-	// Check status code
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		logger.Error("token stream: request failed with status %d: %s", resp.StatusCode, string(body))
-		return StreamResult{FinishReason: "error"}
-	}
-
 	return c.processTokenStream(ctx, resp.Body, textChan, maxChars, stopTokens)
 }
 
@@ -435,24 +500,14 @@ func (c *Client) processTokenStream(ctx context.Context, body io.Reader, textCha
 		default:
 		}
 
-		line := scanner.Text()
-
-		// Skip empty lines and comments
-		if line == "" || strings.HasPrefix(line, ":") {
-			continue
-		}
-
-		// Check for end of stream
-		if line == "data: [DONE]" {
+		jsonData, done := c.format.payload(scanner.Text())
+		if done {
 			break
 		}
-
-		// Parse SSE data line
-		if !strings.HasPrefix(line, "data: ") {
+		if jsonData == "" {
 			continue
 		}
 
-		jsonData := strings.TrimPrefix(line, "data: ")
 		var chunk StreamChunk
 		if err := json.Unmarshal([]byte(jsonData), &chunk); err != nil {
 			logger.Debug("token stream: failed to parse chunk: %v", err)
@@ -460,8 +515,8 @@ func (c *Client) processTokenStream(ctx context.Context, body io.Reader, textCha
 		}
 
 		// Extract text from chunk
-		if len(chunk.Choices) > 0 {
-			text := chunk.Choices[0].Text
+		if !chunk.empty() {
+			text := chunk.text()
 
 			// Check for stop tokens in the text
 			for token := range stopTokenSet {
@@ -512,8 +567,8 @@ func (c *Client) processTokenStream(ctx context.Context, body io.Reader, textCha
 			}
 
 			// Capture finish reason if present
-			if chunk.Choices[0].FinishReason != "" {
-				finishReason = chunk.Choices[0].FinishReason
+			if reason := chunk.finishReason(); reason != "" {
+				finishReason = reason
 			}
 		}
 	}
@@ -529,41 +584,107 @@ func (c *Client) processTokenStream(ctx context.Context, body io.Reader, textCha
 	}
 }
 
-// doRequest sends an HTTP request and returns the response body
-func (c *Client) doRequest(ctx context.Context, req *CompletionRequest) ([]byte, error) {
-	// Marshal the request without HTML escaping
-	var reqBodyBuf bytes.Buffer
-	encoder := json.NewEncoder(&reqBodyBuf)
-	encoder.SetEscapeHTML(false)
-	if err := encoder.Encode(req); err != nil {
-		return nil, fmt.Errorf("failed to marshal request: %w", err)
+// newRequest builds a JSON POST request to the completion endpoint
+func (c *Client) newRequest(ctx context.Context, body any) (*http.Request, error) {
+	reqBody, err := encodeBody(body, c.ExtraBody)
+	if err != nil {
+		return nil, err
 	}
 
-	// Create HTTP request
-	httpReq, err := http.NewRequestWithContext(ctx, "POST", c.URL+c.CompletionPath, &reqBodyBuf)
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", c.URL+c.CompletionPath, reqBody)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 	httpReq.Header.Set("Content-Type", "application/json")
+	if c.APIKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+c.APIKey)
+	}
+	for key, value := range c.Headers {
+		httpReq.Header.Set(key, value)
+	}
+	return httpReq, nil
+}
 
-	// Send the request
-	resp, err := c.HTTPClient.Do(httpReq)
+// openStream sends a streaming request and returns the response once the
+// server has accepted it. The caller must close the response body.
+func (c *Client) openStream(ctx context.Context, body any) (*http.Response, error) {
+	return c.send(ctx, body, c.format.accept())
+}
+
+// doRequest sends an HTTP request and returns the response body
+func (c *Client) doRequest(ctx context.Context, body any) ([]byte, error) {
+	resp, err := c.send(ctx, body, "")
 	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %w", err)
+		return nil, err
 	}
 	defer resp.Body.Close()
 
-	// Check status code
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("request failed with status %d: %s", resp.StatusCode, string(body))
-	}
-
 	// Read the response body
-	body, err := io.ReadAll(resp.Body)
+	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read response body: %w", err)
 	}
 
-	return body, nil
+	return respBody, nil
+}
+
+// send posts a request and returns the response once the server has
+// accepted it, going through the circuit breaker and retrying requests the
+// server turned away with 429 or 503. The caller must close the response body.
+func (c *Client) send(ctx context.Context, body any, accept string) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		if err := c.Breaker.allow(); err != nil {
+			return nil, err
+		}
+
+		httpReq, err := c.newRequest(ctx, body)
+		if err != nil {
+			c.Breaker.abandon()
+			return nil, err
+		}
+		if accept != "" {
+			httpReq.Header.Set("Accept", accept)
+		}
+
+		resp, err := c.HTTPClient.Do(httpReq)
+		if err != nil {
+			if ctx.Err() != nil {
+				c.Breaker.abandon()
+			} else {
+				c.Breaker.failure()
+			}
+			return nil, fmt.Errorf("failed to send request: %w", err)
+		}
+
+		if resp.StatusCode == http.StatusOK {
+			c.Breaker.success()
+			return resp, nil
+		}
+
+		respBody, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode >= http.StatusInternalServerError {
+			c.Breaker.failure()
+		} else {
+			c.Breaker.success()
+		}
+		err = fmt.Errorf("request failed with status %d: %s", resp.StatusCode, string(respBody))
+
+		if !retryable(resp.StatusCode) {
+			return nil, err
+		}
+		delay, ok := c.Retry.delay(attempt, resp.Header.Get("Retry-After"))
+		if !ok {
+			return nil, err
+		}
+		logger.Debug("request failed with status %d, retry %d in %s", resp.StatusCode, attempt+1, delay)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+	}
 }
@@ -437,3 +437,37 @@ func TestDoLineStream_SkipsComments(t *testing.T) {
 
 	assert.Equal(t, 1, len(lines), "lines length (comments skip)")
 }
+
+func TestDoCompletion_SendsAuthAndCustomHeaders(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer secret-key", r.Header.Get("Authorization"), "Authorization header")
+		assert.Equal(t, "acme", r.Header.Get("X-Tenant-ID"), "custom header")
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"), "Content-Type header")
+		w.Write([]byte(`{"choices":[{"text":"ok"}]}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "")
+	client.APIKey = "secret-key"
+	client.Headers = map[string]string{"X-Tenant-ID": "acme"}
+
+	_, err := client.DoCompletion(context.Background(), &CompletionRequest{Prompt: "hello"})
+	assert.NoError(t, err, "DoCompletion")
+}
+
+func TestDoLineStream_HeaderOverridesAPIKey(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Token custom", r.Header.Get("Authorization"), "Authorization header")
+		w.Write([]byte("data: [DONE]\n\n"))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "")
+	client.APIKey = "secret-key"
+	client.Headers = map[string]string{"Authorization": "Token custom"}
+
+	stream := client.DoLineStream(context.Background(), &CompletionRequest{Prompt: "hello"}, 0, nil)
+	for range stream.LinesChan() {
+	}
+	<-stream.DoneChan()
+}
@@ -0,0 +1,204 @@
+package openai
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"cursortab/logger"
+)
+
+// Backoff bounds for retries
+const (
+	DefaultRetryBaseDelay = 100 * time.Millisecond
+	DefaultRetryMaxDelay  = 2 * time.Second
+)
+
+// RetryPolicy bounds the retries of requests the server turned away with
+// 429 or 503. The zero value does not retry.
+type RetryPolicy struct {
+	MaxRetries int           // Retries after the first attempt
+	BaseDelay  time.Duration // Backoff before the first retry, doubled for each one after
+	MaxDelay   time.Duration // Longest wait before a retry, Retry-After included
+}
+
+// NewRetryPolicy creates a policy with the default backoff bounds
+func NewRetryPolicy(maxRetries int) RetryPolicy {
+	return RetryPolicy{
+		MaxRetries: maxRetries,
+		BaseDelay:  DefaultRetryBaseDelay,
+		MaxDelay:   DefaultRetryMaxDelay,
+	}
+}
+
+// retryable reports whether a response status is worth retrying
+func retryable(status int) bool {
+	return status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable
+}
+
+// delay returns how long to wait before retrying after the given attempt
+// (0-indexed), or false if the request should not be retried. A Retry-After
+// the server sent is honoured unless it is longer than MaxDelay; otherwise
+// the backoff doubles per attempt, with jitter so clients don't retry in step.
+func (p RetryPolicy) delay(attempt int, retryAfter string) (time.Duration, bool) {
+	if attempt >= p.MaxRetries {
+		return 0, false
+	}
+	if d, ok := parseRetryAfter(retryAfter); ok {
+		return d, d <= p.MaxDelay
+	}
+
+	backoff := min(p.BaseDelay<<attempt, p.MaxDelay)
+	if backoff <= 0 {
+		return 0, true
+	}
+	return backoff/2 + rand.N(backoff/2+1), true
+}
+
+// parseRetryAfter reads a Retry-After header in seconds or as an HTTP date
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(time.Until(at), 0), true
+	}
+	return 0, false
+}
+
+// ErrCircuitOpen is returned for requests the circuit breaker holds back
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// BreakerState is the state of a circuit breaker
+type BreakerState string
+
+const (
+	BreakerClosed   BreakerState = "closed"    // Requests go through
+	BreakerOpen     BreakerState = "open"      // Requests fail fast until the cooldown ends
+	BreakerHalfOpen BreakerState = "half_open" // One probe request is in flight
+)
+
+// BreakerStatus is a snapshot of a circuit breaker
+type BreakerStatus struct {
+	State     BreakerState `json:"state" msgpack:"state"`
+	Failures  int          `json:"failures" msgpack:"failures"`       // Consecutive failures
+	RetryInMs int64        `json:"retry_in_ms" msgpack:"retry_in_ms"` // Time until the next probe while open
+}
+
+// Breaker stops requests to a server after repeated failures. Once open, it
+// lets a single probe through per cooldown; the breaker closes again when a
+// probe succeeds. Failures are transport errors and 5xx responses.
+// It is safe for concurrent use, and all methods are no-ops on a nil Breaker.
+type Breaker struct {
+	threshold int           // Consecutive failures that open the breaker
+	cooldown  time.Duration // Time open before a probe
+	now       func() time.Time
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+}
+
+// NewBreaker creates a breaker that opens after threshold consecutive
+// failures. Returns nil (no breaker) if threshold <= 0.
+func NewBreaker(threshold int, cooldown time.Duration) *Breaker {
+	if threshold <= 0 {
+		return nil
+	}
+	return &Breaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		now:       time.Now,
+		state:     BreakerClosed,
+	}
+}
+
+// allow returns ErrCircuitOpen if a request must not be sent now. Past the
+// cooldown, the first request is let through as the probe.
+func (b *Breaker) allow() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if wait := b.cooldown - b.now().Sub(b.openedAt); wait > 0 {
+			return fmt.Errorf("%w, next attempt in %s", ErrCircuitOpen, wait.Round(time.Second))
+		}
+		b.state = BreakerHalfOpen
+		logger.Info("circuit breaker: probing server")
+	case BreakerHalfOpen:
+		return fmt.Errorf("%w, probe in flight", ErrCircuitOpen)
+	}
+	return nil
+}
+
+// success records that the server answered
+func (b *Breaker) success() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state != BreakerClosed {
+		logger.Info("circuit breaker: server is back, closing")
+	}
+	b.state = BreakerClosed
+	b.failures = 0
+}
+
+// failure records that the server could not be reached or failed
+func (b *Breaker) failure() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	if b.state == BreakerHalfOpen || (b.state == BreakerClosed && b.failures >= b.threshold) {
+		b.state = BreakerOpen
+		b.openedAt = b.now()
+		logger.Warn("circuit breaker: opened after %d failures, next attempt in %s", b.failures, b.cooldown)
+	}
+}
+
+// abandon records that a request was cancelled before the server answered.
+// An abandoned probe is retried by the next request.
+func (b *Breaker) abandon() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerHalfOpen {
+		b.state = BreakerOpen
+	}
+}
+
+// Status returns a snapshot of the breaker
+func (b *Breaker) Status() BreakerStatus {
+	if b == nil {
+		return BreakerStatus{State: BreakerClosed}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	status := BreakerStatus{State: b.state, Failures: b.failures}
+	if b.state == BreakerOpen {
+		status.RetryInMs = max(b.cooldown-b.now().Sub(b.openedAt), 0).Milliseconds()
+	}
+	return status
+}
@@ -0,0 +1,133 @@
+package openai
+
+import (
+	"context"
+	"cursortab/assert"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newFlakyServer answers the first failures requests with status and the
+// rest with an empty completion
+func newFlakyServer(t *testing.T, failures int, status int, retryAfter string) (*httptest.Server, *atomic.Int32) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if int(calls.Add(1)) <= failures {
+			if retryAfter != "" {
+				w.Header().Set("Retry-After", retryAfter)
+			}
+			w.WriteHeader(status)
+			return
+		}
+		w.Write([]byte(`{"choices":[]}`))
+	}))
+	t.Cleanup(server.Close)
+	return server, &calls
+}
+
+func TestSend_RetriesUnavailable(t *testing.T) {
+	server, calls := newFlakyServer(t, 2, http.StatusServiceUnavailable, "")
+	client := NewClient(server.URL, "")
+	client.Retry = RetryPolicy{MaxRetries: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+
+	_, err := client.DoCompletion(context.Background(), &CompletionRequest{})
+
+	assert.NoError(t, err, "succeeds on the last retry")
+	assert.Equal(t, int32(3), calls.Load(), "attempts")
+}
+
+func TestSend_GivesUpAfterMaxRetries(t *testing.T) {
+	server, calls := newFlakyServer(t, 5, http.StatusTooManyRequests, "0")
+	client := NewClient(server.URL, "")
+	client.Retry = NewRetryPolicy(1)
+
+	_, err := client.DoCompletion(context.Background(), &CompletionRequest{})
+
+	assert.Error(t, err, "still rate limited")
+	assert.Equal(t, int32(2), calls.Load(), "attempts")
+}
+
+func TestSend_DoesNotRetryOtherErrors(t *testing.T) {
+	server, calls := newFlakyServer(t, 1, http.StatusBadRequest, "")
+	client := NewClient(server.URL, "")
+	client.Retry = NewRetryPolicy(2)
+
+	_, err := client.DoCompletion(context.Background(), &CompletionRequest{})
+
+	assert.Error(t, err, "bad request")
+	assert.Equal(t, int32(1), calls.Load(), "attempts")
+}
+
+func TestRetryPolicy_Delay(t *testing.T) {
+	p := RetryPolicy{MaxRetries: 3, BaseDelay: 100 * time.Millisecond, MaxDelay: 2 * time.Second}
+
+	d, ok := p.delay(0, "1")
+	assert.True(t, ok, "short Retry-After honoured")
+	assert.Equal(t, time.Second, d, "Retry-After delay")
+
+	_, ok = p.delay(0, "30")
+	assert.False(t, ok, "long Retry-After not waited for")
+
+	d, ok = p.delay(2, "")
+	assert.True(t, ok, "backoff retry")
+	assert.True(t, d >= 200*time.Millisecond && d <= 400*time.Millisecond, "jittered backoff within bounds")
+
+	_, ok = p.delay(3, "")
+	assert.False(t, ok, "no retries left")
+}
+
+func TestBreaker_OpensAndProbes(t *testing.T) {
+	now := time.Now()
+	b := NewBreaker(2, 10*time.Second)
+	b.now = func() time.Time { return now }
+
+	b.failure()
+	assert.NoError(t, b.allow(), "closed below the threshold")
+	b.failure()
+	assert.Equal(t, BreakerOpen, b.Status().State, "opened at the threshold")
+	assert.True(t, errors.Is(b.allow(), ErrCircuitOpen), "requests fail fast while open")
+	assert.Equal(t, int64(10000), b.Status().RetryInMs, "time to the probe")
+
+	now = now.Add(10 * time.Second)
+	assert.NoError(t, b.allow(), "probe let through after the cooldown")
+	assert.True(t, errors.Is(b.allow(), ErrCircuitOpen), "one probe at a time")
+
+	b.failure()
+	assert.Equal(t, BreakerOpen, b.Status().State, "failed probe reopens")
+
+	now = now.Add(10 * time.Second)
+	assert.NoError(t, b.allow(), "next probe")
+	b.success()
+	assert.Equal(t, BreakerStatus{State: BreakerClosed}, b.Status(), "closed once the probe succeeds")
+}
+
+func TestBreaker_AbandonedProbeRetried(t *testing.T) {
+	now := time.Now()
+	b := NewBreaker(1, time.Second)
+	b.now = func() time.Time { return now }
+
+	b.failure()
+	now = now.Add(time.Second)
+	assert.NoError(t, b.allow(), "probe")
+	b.abandon()
+
+	assert.NoError(t, b.allow(), "next request probes again")
+}
+
+func TestSend_BreakerFailsFast(t *testing.T) {
+	server, calls := newFlakyServer(t, 5, http.StatusInternalServerError, "")
+	client := NewClient(server.URL, "")
+	client.Breaker = NewBreaker(2, time.Minute)
+
+	for range 3 {
+		client.DoCompletion(context.Background(), &CompletionRequest{})
+	}
+	_, err := client.DoCompletion(context.Background(), &CompletionRequest{})
+
+	assert.True(t, errors.Is(err, ErrCircuitOpen), "request held back")
+	assert.Equal(t, int32(2), calls.Load(), "requests that reached the server")
+}
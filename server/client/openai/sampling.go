@@ -0,0 +1,60 @@
+package openai
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// Sampling holds sampling parameters beyond the OpenAI basics that most
+// servers support. Unset fields are not sent; each client renames them to
+// its server's spelling.
+type Sampling struct {
+	MinP              *float64           `json:"min_p,omitempty"`
+	RepetitionPenalty *float64           `json:"repetition_penalty,omitempty"`
+	PresencePenalty   *float64           `json:"presence_penalty,omitempty"`
+	Seed              *int64             `json:"seed,omitempty"`
+	Grammar           string             `json:"grammar,omitempty"` // GBNF grammar constraining the output
+	LogitBias         map[string]float64 `json:"logit_bias,omitempty"`
+}
+
+// encodeBody marshals a request body without HTML escaping, merging extra
+// into it. Fields in extra override the body's; objects are merged key by key.
+func encodeBody(body any, extra map[string]any) (*bytes.Buffer, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(body); err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+	if len(extra) == 0 {
+		return &buf, nil
+	}
+
+	var fields map[string]any
+	decoder := json.NewDecoder(&buf)
+	decoder.UseNumber()
+	if err := decoder.Decode(&fields); err != nil {
+		return nil, fmt.Errorf("failed to merge extra body: %w", err)
+	}
+	mergeFields(fields, extra)
+
+	buf.Reset()
+	if err := encoder.Encode(fields); err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+	return &buf, nil
+}
+
+// mergeFields copies src into dst, which is modified, merging nested objects
+func mergeFields(dst, src map[string]any) {
+	for key, value := range src {
+		srcObject, srcOK := value.(map[string]any)
+		dstObject, dstOK := dst[key].(map[string]any)
+		if srcOK && dstOK {
+			mergeFields(dstObject, srcObject)
+			continue
+		}
+		dst[key] = value
+	}
+}
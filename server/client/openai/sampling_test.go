@@ -0,0 +1,85 @@
+package openai
+
+import (
+	"context"
+	"cursortab/assert"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestEncodeBody_NoExtra(t *testing.T) {
+	buf, err := encodeBody(map[string]string{"prompt": "<a> && b"}, nil)
+
+	assert.NoError(t, err, "encodeBody")
+	assert.Equal(t, `{"prompt":"<a> && b"}`+"\n", buf.String(), "body without HTML escaping")
+}
+
+func TestEncodeBody_MergesExtra(t *testing.T) {
+	body := map[string]any{
+		"model":   "m",
+		"seed":    int64(9007199254740993),
+		"options": map[string]any{"temperature": 0.2, "top_k": 40},
+	}
+	extra := map[string]any{
+		"model":          "override",
+		"cache_prompt":   false,
+		"options":        map[string]any{"top_k": 10, "mirostat": 2},
+		"dry_multiplier": 0.8,
+	}
+
+	buf, err := encodeBody(body, extra)
+	assert.NoError(t, err, "encodeBody")
+
+	var got map[string]any
+	decoder := json.NewDecoder(buf)
+	decoder.UseNumber()
+	assert.NoError(t, decoder.Decode(&got), "decode")
+
+	assert.Equal(t, "override", got["model"], "extra overrides built fields")
+	assert.Equal(t, false, got["cache_prompt"], "extra adds fields")
+	assert.Equal(t, json.Number("0.8"), got["dry_multiplier"], "extra number")
+	assert.Equal(t, json.Number("9007199254740993"), got["seed"], "large integers survive the merge")
+	assert.Equal(t, map[string]any{
+		"temperature": json.Number("0.2"),
+		"top_k":       json.Number("10"),
+		"mirostat":    json.Number("2"),
+	}, got["options"], "nested objects merged key by key")
+}
+
+func TestDoCompletion_SendsSamplingAndExtraBody(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var req map[string]any
+		json.Unmarshal(body, &req)
+
+		assert.Equal(t, 0.05, req["min_p"], "min_p")
+		assert.Equal(t, 1.1, req["repetition_penalty"], "repetition_penalty")
+		assert.Equal(t, float64(42), req["seed"], "seed")
+		assert.Equal(t, map[string]any{"13": -100.0}, req["logit_bias"], "logit_bias")
+		assert.Equal(t, nil, req["presence_penalty"], "unset fields are not sent")
+		assert.Equal(t, nil, req["grammar"], "empty grammar is not sent")
+		assert.Equal(t, true, req["cache_prompt"], "extra body field")
+
+		w.Write([]byte(`{"choices":[{"text":"ok"}]}`))
+	}))
+	defer server.Close()
+
+	minP, penalty, seed := 0.05, 1.1, int64(42)
+	client := NewClient(server.URL, "")
+	client.ExtraBody = map[string]any{"cache_prompt": true}
+
+	_, err := client.DoCompletion(context.Background(), &CompletionRequest{
+		Prompt: "hello",
+		Sampling: Sampling{
+			MinP:              &minP,
+			RepetitionPenalty: &penalty,
+			Seed:              &seed,
+			LogitBias:         map[string]float64{"13": -100},
+		},
+	})
+
+	assert.NoError(t, err, "DoCompletion")
+}
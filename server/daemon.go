@@ -8,15 +8,20 @@ import (
 	"os"
 	"os/signal"
 	"strconv"
+	"sync"
 	"sync/atomic"
 	"syscall"
 	"time"
 
-	"cursortab/buffer"
+	"cursortab/client/openai"
 	"cursortab/engine"
 	"cursortab/logger"
+	"cursortab/metrics"
+	"cursortab/provider"
 	"cursortab/provider/fim"
+	"cursortab/provider/infill"
 	"cursortab/provider/inline"
+	"cursortab/provider/nextedit"
 	"cursortab/provider/sweep"
 	"cursortab/provider/zeta"
 	"cursortab/types"
@@ -25,54 +30,121 @@ import (
 )
 
 type Daemon struct {
-	config      Config
-	provider    engine.Provider
-	buffer      *buffer.NvimBuffer
-	engine      *engine.Engine
-	listener    net.Listener
-	socketPath  string
-	pidPath     string
-	clientCount int64
-	shutdown    chan bool
-	ctx         context.Context
-	cancel      context.CancelFunc
+	mu           sync.Mutex // Guards config, provider, engineConfig and sessions
+	config       Config
+	provider     engine.Provider // Shared by all sessions
+	engineConfig engine.EngineConfig
+	metrics      *metrics.Recorder // Shared by all sessions; survives config reloads
+	sessions     map[int64]*session
+	nextID       int64
+	listener     net.Listener
+	socketPath   string
+	pidPath      string
+	clientCount  int64
+	shutdown     chan bool
+	ctx          context.Context
+	cancel       context.CancelFunc
 }
 
 func NewDaemon(config Config) (*Daemon, error) {
-	providerConfig := &types.ProviderConfig{
-		ProviderURL:         config.Provider.URL,
-		ProviderModel:       config.Provider.Model,
-		ProviderTemperature: config.Provider.Temperature,
-		ProviderMaxTokens:   config.Provider.MaxTokens,
-		ProviderTopK:        config.Provider.TopK,
-		CompletionPath:      config.Provider.CompletionPath,
+	recorder, err := metrics.NewRecorder(getStatsPath())
+	if err != nil {
+		logger.Warn("could not load stats, starting over: %v", err)
 	}
 
-	providerConfig.FIMTokens = types.FIMTokenConfig{
-		Prefix: config.Provider.FIMTokens.Prefix,
-		Suffix: config.Provider.FIMTokens.Suffix,
-		Middle: config.Provider.FIMTokens.Middle,
+	prov, engineConfig, err := newEngineSetup(config, recorder)
+	if err != nil {
+		return nil, err
 	}
 
-	var prov engine.Provider
-	switch types.ProviderType(config.Provider.Type) {
-	case types.ProviderTypeInline:
-		prov = inline.NewProvider(providerConfig)
-	case types.ProviderTypeFIM:
-		prov = fim.NewProvider(providerConfig)
-	case types.ProviderTypeSweep:
-		prov = sweep.NewProvider(providerConfig)
-	case types.ProviderTypeZeta:
-		prov = zeta.NewProvider(providerConfig)
-	default:
-		return nil, fmt.Errorf("unsupported provider type: %s", config.Provider.Type)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Daemon{
+		config:       config,
+		provider:     prov,
+		engineConfig: engineConfig,
+		metrics:      recorder,
+		sessions:     make(map[int64]*session),
+		socketPath:   getSocketPath(),
+		pidPath:      getPidPath(),
+		shutdown:     make(chan bool, 1),
+		ctx:          ctx,
+		cancel:       cancel,
+	}, nil
+}
+
+// ReloadConfig validates a new config and swaps the provider and engine
+// config in place. Requests already in flight finish with the old provider.
+func (d *Daemon) ReloadConfig(config Config) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	prov, engineConfig, err := newEngineSetup(config, d.metrics)
+	if err != nil {
+		return err
 	}
 
-	buf := buffer.New(buffer.Config{
-		NsID: config.NsID,
-	})
+	d.mu.Lock()
+	old := d.config
+	d.config = config
+	d.provider = prov
+	d.engineConfig = engineConfig
+	d.mu.Unlock()
+
+	for _, sess := range d.activeSessions() {
+		sess.engine.Reconfigure(prov, engineConfig)
+	}
+	if config.LogLevel != "" {
+		logger.SetGlobalLevel(logger.ParseLogLevel(config.LogLevel))
+	}
+	if config.Debug != old.Debug {
+		logger.Warn("debug settings take effect after the daemon restarts")
+	}
+
+	logger.Info("config reloaded: %+v", config.Redacted())
+	return nil
+}
+
+// currentConfig returns the config the daemon is running with
+func (d *Daemon) currentConfig() Config {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.config
+}
+
+// newEngineSetup builds the provider and engine config described by config
+func newEngineSetup(config Config, recorder *metrics.Recorder) (engine.Provider, engine.EngineConfig, error) {
+	breakers := provider.NewBreakers()
+	prov, err := newProviderChain(config.Provider, "provider", breakers)
+	if err != nil {
+		return nil, engine.EngineConfig{}, err
+	}
+
+	rules := make([]engine.ProviderRule, 0, len(config.Rules))
+	for i, ruleConfig := range config.Rules {
+		rule := engine.ProviderRule{
+			Pattern:  ruleConfig.Pattern,
+			FileType: ruleConfig.FileType,
+		}
+		if !ruleConfig.Disabled {
+			rule.Provider, err = newProviderChain(*ruleConfig.Provider, fmt.Sprintf("rules[%d].provider", i+1), breakers)
+			if err != nil {
+				return nil, engine.EngineConfig{}, err
+			}
+		}
+		rules = append(rules, rule)
+	}
 
-	eng, err := engine.NewEngine(prov, buf, engine.EngineConfig{
+	var fileStateStore engine.FileStateStore
+	if config.History.Persist {
+		store, err := engine.NewDiskStore(config.History.Dir, int64(config.History.MaxSizeMB)<<20)
+		if err != nil {
+			return nil, engine.EngineConfig{}, fmt.Errorf("history: %w", err)
+		}
+		fileStateStore = store
+	}
+
+	return prov, engine.EngineConfig{
 		NsID:                config.NsID,
 		CompletionTimeout:   time.Duration(config.Provider.CompletionTimeout) * time.Millisecond,
 		IdleCompletionDelay: time.Duration(config.Behavior.IdleCompletionDelay) * time.Millisecond,
@@ -82,25 +154,98 @@ func NewDaemon(config Config) (*Daemon, error) {
 			AutoAdvance:        config.Behavior.CursorPrediction.AutoAdvance,
 			ProximityThreshold: config.Behavior.CursorPrediction.ProximityThreshold,
 		},
-		MaxDiffTokens: config.Provider.MaxDiffHistoryTokens,
-	}, engine.SystemClock)
+		MaxDiffTokens:    config.Provider.MaxDiffHistoryTokens,
+		DiffHistoryFiles: config.Provider.DiffHistoryFiles,
+		ContextBuffers:   config.Provider.ContextBuffers,
+		FileStateStore:   fileStateStore,
+		ProviderRules:    rules,
+		Metrics:          recorder,
+	}, nil
+}
+
+// newProviderChain builds a provider and wraps it in a fallback chain if it
+// has fallbacks. field is the config path used in error messages.
+func newProviderChain(config ProviderConfig, field string, breakers *provider.Breakers) (engine.Provider, error) {
+	primary, err := newProvider(config, breakers)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", field, err)
+	}
+	if len(config.Fallbacks) == 0 {
+		return primary, nil
+	}
+
+	members := []*provider.Provider{primary}
+	for i, fallbackConfig := range config.Fallbacks {
+		fallback, err := newProvider(fallbackConfig, breakers)
+		if err != nil {
+			return nil, fmt.Errorf("%s.fallbacks[%d]: %w", field, i+1, err)
+		}
+		members = append(members, fallback)
+	}
+	return provider.NewChain(members, time.Duration(config.FallbackTimeout)*time.Millisecond), nil
+}
+
+// newProvider builds a single provider from its config. Its circuit breaker
+// is shared through breakers with the other providers of the same config.
+func newProvider(config ProviderConfig, breakers *provider.Breakers) (*provider.Provider, error) {
+	apiKey, err := config.ResolveAPIKey()
 	if err != nil {
 		return nil, err
 	}
 
-	ctx, cancel := context.WithCancel(context.Background())
+	providerConfig := &types.ProviderConfig{
+		ProviderURL:         config.URL,
+		ProviderModel:       config.Model,
+		ProviderTemperature: config.Temperature,
+		ProviderMaxTokens:   config.MaxTokens,
+		ProviderTopK:        config.TopK,
+		ProviderCandidates:  config.Candidates,
+		CompletionPath:      config.CompletionPath,
+		API:                 types.APIType(config.API),
+		Headers:             config.Headers,
+		APIKey:              apiKey,
+		CursorTargetMarker:  config.CursorTargetMarker,
+		MaxRetries:          config.MaxRetries,
+		BreakerFailures:     config.CircuitBreaker.Failures,
+		BreakerCooldown:     time.Duration(config.CircuitBreaker.Cooldown) * time.Millisecond,
+	}
 
-	return &Daemon{
-		config:     config,
-		provider:   prov,
-		buffer:     buf,
-		engine:     eng,
-		socketPath: getSocketPath(),
-		pidPath:    getPidPath(),
-		shutdown:   make(chan bool, 1),
-		ctx:        ctx,
-		cancel:     cancel,
-	}, nil
+	providerConfig.FIMTokens = types.FIMTokenConfig{
+		Prefix: config.FIMTokens.Prefix,
+		Suffix: config.FIMTokens.Suffix,
+		Middle: config.FIMTokens.Middle,
+	}
+	providerConfig.FIMMode = types.FIMMode(config.FIMMode)
+	providerConfig.KeepAlive = config.KeepAlive
+	providerConfig.Sampling = types.SamplingConfig(config.Sampling)
+	providerConfig.ExtraBody = config.ExtraBody
+
+	var prov *provider.Provider
+	switch types.ProviderType(config.Type) {
+	case types.ProviderTypeInline:
+		prov = inline.NewProvider(providerConfig)
+	case types.ProviderTypeFIM:
+		prov = fim.NewProvider(providerConfig)
+	case types.ProviderTypeInfill:
+		prov = infill.NewProvider(providerConfig)
+	case types.ProviderTypeSweep:
+		prov = sweep.NewProvider(providerConfig)
+	case types.ProviderTypeZeta:
+		prov = zeta.NewProvider(providerConfig)
+	case types.ProviderTypeNextEdit:
+		prov = nextedit.NewProvider(providerConfig)
+	default:
+		return nil, fmt.Errorf("unsupported provider type: %s", config.Type)
+	}
+
+	// Candidates are ranked once the whole response is in, so a provider
+	// asked for several runs in batch mode
+	if config.Candidates > 1 {
+		prov.StreamingType = provider.StreamingNone
+	}
+	prov.ShareBreaker(breakers)
+	prov.Client = provider.NewScheduler(prov.Client, config.MaxConcurrent)
+	return prov, nil
 }
 
 func (d *Daemon) Start() error {
@@ -116,9 +261,6 @@ func (d *Daemon) Start() error {
 
 	logger.Info("daemon listening on socket: %s", d.socketPath)
 
-	// Start engine
-	d.engine.Start(d.ctx)
-
 	// Setup shutdown handling
 	d.setupShutdownHandling()
 
@@ -190,24 +332,181 @@ func (d *Daemon) handleConnection(conn net.Conn) {
 		return
 	}
 
-	// Set nvim client on the buffer and register event handler
-	d.buffer.SetClient(n)
-	d.engine.RegisterEventHandler()
+	sess, err := d.openSession(n)
+	if err != nil {
+		logger.Error("error creating session: %v", err)
+		return
+	}
+	defer d.closeSession(sess)
+
+	if err := d.registerConfigHandlers(n, sess); err != nil {
+		logger.Error("error registering config handlers: %v", err)
+	}
+	if err := d.registerStatusHandler(n); err != nil {
+		logger.Error("error registering status handler: %v", err)
+	}
+	if err := d.registerStatsHandlers(n); err != nil {
+		logger.Error("error registering stats handlers: %v", err)
+	}
 
 	// Serve this connection until it closes or context is done
 	select {
 	case <-d.ctx.Done():
 		return
 	default:
+		go sess.detectWorkspace(n)
 		if err := n.Serve(); err != nil && err != io.EOF {
 			logger.Error("error serving connection: %v", err)
 		}
 	}
 }
 
+// openSession creates and starts a session for a new connection
+func (d *Daemon) openSession(n *nvim.Nvim) (*session, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.nextID++
+	sess, err := newSession(d.nextID, n, d.provider, d.config, d.engineConfig)
+	if err != nil {
+		return nil, err
+	}
+	d.sessions[sess.id] = sess
+
+	sess.engine.Start(d.ctx)
+	sess.engine.RegisterEventHandler()
+	logger.Info("session %d opened", sess.id)
+	return sess, nil
+}
+
+// activeSessions returns a snapshot of the connected sessions
+func (d *Daemon) activeSessions() []*session {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	sessions := make([]*session, 0, len(d.sessions))
+	for _, sess := range d.sessions {
+		sessions = append(sessions, sess)
+	}
+	return sessions
+}
+
+// closeSession stops a session's engine once its connection is gone
+func (d *Daemon) closeSession(sess *session) {
+	d.mu.Lock()
+	delete(d.sessions, sess.id)
+	d.mu.Unlock()
+
+	sess.engine.Stop()
+	d.saveStats()
+	logger.Info("session %d closed", sess.id)
+}
+
+// registerConfigHandlers registers the config RPC methods on a connection.
+// cursortab_check_config is the client's handshake: it takes the client's
+// namespace and reports which settings of its config differ from the running
+// one. cursortab_reload_config applies a client's config.
+func (d *Daemon) registerConfigHandlers(n *nvim.Nvim, sess *session) error {
+	if err := n.RegisterHandler("cursortab_check_config", func(_ *nvim.Nvim, configJSON string) ([]string, error) {
+		config, err := parseConfig(configJSON)
+		if err != nil {
+			return nil, err
+		}
+		sess.setNamespace(config.NsID)
+		diff := d.currentConfig().Diff(config)
+		if len(diff) > 0 {
+			logger.Warn("client config differs from the running config: %v", diff)
+		}
+		return diff, nil
+	}); err != nil {
+		return err
+	}
+
+	return n.RegisterHandler("cursortab_reload_config", func(_ *nvim.Nvim, configJSON string) error {
+		config, err := parseConfig(configJSON)
+		if err != nil {
+			logger.Warn("config reload rejected: %v", err)
+			return err
+		}
+		sess.setNamespace(config.NsID)
+		return d.ReloadConfig(config)
+	})
+}
+
+// ProviderStatus is the health of a provider's server as shown by :CursortabStatus
+type ProviderStatus struct {
+	Name    string               `msgpack:"name"`
+	URL     string               `msgpack:"url"`
+	Breaker openai.BreakerStatus `msgpack:"breaker"`
+}
+
+// registerStatusHandler registers cursortab_status, which returns the circuit
+// breaker state of every configured provider that has one
+func (d *Daemon) registerStatusHandler(n *nvim.Nvim) error {
+	return n.RegisterHandler("cursortab_status", func(_ *nvim.Nvim) ([]ProviderStatus, error) {
+		d.mu.Lock()
+		providers := []engine.Provider{d.provider}
+		for _, rule := range d.engineConfig.ProviderRules {
+			if rule.Provider != nil {
+				providers = append(providers, rule.Provider)
+			}
+		}
+		d.mu.Unlock()
+
+		statuses := []ProviderStatus{}
+		seen := make(map[*openai.Breaker]bool)
+		for _, prov := range providers {
+			members := []*provider.Provider{}
+			switch p := prov.(type) {
+			case *provider.Provider:
+				members = append(members, p)
+			case *provider.Chain:
+				members = p.Members
+			}
+			for _, m := range members {
+				breaker := m.CircuitBreaker()
+				if breaker == nil || seen[breaker] {
+					continue
+				}
+				seen[breaker] = true
+				statuses = append(statuses, ProviderStatus{
+					Name:    m.ProviderName(),
+					URL:     m.Config.ProviderURL,
+					Breaker: breaker.Status(),
+				})
+			}
+		}
+		return statuses, nil
+	})
+}
+
+// registerStatsHandlers registers the metrics RPC methods on a connection.
+// cursortab_stats returns the completion metrics recorded so far;
+// cursortab_reset_stats discards them.
+func (d *Daemon) registerStatsHandlers(n *nvim.Nvim) error {
+	if err := n.RegisterHandler("cursortab_stats", func(_ *nvim.Nvim) (metrics.Stats, error) {
+		return d.metrics.Snapshot(), nil
+	}); err != nil {
+		return err
+	}
+
+	return n.RegisterHandler("cursortab_reset_stats", func(_ *nvim.Nvim) error {
+		d.metrics.Reset()
+		d.saveStats()
+		return nil
+	})
+}
+
+// saveStats writes the completion metrics to disk
+func (d *Daemon) saveStats() {
+	if err := d.metrics.Save(); err != nil {
+		logger.Warn("could not save stats: %v", err)
+	}
+}
+
 func (d *Daemon) monitorIdleShutdown() {
 	// In debug mode, shut down immediately when no clients are connected
-	if d.config.Debug.ImmediateShutdown {
+	if d.currentConfig().Debug.ImmediateShutdown {
 		ticker := time.NewTicker(1 * time.Second)
 		defer ticker.Stop()
 
@@ -251,7 +550,10 @@ func (d *Daemon) monitorIdleShutdown() {
 }
 
 func (d *Daemon) Stop() {
-	d.engine.Stop()
+	for _, sess := range d.activeSessions() {
+		sess.engine.Stop()
+	}
+	d.saveStats()
 	if d.listener != nil {
 		d.listener.Close()
 	}
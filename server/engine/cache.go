@@ -0,0 +1,197 @@
+package engine
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+
+	"cursortab/logger"
+	"cursortab/text"
+	"cursortab/types"
+)
+
+// completionCacheSize is the number of completion responses kept per engine
+const completionCacheSize = 64
+
+// CacheKeyProvider is implemented by providers whose responses can be cached.
+// Implemented by provider.Provider and provider.Chain.
+type CacheKeyProvider interface {
+	// CacheKey hashes the prompt and trimmed window a request would be sent
+	// with. Returns "" if the request would not be sent.
+	CacheKey(req *types.CompletionRequest) string
+}
+
+// cachedCompletion is a completion kept in the cache. Line streams are kept as
+// the stages they were shown in, since their output is never postprocessed.
+type cachedCompletion struct {
+	resp    *types.CompletionResponse     // Batch and token stream results
+	staging *text.StagingResult           // Line stream results
+	target  *types.CursorPredictionTarget // Next edit location named with staging
+}
+
+// completionCache is an LRU cache of completions. Keys include the file path
+// and buffer version, so an edit invalidates the file's entries.
+// It is safe for concurrent use, and all methods are no-ops on a nil cache.
+type completionCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List               // Most recently used first
+	entries map[string]*list.Element // Values are *cacheEntry
+}
+
+type cacheEntry struct {
+	key     string
+	path    string
+	version int
+	value   *cachedCompletion
+}
+
+func newCompletionCache(size int) *completionCache {
+	return &completionCache{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// completionCacheKey builds the cache key for a request to p. Returns "" if p
+// cannot be cached or would not send the request.
+func completionCacheKey(p Provider, req *types.CompletionRequest) string {
+	cp, ok := p.(CacheKeyProvider)
+	if !ok {
+		return ""
+	}
+	key := cp.CacheKey(req)
+	if key == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s\x00%s\x00%d\x00%d:%d\x00%s",
+		providerName(p), req.FilePath, req.Version, req.CursorRow, req.CursorCol, key)
+}
+
+func (c *completionCache) get(key string) *cachedCompletion {
+	if c == nil || key == "" {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*cacheEntry).value
+}
+
+// put stores the completion for req, evicting the least recently used one
+// when full
+func (c *completionCache) put(key string, req *types.CompletionRequest, value *cachedCompletion) {
+	if c == nil || key == "" {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		elem.Value.(*cacheEntry).value = value
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&cacheEntry{
+		key:     key,
+		path:    req.FilePath,
+		version: req.Version,
+		value:   value,
+	})
+	for c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+}
+
+// invalidate drops the entries for path made at other buffer versions
+func (c *completionCache) invalidate(path string, version int) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for elem := c.order.Front(); elem != nil; {
+		next := elem.Next()
+		if entry := elem.Value.(*cacheEntry); entry.path == path && entry.version != version {
+			c.remove(elem)
+		}
+		elem = next
+	}
+}
+
+// clear drops every entry
+func (c *completionCache) clear() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.order.Init()
+	clear(c.entries)
+}
+
+func (c *completionCache) remove(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.entries, elem.Value.(*cacheEntry).key)
+}
+
+// lookupCache sets the cache key of a request about to be sent to p and
+// returns the cached completion for it, if any. Entries for older versions of
+// the file are dropped first.
+func (e *Engine) lookupCache(info *requestInfo, p Provider, req *types.CompletionRequest) *cachedCompletion {
+	e.cache.invalidate(req.FilePath, req.Version)
+	info.cacheKey = completionCacheKey(p, req)
+
+	cached := e.cache.get(info.cacheKey)
+	if cached == nil {
+		return nil
+	}
+	logger.Debug("%s: completion served from cache", info.provider)
+	e.config.Metrics.RecordCacheHit(info.provider)
+	return cached
+}
+
+// cacheStream caches the stages of a line stream that ran to completion.
+// Streams cut short by the timeout and streams with no changes are not cached.
+func (e *Engine) cacheStream(info requestInfo, ss *StreamingState, staging *text.StagingResult, target *types.CursorPredictionTarget) {
+	if staging == nil || ss.Context.Err() != nil {
+		return
+	}
+	e.cache.put(info.cacheKey, ss.Request, &cachedCompletion{staging: staging, target: target})
+}
+
+// showCachedCompletion shows a cached completion as if it had just arrived
+func (e *Engine) showCachedCompletion(cached *cachedCompletion) {
+	if cached.resp != nil {
+		e.state = statePendingCompletion
+		e.handleCompletionReadyImpl(cached.resp)
+		return
+	}
+
+	e.syncBuffer()
+	if e.showStaging(e.restage(cached.staging)) {
+		e.providerTarget = cached.target
+	}
+}
+
+// restage checks again whether the first of the cached stages needs a jump,
+// since the view may have scrolled since they were built
+func (e *Engine) restage(staging *text.StagingResult) *text.StagingResult {
+	viewportTop, viewportBottom := e.buffer.ViewportBounds()
+	return &text.StagingResult{
+		Stages: staging.Stages,
+		FirstNeedsNavigation: text.StageNeedsNavigation(
+			staging.Stages[0],
+			e.buffer.Row(),
+			viewportTop, viewportBottom,
+			e.config.CursorPrediction.ProximityThreshold,
+		),
+	}
+}
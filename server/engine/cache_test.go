@@ -0,0 +1,134 @@
+package engine
+
+import (
+	"context"
+	"cursortab/assert"
+	"cursortab/metrics"
+	"cursortab/types"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// cachingProvider is a mockProvider whose requests can be cached
+type cachingProvider struct {
+	*mockProvider
+}
+
+func (p *cachingProvider) CacheKey(req *types.CompletionRequest) string {
+	return "prompt"
+}
+
+func (p *cachingProvider) calls() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.completionCalls
+}
+
+// cachingLineStreamProvider is a mockLineStreamProvider whose requests can be cached
+type cachingLineStreamProvider struct {
+	*mockLineStreamProvider
+}
+
+func (p *cachingLineStreamProvider) CacheKey(req *types.CompletionRequest) string {
+	return "prompt"
+}
+
+func testResponse(line string) *cachedCompletion {
+	return &cachedCompletion{resp: &types.CompletionResponse{
+		Completions: []*types.Completion{{StartLine: 1, EndLineInc: 1, Lines: []string{line}}},
+	}}
+}
+
+func TestCompletionCache_EvictsLeastRecentlyUsed(t *testing.T) {
+	c := newCompletionCache(2)
+	req := &types.CompletionRequest{FilePath: "a.go", Version: 1}
+
+	c.put("a", req, testResponse("a"))
+	c.put("b", req, testResponse("b"))
+	c.get("a")
+	c.put("c", req, testResponse("c"))
+
+	assert.NotNil(t, c.get("a"), "recently used entry kept")
+	assert.Nil(t, c.get("b"), "least recently used entry evicted")
+	assert.NotNil(t, c.get("c"), "new entry")
+}
+
+func TestCompletionCache_InvalidatesOtherVersions(t *testing.T) {
+	c := newCompletionCache(completionCacheSize)
+	c.put("old", &types.CompletionRequest{FilePath: "a.go", Version: 1}, testResponse("old"))
+	c.put("current", &types.CompletionRequest{FilePath: "a.go", Version: 2}, testResponse("current"))
+	c.put("other", &types.CompletionRequest{FilePath: "b.go", Version: 1}, testResponse("other"))
+
+	c.invalidate("a.go", 2)
+
+	assert.Nil(t, c.get("old"), "older version dropped")
+	assert.NotNil(t, c.get("current"), "current version kept")
+	assert.NotNil(t, c.get("other"), "other files kept")
+}
+
+func TestRequestCompletion_ServedFromCache(t *testing.T) {
+	buf := newMockBuffer()
+	prov := &cachingProvider{mockProvider: newMockProvider()}
+	eng := createTestEngine(buf, prov.mockProvider, newMockClock())
+	eng.provider = prov
+	eng.mainCtx = context.Background()
+	eng.config.Metrics, _ = metrics.NewRecorder("")
+
+	request := func() {
+		eng.clearAll()
+		eng.state = stateIdle
+		eng.requestCompletion(types.CompletionSourceTyping)
+		if eng.state != statePendingCompletion {
+			return
+		}
+		select {
+		case event := <-eng.eventChan:
+			eng.handleBackgroundEvent(event)
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for completion")
+		}
+	}
+
+	request()
+	request()
+	assert.Equal(t, 1, prov.calls(), "second request served from cache")
+	assert.Equal(t, stateHasCompletion, eng.state, "cached completion shown")
+	stats := eng.config.Metrics.Snapshot().Providers["unknown"]
+	assert.Equal(t, int64(1), stats.CacheHits, "cache hit recorded")
+
+	buf.version++
+	request()
+	assert.Equal(t, 2, prov.calls(), "edited buffer requested anew")
+
+	eng.Reconfigure(prov, eng.config)
+	request()
+	assert.Equal(t, 3, prov.calls(), "reconfigure clears the cache")
+}
+
+func TestStreamingCompletion_CachedAsStages(t *testing.T) {
+	buf := newMockBuffer()
+	buf.lines = nil
+	for i := 1; i <= 5; i++ {
+		buf.lines = append(buf.lines, fmt.Sprintf("line %d", i))
+	}
+	eng := createTestEngine(buf, newMockProvider(), newMockClock())
+	eng.mainCtx = context.Background()
+	stream := newMockLineStream()
+	eng.provider = &cachingLineStreamProvider{&mockLineStreamProvider{mockProvider: newMockProvider(), stream: stream}}
+
+	eng.requestCompletion(types.CompletionSourceTyping)
+	for _, line := range []string{"LINE 1", "line 2", "line 3", "line 4", "line 5"} {
+		eng.handleStreamLine(line)
+	}
+	eng.handleStreamCompleteSimple()
+	assert.Equal(t, stateHasCompletion, eng.state, "streamed completion shown")
+
+	eng.clearAll()
+	eng.state = stateIdle
+	eng.requestCompletion(types.CompletionSourceTyping)
+
+	assert.Nil(t, eng.streamingState, "no stream started")
+	assert.Equal(t, stateHasCompletion, eng.state, "cached stages shown")
+	assert.Equal(t, []string{"LINE 1"}, eng.completions[0].Lines, "cached stage")
+}
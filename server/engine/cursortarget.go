@@ -0,0 +1,204 @@
+package engine
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"cursortab/logger"
+	"cursortab/text"
+	"cursortab/types"
+)
+
+// providerTargetSearchRadius is how many lines around its line number a
+// provider cursor target's expected content is looked for, to absorb small
+// miscounts by the model
+const providerTargetSearchRadius = 5
+
+// inOtherFile reports whether target points into a file other than the
+// current buffer
+func (e *Engine) inOtherFile(target *types.CursorPredictionTarget) bool {
+	return target != nil && target.RelativePath != "" && target.RelativePath != e.buffer.Path()
+}
+
+// workspaceFile returns the path of a file given relative to the current
+// buffer's workspace. Target paths come from model output, so paths that
+// leave the workspace are rejected rather than read or opened.
+func (e *Engine) workspaceFile(relativePath string) (string, error) {
+	workspace := e.buffer.WorkspacePath()
+	if workspace == "" || filepath.IsAbs(relativePath) {
+		return "", fmt.Errorf("%s is not in the workspace", relativePath)
+	}
+	path := filepath.Join(workspace, relativePath)
+	rel, err := filepath.Rel(workspace, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is not in the workspace", relativePath)
+	}
+	return path, nil
+}
+
+// readWorkspaceFile reads the lines of a file given relative to the current
+// buffer's workspace
+func (e *Engine) readWorkspaceFile(relativePath string) ([]string, error) {
+	path, err := e.workspaceFile(relativePath)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n"), nil
+}
+
+// resolveProviderTarget checks a cursor target named by the provider against
+// the buffer, or against the file on disk for targets in other files. The
+// target line is moved to the nearest line holding the expected content.
+// Returns nil if the file cannot be read or its content cannot be found.
+func (e *Engine) resolveProviderTarget(target *types.CursorPredictionTarget) *types.CursorPredictionTarget {
+	if target == nil {
+		return nil
+	}
+
+	path := e.buffer.Path()
+	lines := e.buffer.Lines()
+	if e.inOtherFile(target) {
+		var err error
+		if lines, err = e.readWorkspaceFile(target.RelativePath); err != nil {
+			logger.Debug("ignoring cursor target: %v", err)
+			return nil
+		}
+		path = target.RelativePath
+	}
+
+	line := int(target.LineNumber)
+	expected := strings.TrimSpace(target.ExpectedContent)
+	matches := func(l int) bool {
+		return l >= 1 && l <= len(lines) && (expected == "" || strings.TrimSpace(lines[l-1]) == expected)
+	}
+
+	found := 0
+	for d := 0; d <= providerTargetSearchRadius && found == 0; d++ {
+		if matches(line - d) {
+			found = line - d
+		} else if matches(line + d) {
+			found = line + d
+		}
+		if expected == "" {
+			break // Without content to look for, only the line itself is checked
+		}
+	}
+	if found == 0 {
+		logger.Debug("ignoring cursor target, %s:%d does not hold %q", path, line, target.ExpectedContent)
+		return nil
+	}
+
+	return &types.CursorPredictionTarget{
+		RelativePath:    path,
+		LineNumber:      int32(found),
+		ExpectedContent: target.ExpectedContent,
+		ShouldRetrigger: target.ShouldRetrigger,
+	}
+}
+
+// useProviderTarget makes a cursor target named by the provider the current
+// one if it checks out. Returns false if it does not.
+func (e *Engine) useProviderTarget(target *types.CursorPredictionTarget) bool {
+	resolved := e.resolveProviderTarget(target)
+	if resolved == nil {
+		return false
+	}
+	e.cursorTarget = resolved
+	return true
+}
+
+// renameTarget follows an accepted completion that renamed an identifier into
+// other files: it returns a cursor target to the first remaining use of the
+// old name in the most recently used file of the workspace that has one.
+// Open buffers are searched first, so unsaved changes count; files the
+// engine has seen but that are not open are read from disk.
+// Returns nil unless the rename is complete in the current file.
+func (e *Engine) renameTarget(oldLines, newLines []string) *types.CursorPredictionTarget {
+	oldName, _, ok := text.RenamedIdentifier(oldLines, newLines)
+	workspacePath := e.buffer.WorkspacePath()
+	if !ok || workspacePath == "" {
+		return nil
+	}
+	for _, line := range e.buffer.Lines() {
+		if text.IndexWord(line, oldName) >= 0 {
+			return nil // Uses left here come first
+		}
+	}
+
+	openPaths := make(map[string]bool)
+	for _, use := range e.buffer.FindWord(oldName) {
+		openPaths[use.Path] = true
+		if use.Line > 0 && !filepath.IsAbs(use.Path) {
+			return newRenameTarget(oldName, use.Path, use.Line, use.Text)
+		}
+	}
+
+	// Other files the engine has seen in this workspace, most recently used first
+	currentKey := fileKey(workspacePath, e.buffer.Path())
+	var paths []string
+	for key := range e.fileStateStore {
+		rel, err := filepath.Rel(workspacePath, key)
+		if key == currentKey || err != nil || strings.HasPrefix(rel, "..") || openPaths[rel] {
+			continue
+		}
+		paths = append(paths, rel)
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		return e.fileStateStore[fileKey(workspacePath, paths[i])].LastAccessNs >
+			e.fileStateStore[fileKey(workspacePath, paths[j])].LastAccessNs
+	})
+
+	for _, path := range paths {
+		lines, err := e.readWorkspaceFile(path)
+		if err != nil {
+			continue
+		}
+		for i, line := range lines {
+			if text.IndexWord(line, oldName) >= 0 {
+				return newRenameTarget(oldName, path, i+1, line)
+			}
+		}
+	}
+	return nil
+}
+
+// newRenameTarget returns the cursor target to a remaining use of a renamed
+// identifier on line (1-indexed) of path
+func newRenameTarget(oldName, path string, line int, content string) *types.CursorPredictionTarget {
+	logger.Debug("rename of %s continues in %s:%d", oldName, path, line)
+	return &types.CursorPredictionTarget{
+		RelativePath:    path,
+		LineNumber:      int32(line),
+		ExpectedContent: strings.TrimSpace(content),
+		ShouldRetrigger: true,
+	}
+}
+
+// acceptFileTarget switches to the file of a cursor target in another file
+// and requests a completion at the target line. Stages and prefetched
+// completions belong to the file left behind and are dropped.
+func (e *Engine) acceptFileTarget() {
+	target := e.cursorTarget
+	e.clearAll()
+	e.state = stateIdle
+
+	path, err := e.workspaceFile(target.RelativePath)
+	if err != nil {
+		logger.Warn("ignoring cursor target: %v", err)
+		return
+	}
+	if err := e.buffer.OpenFile(path, int(target.LineNumber)); err != nil {
+		logger.Error("error opening %s: %v", path, err)
+		return
+	}
+	if target.ShouldRetrigger {
+		e.requestCompletion(types.CompletionSourceTyping)
+	}
+}
@@ -0,0 +1,208 @@
+package engine
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"cursortab/types"
+)
+
+// FileStateStore persists file states across daemon restarts, keyed by
+// workspace root and workspace-relative file path.
+// Implemented by DiskStore.
+type FileStateStore interface {
+	// Load returns the saved state for a file, or nil if there is none
+	Load(workspacePath, filePath string) (*FileState, error)
+	Save(workspacePath, filePath string, state *FileState) error
+	Delete(workspacePath, filePath string) error
+}
+
+// diskStoreExt is the extension of entry files; the store only ever touches
+// entry files (see isEntryName) directly inside its directory
+const diskStoreExt = ".json"
+
+// diskRecord is the on-disk form of a FileState
+type diskRecord struct {
+	WorkspacePath string             `json:"workspace_path"`
+	FilePath      string             `json:"file_path"`
+	SavedAt       int64              `json:"saved_at"` // Unix nanoseconds
+	PreviousLines []string           `json:"previous_lines"`
+	OriginalLines []string           `json:"original_lines"`
+	DiffHistories []*types.DiffEntry `json:"diff_histories"`
+	Version       int                `json:"version"`
+}
+
+// DiskStore is a FileStateStore that keeps one JSON file per source file in a
+// single directory. When the entries exceed maxBytes, the least recently
+// saved ones are removed.
+type DiskStore struct {
+	mu       sync.Mutex
+	dir      string
+	maxBytes int64
+}
+
+// NewDiskStore creates the store directory if needed. dir must be absolute.
+func NewDiskStore(dir string, maxBytes int64) (*DiskStore, error) {
+	if !filepath.IsAbs(dir) {
+		return nil, fmt.Errorf("file state directory must be absolute: %q", dir)
+	}
+	if maxBytes <= 0 {
+		return nil, fmt.Errorf("file state size cap must be > 0, got %d", maxBytes)
+	}
+
+	dir = filepath.Clean(dir)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("creating file state directory: %w", err)
+	}
+
+	return &DiskStore{dir: dir, maxBytes: maxBytes}, nil
+}
+
+// entryPath returns the file holding a file's state. Names are hashes, so
+// paths from the editor can never point outside the store directory.
+func (s *DiskStore) entryPath(workspacePath, filePath string) string {
+	sum := sha256.Sum256([]byte(workspacePath + "\x00" + filePath))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:])+diskStoreExt)
+}
+
+func (s *DiskStore) Load(workspacePath, filePath string) (*FileState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := os.ReadFile(s.entryPath(workspacePath, filePath))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var record diskRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, fmt.Errorf("decoding file state: %w", err)
+	}
+	if record.WorkspacePath != workspacePath || record.FilePath != filePath {
+		return nil, nil
+	}
+
+	return &FileState{
+		PreviousLines: record.PreviousLines,
+		DiffHistories: record.DiffHistories,
+		OriginalLines: record.OriginalLines,
+		LastAccessNs:  record.SavedAt,
+		Version:       record.Version,
+	}, nil
+}
+
+func (s *DiskStore) Save(workspacePath, filePath string, state *FileState) error {
+	data, err := json.Marshal(diskRecord{
+		WorkspacePath: workspacePath,
+		FilePath:      filePath,
+		SavedAt:       time.Now().UnixNano(),
+		PreviousLines: state.PreviousLines,
+		OriginalLines: state.OriginalLines,
+		DiffHistories: state.DiffHistories,
+		Version:       state.Version,
+	})
+	if err != nil {
+		return err
+	}
+	if int64(len(data)) > s.maxBytes {
+		return fmt.Errorf("file state for %s is %d bytes, over the %d byte cap", filePath, len(data), s.maxBytes)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Write to a temporary file first so readers never see a partial entry
+	tmp, err := os.CreateTemp(s.dir, "tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.entryPath(workspacePath, filePath)); err != nil {
+		return err
+	}
+
+	return s.enforceCap()
+}
+
+func (s *DiskStore) Delete(workspacePath, filePath string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := os.Remove(s.entryPath(workspacePath, filePath))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// isEntryName reports whether name is one the store creates: a hex sha256
+// followed by diskStoreExt. Other files in a shared directory are never
+// counted or removed.
+func isEntryName(name string) bool {
+	hash, ok := strings.CutSuffix(name, diskStoreExt)
+	if !ok || len(hash) != hex.EncodedLen(sha256.Size) {
+		return false
+	}
+	_, err := hex.DecodeString(hash)
+	return err == nil
+}
+
+// enforceCap removes the least recently saved entries until the store's own
+// entries fit within maxBytes
+func (s *DiskStore) enforceCap() error {
+	dirEntries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+
+	var entries []os.FileInfo
+	var total int64
+	for _, dirEntry := range dirEntries {
+		if !dirEntry.Type().IsRegular() || !isEntryName(dirEntry.Name()) {
+			continue
+		}
+		info, err := dirEntry.Info()
+		if err != nil {
+			continue
+		}
+		entries = append(entries, info)
+		total += info.Size()
+	}
+	if total <= s.maxBytes {
+		return nil
+	}
+
+	// Oldest first
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].ModTime().Before(entries[j].ModTime())
+	})
+	for _, info := range entries {
+		if total <= s.maxBytes {
+			break
+		}
+		if err := os.Remove(filepath.Join(s.dir, info.Name())); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		total -= info.Size()
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package engine
+
+import (
+	"cursortab/assert"
+	"cursortab/types"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewDiskStore_Validation(t *testing.T) {
+	_, err := NewDiskStore("relative/dir", 1024)
+	assert.Error(t, err, "relative dir rejected")
+
+	_, err = NewDiskStore(t.TempDir(), 0)
+	assert.Error(t, err, "zero cap rejected")
+}
+
+func TestDiskStore_RoundTrip(t *testing.T) {
+	store, err := NewDiskStore(t.TempDir(), 1<<20)
+	assert.NoError(t, err, "NewDiskStore")
+
+	state := &FileState{
+		PreviousLines: []string{"a"},
+		OriginalLines: []string{"a", "b"},
+		DiffHistories: []*types.DiffEntry{{Original: "a", Updated: "b"}},
+		Version:       3,
+	}
+	assert.NoError(t, store.Save("/repo", "main.go", state), "Save")
+
+	loaded, err := store.Load("/repo", "main.go")
+	assert.NoError(t, err, "Load")
+	assert.NotNil(t, loaded, "state found")
+	assert.Equal(t, state.OriginalLines, loaded.OriginalLines, "original lines")
+	assert.Equal(t, "b", loaded.DiffHistories[0].Updated, "diff history")
+	assert.Equal(t, 3, loaded.Version, "version")
+
+	other, err := store.Load("/other", "main.go")
+	assert.NoError(t, err, "Load other workspace")
+	assert.Nil(t, other, "keyed by workspace")
+
+	assert.NoError(t, store.Delete("/repo", "main.go"), "Delete")
+	loaded, _ = store.Load("/repo", "main.go")
+	assert.Nil(t, loaded, "deleted")
+	assert.NoError(t, store.Delete("/repo", "main.go"), "Delete missing entry")
+}
+
+func TestDiskStore_StaysInsideDir(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "history")
+	store, err := NewDiskStore(dir, 1<<20)
+	assert.NoError(t, err, "NewDiskStore")
+
+	state := &FileState{OriginalLines: []string{"x"}}
+	assert.NoError(t, store.Save("/repo", "../../escape.go", state), "Save")
+	assert.NoError(t, store.Save("..", "/etc/passwd", state), "Save")
+
+	rootEntries, _ := os.ReadDir(root)
+	assert.Len(t, 1, rootEntries, "nothing written next to the store dir")
+
+	entries, _ := os.ReadDir(dir)
+	assert.Len(t, 2, entries, "one entry per file")
+	for _, entry := range entries {
+		assert.True(t, strings.HasSuffix(entry.Name(), diskStoreExt), "entry name "+entry.Name())
+	}
+}
+
+func TestDiskStore_SizeCap(t *testing.T) {
+	dir := t.TempDir()
+	state := &FileState{OriginalLines: []string{strings.Repeat("x", 400)}}
+	store, err := NewDiskStore(dir, 1000)
+	assert.NoError(t, err, "NewDiskStore")
+
+	// Unrelated files in the directory are left alone, even older .json ones
+	old := time.Now().Add(-time.Hour)
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "notes.txt"), []byte(strings.Repeat("n", 2000)), 0o600), "WriteFile")
+	foreign := filepath.Join(dir, "settings.json")
+	assert.NoError(t, os.WriteFile(foreign, []byte(strings.Repeat("n", 2000)), 0o600), "WriteFile")
+	os.Chtimes(foreign, old, old)
+
+	assert.NoError(t, store.Save("/repo", "a.go", state), "Save a")
+	os.Chtimes(store.entryPath("/repo", "a.go"), old, old)
+	assert.NoError(t, store.Save("/repo", "b.go", state), "Save b")
+	assert.NoError(t, store.Save("/repo", "c.go", state), "Save c")
+
+	a, _ := store.Load("/repo", "a.go")
+	c, _ := store.Load("/repo", "c.go")
+	assert.Nil(t, a, "oldest entry evicted")
+	assert.NotNil(t, c, "newest entry kept")
+
+	_, err = os.Stat(filepath.Join(dir, "notes.txt"))
+	assert.NoError(t, err, "unrelated file kept")
+	_, err = os.Stat(foreign)
+	assert.NoError(t, err, "foreign .json file kept")
+
+	huge := &FileState{OriginalLines: []string{strings.Repeat("x", 2000)}}
+	assert.Error(t, store.Save("/repo", "huge.go", huge), "entry over the cap rejected")
+}
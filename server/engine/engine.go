@@ -5,7 +5,10 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"runtime/debug"
+	"slices"
+	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -13,6 +16,7 @@ import (
 
 	"cursortab/buffer"
 	"cursortab/logger"
+	"cursortab/metrics"
 	"cursortab/text"
 	"cursortab/types"
 	"cursortab/utils"
@@ -25,7 +29,9 @@ type Buffer interface {
 	Lines() []string
 	Row() int
 	Col() int
-	Path() string
+	Path() string          // Relative to WorkspacePath() when inside it
+	WorkspacePath() string // Workspace root of the current buffer
+	FileType() string
 	Version() int
 	ViewportBounds() (top, bottom int)
 	PreviousLines() []string
@@ -34,12 +40,18 @@ type Buffer interface {
 	SetFileContext(prev, orig []string, diffs []*types.DiffEntry)
 	HasChanges(startLine, endLineInc int, lines []string) bool
 	PrepareCompletion(startLine, endLineInc int, lines []string, groups []*text.Group) buffer.Batch
+	ApplyEdit(startLine, endLineInc int, lines []string, row, col int) error
 	CommitPending()
-	CommitUserEdits() bool // Returns true if changes were committed
+	CommitUserEdits() bool           // Returns true if changes were committed
+	RevertLastAccept() (bool, error) // Returns true if an accepted completion was reverted
 	ShowCursorTarget(line int) error
+	ShowFileTarget(path string, line int) error // Jump indicator to a line in another file
 	ClearUI() error
 	MoveCursor(line int, center, mark bool) error
+	OpenFile(path string, line int) error // Switches to path (absolute) with the cursor on line
 	LinterErrors() *types.LinterErrors
+	ContextBuffers(maxBuffers, maxLines int) []*types.FileChunk // Other open buffers, most recently used first
+	FindWord(word string) []*buffer.WordUse                     // First use of word in other open buffers, most recently used first
 	RegisterEventHandler(handler func(event string)) error
 }
 
@@ -87,6 +99,7 @@ const (
 type LineStream interface {
 	LinesChan() <-chan string // Channel for complete lines
 	Cancel()                  // Cancel the stream early
+	FinishReason() string     // Why the stream ended, once LinesChan is closed ("error" if it failed)
 }
 
 // TrimmedContext provides access to trim info from the provider.
@@ -96,6 +109,12 @@ type TrimmedContext interface {
 	GetTrimmedLines() []string // Lines sent to the model (nil if no trimming)
 }
 
+// CursorTargetContext provides access to the next edit location a model named
+// in a streamed completion. Implemented by provider.Context.
+type CursorTargetContext interface {
+	GetCursorTarget() *types.CursorPredictionTarget // nil if the model named none
+}
+
 // StreamingState holds state during incremental line streaming
 type StreamingState struct {
 	// Stage building
@@ -108,10 +127,17 @@ type StreamingState struct {
 	// Accumulated text for postprocessing
 	AccumulatedText strings.Builder
 
-	// Provider context for postprocessing
+	// Provider serving the stream and its context for postprocessing
+	Provider        LineStreamProvider
 	ProviderContext any
 	Validated       bool
 
+	// Stream the lines come from, asked how it ended
+	Stream LineStream
+
+	// Request context, done if the stream was cut short
+	Context context.Context
+
 	// Request data needed for finalization
 	Request *types.CompletionRequest
 
@@ -125,9 +151,16 @@ type TokenStreamingState struct {
 	// Accumulated text (cumulative, not deltas)
 	AccumulatedText string
 
-	// Provider context for postprocessing
+	// Provider serving the stream and its context for postprocessing
+	Provider        TokenStreamProvider
 	ProviderContext any
 
+	// Stream the text comes from, asked how it ended
+	Stream LineStream
+
+	// Request context, done if the stream was cut short
+	Context context.Context
+
 	// Request data needed for finalization
 	Request *types.CompletionRequest
 
@@ -169,11 +202,16 @@ type EngineConfig struct {
 	IdleCompletionDelay time.Duration
 	TextChangeDebounce  time.Duration
 	CursorPrediction    CursorPredictionConfig
-	MaxDiffTokens       int // Maximum tokens for diff history per file (0 = no limit)
+	MaxDiffTokens       int               // Maximum tokens for diff history, shared by all files (0 = no limit)
+	DiffHistoryFiles    int               // Recently edited files included in diff history (<= 1 = current file only)
+	ContextBuffers      int               // Other open buffers whose text is sent with requests (0 = none)
+	FileStateStore      FileStateStore    // Persists file states across restarts (nil = memory only)
+	ProviderRules       []ProviderRule    // Per-file provider overrides; first match wins
+	Metrics             *metrics.Recorder // Completion metrics shared by all engines (nil = disabled)
 }
 
 type Engine struct {
-	WorkspacePath string
+	WorkspacePath string // Fallback root for buffers the client reports no workspace for
 	WorkspaceID   string
 
 	provider        Provider
@@ -199,17 +237,36 @@ type Engine struct {
 	applyBatch   buffer.Batch
 	cursorTarget *types.CursorPredictionTarget
 
+	// Next edit location the provider named for after the completion on screen
+	providerTarget *types.CursorPredictionTarget
+
 	// Staged completion state (for multi-stage completions)
 	stagedCompletion *types.StagedCompletion
 
+	// Ranked alternatives to the completion on screen (nil when there is only one)
+	candidates   []*types.Completion
+	candidateIdx int
+
+	// Metrics state: the latest completion and prefetch requests and the
+	// stage on screen whose outcome is pending
+	lastRequest     requestInfo
+	prefetchRequest requestInfo
+	shown           *shownCompletion
+
 	// Original buffer lines when completion was shown (for partial typing optimization)
 	completionOriginalLines []string
 
 	// Prefetch state
 	prefetchedCompletions  []*types.Completion
+	prefetchedDisjoint     bool                // prefetchedCompletions are hunks of one edit
+	prefetchedStaging      *text.StagingResult // Stages of a streamed prefetch
 	prefetchedCursorTarget *types.CursorPredictionTarget
 	prefetchState          prefetchState
 
+	// Prefetch streaming state (line-by-line, stages built off-screen)
+	prefetchStreamingState *StreamingState
+	prefetchLinesChan      <-chan string // Lines channel (nil when not streaming)
+
 	// Streaming state (line-by-line)
 	streamingState  *StreamingState
 	streamingCancel context.CancelFunc
@@ -225,6 +282,9 @@ type Engine struct {
 
 	// Per-file state that persists across file switches (for context restoration)
 	fileStateStore map[string]*FileState
+
+	// Recent completions by provider, prompt and cursor
+	cache *completionCache
 }
 
 func NewEngine(provider Provider, buf Buffer, config EngineConfig, clock Clock) (*Engine, error) {
@@ -255,6 +315,7 @@ func NewEngine(provider Provider, buf Buffer, config EngineConfig, clock Clock)
 		prefetchState:          prefetchNone,
 		stopped:                false,
 		fileStateStore:         make(map[string]*FileState),
+		cache:                  newCompletionCache(completionCacheSize),
 	}, nil
 }
 
@@ -306,10 +367,13 @@ func (e *Engine) Stop() {
 		e.completions = nil
 		e.applyBatch = nil
 		e.stagedCompletion = nil
+		e.providerTarget = nil
 		e.prefetchedCompletions = nil
+		e.prefetchedDisjoint = false
 		e.prefetchedCursorTarget = nil
 		e.prefetchState = prefetchNone
 		e.completionOriginalLines = nil
+		e.shown = nil
 		// Close event channel (this will cause eventLoop to exit if it hasn't already)
 		close(e.eventChan)
 
@@ -337,7 +401,11 @@ func (e *Engine) clearState(opts ClearOptions) {
 		e.prefetchCancel = nil
 		e.prefetchState = prefetchNone
 		e.prefetchedCompletions = nil
+		e.prefetchedDisjoint = false
+		e.prefetchedStaging = nil
 		e.prefetchedCursorTarget = nil
+		e.prefetchStreamingState = nil
+		e.prefetchLinesChan = nil
 	}
 	if opts.ClearCursorTarget {
 		e.cursorTarget = nil
@@ -349,8 +417,11 @@ func (e *Engine) clearState(opts ClearOptions) {
 	e.applyBatch = nil
 	if opts.ClearStaged {
 		e.stagedCompletion = nil
+		e.providerTarget = nil
 	}
 	e.completionOriginalLines = nil
+	e.candidates = nil
+	e.candidateIdx = 0
 }
 
 // clearAll clears everything including prefetch and staged completions
@@ -389,6 +460,7 @@ func (e *Engine) eventLoop(ctx context.Context) {
 		e.mu.RLock()
 		linesChan := e.streamLinesChan
 		tokenChan := e.tokenStreamChan
+		prefetchChan := e.prefetchLinesChan
 		e.mu.RUnlock()
 
 		select {
@@ -419,6 +491,28 @@ func (e *Engine) eventLoop(ctx context.Context) {
 			e.handleStreamLine(line)
 			e.mu.Unlock()
 
+		case line, ok := <-prefetchChan:
+			// Prefetch stream handling - stages are built off-screen
+			// When prefetchChan is nil, this case is never selected
+			e.mu.Lock()
+			if e.stopped {
+				e.mu.Unlock()
+				return
+			}
+			if e.prefetchLinesChan != prefetchChan {
+				// Prefetch changed while we were waiting, ignore stale data
+				e.mu.Unlock()
+				continue
+			}
+			if !ok {
+				// Channel closed - prefetch stream complete
+				e.handlePrefetchStreamComplete()
+				e.mu.Unlock()
+				continue
+			}
+			e.handlePrefetchStreamLine(line)
+			e.mu.Unlock()
+
 		case text, ok := <-tokenChan:
 			// Token stream handling (cumulative text for inline completion)
 			// When tokenChan is nil, this case is never selected
@@ -541,6 +635,7 @@ func (e *Engine) handleBackgroundEvent(event Event) bool {
 }
 
 func (e *Engine) reject() {
+	e.recordOutcome(metrics.Rejected)
 	e.clearState(ClearOptions{
 		CancelCurrent:     true,
 		CancelPrefetch:    true,
@@ -562,10 +657,31 @@ func (e *Engine) syncBuffer() {
 	}
 
 	if result != nil && result.BufferChanged {
-		e.handleFileSwitch(result.OldPath, result.NewPath, e.buffer.Lines())
+		e.handleFileSwitch(result.OldWorkspace, result.OldPath, result.NewWorkspace, result.NewPath, e.buffer.Lines())
+	}
+
+	// The user undid an accepted completion: keep the saved history in step
+	if result != nil && result.HistoryReverted {
+		e.saveCurrentFileState()
 	}
 }
 
+// revertLastAccept undoes the most recently accepted completion and removes
+// it from the edit history
+func (e *Engine) revertLastAccept() {
+	e.syncBuffer()
+	reverted, err := e.buffer.RevertLastAccept()
+	if err != nil {
+		logger.Error("error reverting completion: %v", err)
+		return
+	}
+	if !reverted {
+		logger.Debug("no accepted completion to revert")
+		return
+	}
+	e.saveCurrentFileState()
+}
+
 func (e *Engine) requestCompletion(source types.CompletionSource) {
 	// Check if stopped before making request
 	if e.stopped {
@@ -576,27 +692,40 @@ func (e *Engine) requestCompletion(source types.CompletionSource) {
 
 	req := &types.CompletionRequest{
 		Source:            source,
-		WorkspacePath:     e.WorkspacePath,
+		WorkspacePath:     e.buffer.WorkspacePath(),
 		WorkspaceID:       e.WorkspaceID,
 		FilePath:          e.buffer.Path(),
+		FileType:          e.buffer.FileType(),
 		Lines:             e.buffer.Lines(),
 		Version:           e.buffer.Version(),
 		PreviousLines:     e.buffer.PreviousLines(),
 		FileDiffHistories: e.getAllFileDiffHistories(),
+		ContextBuffers:    e.getContextBuffers(),
 		CursorRow:         e.buffer.Row(),
 		CursorCol:         e.buffer.Col(),
 		ViewportHeight:    e.getViewportHeightConstraint(),
 		LinterErrors:      e.buffer.LinterErrors(),
 	}
 
+	provider := e.providerFor(req.FilePath, req.FileType)
+	if provider == nil {
+		logger.Debug("completions disabled for %s", req.FilePath)
+		return
+	}
+	e.lastRequest = e.startRequest(provider, req.FileType)
+	if cached := e.lookupCache(&e.lastRequest, provider, req); cached != nil {
+		e.showCachedCompletion(cached)
+		return
+	}
+
 	// Check if provider supports streaming
-	if streamProvider, ok := e.provider.(LineStreamProvider); ok {
+	if streamProvider, ok := provider.(LineStreamProvider); ok {
 		switch streamProvider.GetStreamingType() {
 		case StreamingTypeLines:
 			e.requestStreamingCompletion(streamProvider, req)
 			return
 		case StreamingTypeTokens:
-			if tokenProvider, ok := e.provider.(TokenStreamProvider); ok {
+			if tokenProvider, ok := provider.(TokenStreamProvider); ok {
 				e.requestTokenStreamingCompletion(tokenProvider, req)
 				return
 			}
@@ -609,12 +738,19 @@ func (e *Engine) requestCompletion(source types.CompletionSource) {
 	ctx, cancel := context.WithTimeout(e.mainCtx, e.config.CompletionTimeout)
 	e.currentCancel = cancel
 
+	recorder := e.config.Metrics
+	cache := e.cache
+	info := e.lastRequest
+
 	go func() {
 		defer cancel()
 
-		result, err := e.provider.GetCompletion(ctx, req)
+		result, err := provider.GetCompletion(ctx, req)
 
 		if err != nil {
+			if !errors.Is(err, context.Canceled) {
+				recorder.RecordError(info.provider)
+			}
 			select {
 			case e.eventChan <- Event{Type: EventCompletionError, Data: err}:
 			case <-e.mainCtx.Done():
@@ -622,6 +758,12 @@ func (e *Engine) requestCompletion(source types.CompletionSource) {
 			return
 		}
 
+		// The whole completion arrives at once, so the first line does too
+		elapsed := e.clock.Now().Sub(info.start)
+		recorder.RecordFirstLine(info.provider, elapsed)
+		recorder.RecordTotal(info.provider, elapsed)
+		cache.put(info.cacheKey, req, &cachedCompletion{resp: result})
+
 		select {
 		case e.eventChan <- Event{Type: EventCompletionReady, Data: result}:
 		case <-e.mainCtx.Done():
@@ -639,11 +781,23 @@ func (e *Engine) requestStreamingCompletion(provider LineStreamProvider, req *ty
 	// Prepare the stream
 	stream, providerCtx, err := provider.PrepareLineStream(ctx, req)
 	if err != nil {
+		e.config.Metrics.RecordError(e.lastRequest.provider)
 		cancel()
 		e.state = stateIdle
 		return
 	}
 
+	// Initialize streaming state
+	e.streamingState = e.newStreamingState(ctx, provider, stream, providerCtx, req)
+
+	// Set stream channel directly - event loop will select on it
+	e.streamLinesChan = stream.LinesChan()
+	e.streamLineNum = 0
+}
+
+// newStreamingState creates the state for a line stream, with a stage builder
+// placed at the request's cursor
+func (e *Engine) newStreamingState(ctx context.Context, provider LineStreamProvider, stream LineStream, providerCtx any, req *types.CompletionRequest) *StreamingState {
 	// Get old lines for incremental diff building.
 	// Extract trim info from provider context if available.
 	windowStart := 0
@@ -659,24 +813,22 @@ func (e *Engine) requestStreamingCompletion(provider LineStreamProvider, req *ty
 
 	viewportTop, viewportBottom := e.buffer.ViewportBounds()
 
-	// Initialize streaming state
-	e.streamingState = &StreamingState{
+	return &StreamingState{
 		StageBuilder: text.NewIncrementalStageBuilder(
 			oldLines,
 			windowStart+1, // baseLineOffset (1-indexed)
 			e.config.CursorPrediction.ProximityThreshold,
 			viewportTop,
 			viewportBottom,
-			e.buffer.Row(),
+			req.CursorRow,
 			req.FilePath,
 		),
+		Provider:        provider,
 		ProviderContext: providerCtx,
+		Stream:          stream,
+		Context:         ctx,
 		Request:         req,
 	}
-
-	// Set stream channel directly - event loop will select on it
-	e.streamLinesChan = stream.LinesChan()
-	e.streamLineNum = 0
 }
 
 // requestTokenStreamingCompletion handles token-by-token streaming completions (inline)
@@ -689,6 +841,7 @@ func (e *Engine) requestTokenStreamingCompletion(provider TokenStreamProvider, r
 	// Prepare the stream
 	stream, providerCtx, err := provider.PrepareTokenStream(ctx, req)
 	if err != nil {
+		e.config.Metrics.RecordError(e.lastRequest.provider)
 		cancel()
 		e.state = stateIdle
 		return
@@ -705,7 +858,10 @@ func (e *Engine) requestTokenStreamingCompletion(provider TokenStreamProvider, r
 	// Initialize token streaming state
 	e.tokenStreamingState = &TokenStreamingState{
 		AccumulatedText: "",
+		Provider:        provider,
 		ProviderContext: providerCtx,
+		Stream:          stream,
+		Context:         ctx,
 		Request:         req,
 		LinePrefix:      linePrefix,
 		LineNum:         req.CursorRow,
@@ -726,6 +882,13 @@ func (e *Engine) handleCursorTarget() {
 		return
 	}
 
+	// Another file is never close enough to skip the jump
+	if e.inOtherFile(e.cursorTarget) {
+		e.state = stateHasCursorTarget
+		e.buffer.ShowFileTarget(e.cursorTarget.RelativePath, int(e.cursorTarget.LineNumber))
+		return
+	}
+
 	distance := abs(int(e.cursorTarget.LineNumber) - e.buffer.Row())
 	if distance <= e.config.CursorPrediction.ProximityThreshold {
 		// Close enough - don't show cursor prediction
@@ -802,6 +965,14 @@ func (e *Engine) clearCompletionUIOnly() {
 }
 
 func (e *Engine) acceptCompletion() {
+	e.recordOutcome(metrics.Accepted)
+
+	// What the completion replaces, to follow a rename into other files
+	var replaced, accepted []string
+	if len(e.completions) > 0 {
+		replaced, accepted = e.completionOriginalLines, e.completions[0].Lines
+	}
+
 	if e.applyBatch != nil {
 		if err := e.applyBatch.Execute(); err != nil {
 			logger.Error("error applying completion: %v", err)
@@ -820,10 +991,12 @@ func (e *Engine) acceptCompletion() {
 	if e.stagedCompletion != nil {
 		// Track cumulative offset for unequal line count stages
 		currentStage := e.getStage(e.stagedCompletion.CurrentIdx)
+		acceptedEnd := 0
 		if currentStage != nil {
 			oldLineCount := currentStage.BufferEnd - currentStage.BufferStart + 1
 			newLineCount := len(currentStage.Lines)
 			e.stagedCompletion.CumulativeOffset += newLineCount - oldLineCount
+			acceptedEnd = currentStage.BufferEnd
 		}
 
 		e.stagedCompletion.CurrentIdx++
@@ -831,17 +1004,30 @@ func (e *Engine) acceptCompletion() {
 			// More stages remaining - sync buffer and show cursor target to next stage
 			e.syncBuffer()
 
-			// Apply cumulative offset to remaining stages if line counts changed
-			if e.stagedCompletion.CumulativeOffset != 0 {
+			// Apply cumulative offset to remaining stages if line counts changed.
+			// Stages are ordered by distance from the cursor, so only those below
+			// the accepted stage moved; the same goes for cursor targets,
+			// including the accepted stage's own jump to the next stage.
+			if offset := e.stagedCompletion.CumulativeOffset; offset != 0 && currentStage != nil {
+				shift := func(target *types.CursorPredictionTarget) {
+					if target != nil && int(target.LineNumber) > acceptedEnd {
+						target.LineNumber += int32(offset)
+					}
+				}
+				if e.cursorTarget != currentStage.CursorTarget {
+					shift(e.cursorTarget)
+				}
+				shift(currentStage.CursorTarget)
 				for i := e.stagedCompletion.CurrentIdx; i < len(e.stagedCompletion.Stages); i++ {
 					stage := e.getStage(i)
-					if stage != nil {
-						stage.BufferStart += e.stagedCompletion.CumulativeOffset
-						stage.BufferEnd += e.stagedCompletion.CumulativeOffset
+					if stage == nil {
+						continue
 					}
-					if stage != nil && stage.CursorTarget != nil {
-						stage.CursorTarget.LineNumber += int32(e.stagedCompletion.CumulativeOffset)
+					if stage.BufferStart > acceptedEnd {
+						stage.BufferStart += offset
+						stage.BufferEnd += offset
 					}
+					shift(stage.CursorTarget)
 				}
 				// Reset cumulative offset after applying (already factored in)
 				e.stagedCompletion.CumulativeOffset = 0
@@ -869,9 +1055,20 @@ func (e *Engine) acceptCompletion() {
 	// Sync buffer to get the updated state after applying completion
 	e.syncBuffer()
 
+	// The provider's next edit location takes over from the last stage's,
+	// failing that a finished rename continues in other files
+	target := e.providerTarget
+	e.providerTarget = nil
+	if !e.useProviderTarget(target) {
+		if rename := e.renameTarget(replaced, accepted); rename != nil {
+			e.cursorTarget = rename
+		}
+	}
+
 	// Prefetch next completion if cursor target requests retrigger (after applying current completion)
-	// Skip if prefetch is already in flight (e.g., triggered at n-1 stage)
-	if e.cursorTarget != nil && e.cursorTarget.ShouldRetrigger && e.prefetchState == prefetchNone {
+	// Skip if prefetch is already in flight (e.g., triggered at n-1 stage) or the target is in another file
+	if e.cursorTarget != nil && e.cursorTarget.ShouldRetrigger && e.prefetchState == prefetchNone &&
+		!e.inOtherFile(e.cursorTarget) {
 		// Prefetch targeting the predicted cursor line
 		overrideRow := max(1, int(e.cursorTarget.LineNumber))
 		e.requestPrefetch(types.CompletionSourceTyping, overrideRow, 0)
@@ -894,19 +1091,63 @@ func (e *Engine) saveCurrentFileState() {
 		Version:       e.buffer.Version(),
 	}
 
-	e.fileStateStore[e.buffer.Path()] = state
-	e.trimFileStateStore(2) // Keep at most 2 files
+	e.fileStateStore[fileKey(e.buffer.WorkspacePath(), e.buffer.Path())] = state
+	e.trimFileStateStore(max(2, e.config.DiffHistoryFiles))
+	e.persistFileState(e.buffer.WorkspacePath(), e.buffer.Path(), state)
+}
+
+// persistFileState saves a file's state to the persistent store, if enabled
+func (e *Engine) persistFileState(workspacePath, filePath string, state *FileState) {
+	if e.config.FileStateStore == nil {
+		return
+	}
+	if err := e.config.FileStateStore.Save(workspacePath, filePath, state); err != nil {
+		logger.Warn("error saving file state for %s: %v", filePath, err)
+	}
+}
+
+// loadFileState returns a file's state from the persistent store, if enabled
+func (e *Engine) loadFileState(workspacePath, filePath string) *FileState {
+	if e.config.FileStateStore == nil {
+		return nil
+	}
+	state, err := e.config.FileStateStore.Load(workspacePath, filePath)
+	if err != nil {
+		logger.Warn("error loading file state for %s: %v", filePath, err)
+		return nil
+	}
+	return state
+}
+
+// discardFileState removes a stale file state from the persistent store, if enabled
+func (e *Engine) discardFileState(workspacePath, filePath string) {
+	if e.config.FileStateStore == nil {
+		return
+	}
+	if err := e.config.FileStateStore.Delete(workspacePath, filePath); err != nil {
+		logger.Warn("error discarding file state for %s: %v", filePath, err)
+	}
+}
+
+// fileKey identifies a file in the file state store. Paths are relative to
+// their workspace, so the same relative path in two projects gets two keys.
+func fileKey(workspacePath, filePath string) string {
+	if filePath == "" || workspacePath == "" || filepath.IsAbs(filePath) {
+		return filePath
+	}
+	return filepath.Join(workspacePath, filePath)
 }
 
 // handleFileSwitch manages file state when switching between files.
 // Called after Sync detects a buffer change. Returns true if state was restored.
-func (e *Engine) handleFileSwitch(oldPath, newPath string, currentLines []string) bool {
-	if oldPath == newPath {
+func (e *Engine) handleFileSwitch(oldWorkspace, oldPath, newWorkspace, newPath string, currentLines []string) bool {
+	oldKey, newKey := fileKey(oldWorkspace, oldPath), fileKey(newWorkspace, newPath)
+	if oldKey == newKey {
 		return false
 	}
 
 	// Save state of the file we're leaving
-	if oldPath != "" {
+	if oldKey != "" {
 		state := &FileState{
 			PreviousLines: copyLines(e.buffer.PreviousLines()),
 			DiffHistories: copyDiffs(e.buffer.DiffHistories()),
@@ -914,11 +1155,22 @@ func (e *Engine) handleFileSwitch(oldPath, newPath string, currentLines []string
 			LastAccessNs:  e.clock.Now().UnixNano(),
 			Version:       e.buffer.Version(),
 		}
-		e.fileStateStore[oldPath] = state
+		e.fileStateStore[oldKey] = state
+		if len(state.DiffHistories) > 0 {
+			e.persistFileState(oldWorkspace, oldPath, state)
+		}
 	}
 
-	// Try to restore state for the new file
-	if state, exists := e.fileStateStore[newPath]; exists {
+	// Try to restore state for the new file, falling back to the persistent
+	// store for files not seen since the daemon started
+	state, exists := e.fileStateStore[newKey]
+	if !exists && newKey != "" {
+		if state = e.loadFileState(newWorkspace, newPath); state != nil {
+			e.fileStateStore[newKey] = state
+			exists = true
+		}
+	}
+	if exists {
 		if e.isFileStateValid(state, currentLines) {
 			// Restore the saved state
 			e.buffer.SetFileContext(state.PreviousLines, state.OriginalLines, state.DiffHistories)
@@ -926,7 +1178,8 @@ func (e *Engine) handleFileSwitch(oldPath, newPath string, currentLines []string
 			return true
 		}
 		// State is stale (file changed externally) - discard it
-		delete(e.fileStateStore, newPath)
+		delete(e.fileStateStore, newKey)
+		e.discardFileState(newWorkspace, newPath)
 	}
 
 	// New file or stale state - initialize fresh (PreviousLines stays nil for new files)
@@ -1008,30 +1261,124 @@ func (e *Engine) trimFileStateStore(maxFiles int) {
 	}
 }
 
-// getAllFileDiffHistories returns diff history for the current file only.
-// This prevents context pollution from other files' diffs.
+// getAllFileDiffHistories returns the diff history sent with a request.
+// By default it holds the current file's diffs only, which prevents context
+// pollution from other files. With DiffHistoryFiles > 1 the most recently
+// edited other files are included too, ordered oldest first so the current
+// file's edits come last.
 func (e *Engine) getAllFileDiffHistories() []*types.FileDiffHistory {
-	// Only return diffs for the current file
-	if e.buffer.Path() == "" || len(e.buffer.DiffHistories()) == 0 {
-		return nil
+	// Newest first: current file, then other files by last access
+	var histories []*types.FileDiffHistory
+	if e.buffer.Path() != "" && len(e.buffer.DiffHistories()) > 0 {
+		histories = append(histories, &types.FileDiffHistory{
+			FileName:    e.buffer.Path(),
+			DiffHistory: copyDiffs(e.buffer.DiffHistories()),
+		})
 	}
-
-	// Copy to ensure immutability
-	diffs := copyDiffs(e.buffer.DiffHistories())
+	histories = append(histories, e.recentFileDiffHistories(e.config.DiffHistoryFiles-1)...)
 
 	// Apply token limiting if configured
 	if e.config.MaxDiffTokens > 0 {
-		diffs = utils.TrimDiffEntries(diffs, e.config.MaxDiffTokens)
+		histories = trimDiffHistories(histories, e.config.MaxDiffTokens)
 	}
 
-	if len(diffs) == 0 {
+	if len(histories) == 0 {
 		return nil
 	}
 
-	return []*types.FileDiffHistory{{
-		FileName:    e.buffer.Path(),
-		DiffHistory: diffs,
-	}}
+	slices.Reverse(histories)
+	return histories
+}
+
+// contextBufferLines is the number of lines sent from each context buffer
+const contextBufferLines = 64
+
+// getContextBuffers returns the text of other open buffers sent with a
+// request, around where the cursor last was in each
+func (e *Engine) getContextBuffers() []*types.FileChunk {
+	if e.config.ContextBuffers <= 0 {
+		return nil
+	}
+	return e.buffer.ContextBuffers(e.config.ContextBuffers, contextBufferLines)
+}
+
+// recentFileDiffHistories returns the diff histories of up to maxFiles other
+// files from the file state store, most recently accessed first
+func (e *Engine) recentFileDiffHistories(maxFiles int) []*types.FileDiffHistory {
+	if maxFiles <= 0 {
+		return nil
+	}
+
+	workspacePath := e.buffer.WorkspacePath()
+	currentKey := fileKey(workspacePath, e.buffer.Path())
+
+	keys := make([]string, 0, len(e.fileStateStore))
+	for key, state := range e.fileStateStore {
+		if key != currentKey && len(state.DiffHistories) > 0 {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return e.fileStateStore[keys[i]].LastAccessNs > e.fileStateStore[keys[j]].LastAccessNs
+	})
+	if len(keys) > maxFiles {
+		keys = keys[:maxFiles]
+	}
+
+	histories := make([]*types.FileDiffHistory, 0, len(keys))
+	for _, key := range keys {
+		histories = append(histories, &types.FileDiffHistory{
+			FileName:    relativeFileName(workspacePath, key),
+			DiffHistory: copyDiffs(e.fileStateStore[key].DiffHistories),
+		})
+	}
+	return histories
+}
+
+// trimDiffHistories fits histories (newest file first) into a token budget
+// shared by all files. Newer files are served first; the newest file always
+// keeps its latest diff, while older files are dropped once the budget is spent.
+func trimDiffHistories(histories []*types.FileDiffHistory, maxTokens int) []*types.FileDiffHistory {
+	result := make([]*types.FileDiffHistory, 0, len(histories))
+	remaining := maxTokens
+	for i, history := range histories {
+		if remaining <= 0 {
+			break
+		}
+
+		diffs := utils.TrimDiffEntries(history.DiffHistory, remaining)
+		chars := diffChars(diffs)
+		if i > 0 && chars > utils.EstimateCharsFromTokens(remaining) {
+			break // Even the latest diff of this file does not fit
+		}
+		remaining -= chars / utils.AvgCharsPerToken
+
+		if len(diffs) > 0 {
+			result = append(result, &types.FileDiffHistory{
+				FileName:    history.FileName,
+				DiffHistory: diffs,
+			})
+		}
+	}
+	return result
+}
+
+// diffChars returns the size of diff entries as counted by utils.TrimDiffEntries
+func diffChars(diffs []*types.DiffEntry) int {
+	chars := 0
+	for _, diff := range diffs {
+		chars += len(diff.Original) + len(diff.Updated)
+	}
+	return chars
+}
+
+// relativeFileName names a file state store key relative to a workspace,
+// keeping the key as is for files outside of it
+func relativeFileName(workspacePath, key string) string {
+	if rel, err := filepath.Rel(workspacePath, key); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel
+	}
+	return key
 }
 
 // copyLines creates a deep copy of a string slice
@@ -1059,6 +1406,11 @@ func (e *Engine) acceptCursorTarget() {
 		return
 	}
 
+	if e.inOtherFile(e.cursorTarget) {
+		e.acceptFileTarget()
+		return
+	}
+
 	err := e.buffer.MoveCursor(int(e.cursorTarget.LineNumber), true, true)
 	if err != nil {
 		logger.Error("error moving cursor: %v", err)
@@ -1078,6 +1430,12 @@ func (e *Engine) acceptCursorTarget() {
 		return
 	}
 
+	// A prefetch still streaming takes over as the current completion
+	if e.prefetchStreamingState != nil {
+		e.promotePrefetchStream()
+		return
+	}
+
 	// If prefetch is in progress, wait for it to complete instead of triggering new request
 	if e.prefetchState == prefetchInFlight {
 		e.prefetchState = prefetchWaitingForTab
@@ -1113,6 +1471,7 @@ func (e *Engine) showCurrentStage() {
 	}}
 	e.cursorTarget = stage.CursorTarget
 	e.state = stateHasCompletion
+	e.recordShown(e.stagedCompletion.CurrentIdx)
 
 	// Use PrepareCompletion with pre-computed groups from stage
 	e.applyBatch = e.buffer.PrepareCompletion(
@@ -1151,10 +1510,15 @@ func (e *Engine) processCompletion(completion *types.Completion) bool {
 	if completion == nil {
 		return false
 	}
+	return e.showStaging(e.stageCompletion(completion))
+}
 
+// stageCompletion splits a completion into stages.
+// Returns nil if it has no changes.
+func (e *Engine) stageCompletion(completion *types.Completion) *text.StagingResult {
 	// Check for actual changes
 	if !e.buffer.HasChanges(completion.StartLine, completion.EndLineInc, completion.Lines) {
-		return false
+		return nil
 	}
 
 	// Extract original lines from buffer
@@ -1187,7 +1551,63 @@ func (e *Engine) processCompletion(completion *types.Completion) bool {
 		completion.Lines,
 		originalLines, // oldLines parameter
 	)
+	return stagingResult
+}
+
+// processHunks shows the disjoint hunks of one edit as a single staged
+// completion. Hunks closer together than the proximity threshold are staged
+// as one, like changes within a single completion. Returns false if the hunks
+// overlap or none has changes.
+func (e *Engine) processHunks(hunks []*types.Completion) bool {
+	defer logger.Trace("engine.processHunks")()
+	if len(hunks) == 0 {
+		return false
+	}
+
+	sorted := append([]*types.Completion(nil), hunks...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].StartLine < sorted[j].StartLine })
+
+	bufferLines := e.buffer.Lines()
+	joined := []*types.Completion{sorted[0]}
+	for _, hunk := range sorted[1:] {
+		last := joined[len(joined)-1]
+		if hunk.StartLine <= last.EndLineInc {
+			logger.Debug("ignoring hunks, %d-%d overlaps %d-%d",
+				hunk.StartLine, hunk.EndLineInc, last.StartLine, last.EndLineInc)
+			return false
+		}
+		if hunk.StartLine-last.EndLineInc-1 > e.config.CursorPrediction.ProximityThreshold ||
+			hunk.StartLine-1 > len(bufferLines) {
+			joined = append(joined, hunk)
+			continue
+		}
+		lines := append([]string(nil), last.Lines...)
+		lines = append(lines, bufferLines[last.EndLineInc:hunk.StartLine-1]...)
+		joined[len(joined)-1] = &types.Completion{
+			StartLine:  last.StartLine,
+			EndLineInc: hunk.EndLineInc,
+			Lines:      append(lines, hunk.Lines...),
+		}
+	}
 
+	results := make([]*text.StagingResult, 0, len(joined))
+	for _, hunk := range joined {
+		results = append(results, e.stageCompletion(hunk))
+	}
+	viewportTop, viewportBottom := e.buffer.ViewportBounds()
+	return e.showStaging(text.MergeStages(
+		results,
+		e.buffer.Row(),
+		viewportTop, viewportBottom,
+		e.config.CursorPrediction.ProximityThreshold,
+		e.buffer.Path(),
+	))
+}
+
+// showStaging stores the stages of a completion and shows the first one, or a
+// jump to it when it is out of view or far from the cursor.
+// Returns false if there are no stages.
+func (e *Engine) showStaging(stagingResult *text.StagingResult) bool {
 	if stagingResult != nil && len(stagingResult.Stages) > 0 {
 		// Convert stages to any slice for storage
 		stagesAny := make([]any, len(stagingResult.Stages))
@@ -1222,6 +1642,80 @@ func (e *Engine) processCompletion(completion *types.Completion) bool {
 	return false
 }
 
+// processCandidates shows the top-ranked candidate and keeps the others for
+// cycling, along with the provider's cursor target for after they are accepted.
+// Disjoint completions are the hunks of one edit and are shown together.
+// Returns false if the top candidate has no changes.
+func (e *Engine) processCandidates(completions []*types.Completion, disjoint bool, target *types.CursorPredictionTarget) bool {
+	if disjoint {
+		if !e.processHunks(completions) {
+			return false
+		}
+		e.providerTarget = target
+		return true
+	}
+	if len(completions) == 0 || !e.processCompletion(completions[0]) {
+		return false
+	}
+	e.providerTarget = target
+	if len(completions) > 1 {
+		e.candidates = completions
+		e.candidateIdx = 0
+	}
+	return true
+}
+
+// cycleCandidate replaces the completion on screen with the next (step 1)
+// or previous (step -1) candidate, skipping candidates with no changes left.
+// Candidates can only be cycled before the first stage is accepted.
+func (e *Engine) cycleCandidate(step int) {
+	if len(e.candidates) < 2 || (e.stagedCompletion != nil && e.stagedCompletion.CurrentIdx > 0) {
+		return
+	}
+
+	candidates := e.candidates
+	current := e.candidateIdx
+	target := e.providerTarget
+	e.syncBuffer()
+	e.clearState(ClearOptions{ClearStaged: true, ClearCursorTarget: true, CallOnReject: true})
+
+	n := len(candidates)
+	for i := 1; i <= n; i++ {
+		idx := ((current+step*i)%n + n) % n
+		if e.processCompletion(candidates[idx]) {
+			e.candidates = candidates
+			e.candidateIdx = idx
+			e.providerTarget = target
+			return
+		}
+	}
+
+	// Nothing left to show
+	e.state = stateIdle
+}
+
+// Reconfigure swaps the default provider and config used for new requests.
+// Requests already in flight finish with the provider they started with.
+func (e *Engine) Reconfigure(provider Provider, config EngineConfig) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.provider = provider
+	e.config = config
+	e.cache.clear()
+	logger.Info("engine reconfigured")
+}
+
+// SetWorkspace sets the fallback workspace for buffers the client reports no
+// workspace root for. workspaceID distinguishes clients that share a path.
+func (e *Engine) SetWorkspace(workspacePath, workspaceID string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.WorkspacePath = workspacePath
+	e.WorkspaceID = workspaceID
+}
+
 // RegisterEventHandler registers the event handler for nvim RPC callbacks.
 // This should be called after buffer.SetClient has been called with a valid nvim connection.
 func (e *Engine) RegisterEventHandler() {
@@ -1233,7 +1727,7 @@ func (e *Engine) RegisterEventHandler() {
 		return
 	}
 
-	// Register the event handler for the new connection
+	// Register the event handler for the session's connection
 	if err := e.buffer.RegisterEventHandler(func(event string) {
 		e.mu.RLock()
 		stopped := e.stopped
@@ -1329,46 +1823,97 @@ func (e *Engine) handleStreamLine(line string) {
 		return
 	}
 
+	validated := ss.Validated
+	finalized, err := ss.addLine(line)
+	if err != nil {
+		e.cancelStreaming()
+		e.state = stateIdle
+		return
+	}
+	if !validated {
+		e.config.Metrics.RecordFirstLine(e.lastRequest.provider, e.clock.Now().Sub(e.lastRequest.start))
+	}
+	if finalized != nil {
+		e.renderStageIfClose(ss, finalized)
+	}
+}
+
+// addLine accumulates a streamed line, validating the first one, and feeds
+// the line before it to the stage builder. The last line is held back until
+// the stream ends in case it is truncated. Returns the stage finalized by the
+// line, if any.
+func (ss *StreamingState) addLine(line string) (*text.Stage, error) {
 	// Accumulate text for postprocessing
 	ss.AccumulatedText.WriteString(line)
 	ss.AccumulatedText.WriteString("\n")
 
 	// First line validation
 	if !ss.Validated {
-		if sp, ok := e.provider.(LineStreamProvider); ok {
-			if err := sp.ValidateFirstLine(ss.ProviderContext, line); err != nil {
-				e.cancelStreaming()
-				e.state = stateIdle
-				return
-			}
+		if err := ss.Provider.ValidateFirstLine(ss.ProviderContext, line); err != nil {
+			return nil, err
 		}
 		ss.Validated = true
 	}
 
 	// Process pending line through stage builder (if any)
+	var finalized *text.Stage
 	if ss.HasPendingLine {
-		finalized := ss.StageBuilder.AddLine(ss.PendingLine)
-		if finalized != nil && !ss.FirstStageRendered {
-			// Check if this stage is close enough to render immediately
-			viewportTop, viewportBottom := e.buffer.ViewportBounds()
-			needsNav := text.StageNeedsNavigation(
-				finalized,
-				e.buffer.Row(),
-				viewportTop, viewportBottom,
-				e.config.CursorPrediction.ProximityThreshold,
-			)
-			if !needsNav {
-				// Stage is close to cursor - render it immediately
-				e.renderStreamedStage(finalized)
-				ss.FirstStageRendered = true
-			}
-			// If needsNav, don't render - let Finalize() handle it with cursor prediction
-		}
+		finalized = ss.StageBuilder.AddLine(ss.PendingLine)
 	}
 
 	// Buffer current line (will be processed on next line or completion)
 	ss.PendingLine = line
 	ss.HasPendingLine = true
+	return finalized, nil
+}
+
+// finish adds the held-back last line and finalizes the stages. Returns nil
+// stages if the stream changed nothing, along with the cursor target the
+// provider named, if any.
+func (ss *StreamingState) finish() (*text.StagingResult, *types.CursorPredictionTarget) {
+	var providerTarget *types.CursorPredictionTarget
+	if tc, ok := ss.ProviderContext.(CursorTargetContext); ok {
+		providerTarget = tc.GetCursorTarget()
+	}
+
+	// Process pending line if not truncated
+	if ss.HasPendingLine {
+		ss.StageBuilder.AddLine(ss.PendingLine)
+		ss.HasPendingLine = false
+	}
+
+	// Finalize remaining stages
+	stagingResult := ss.StageBuilder.Finalize()
+	if stagingResult != nil && len(stagingResult.Stages) == 0 {
+		stagingResult = nil
+	}
+	return stagingResult, providerTarget
+}
+
+// renderStageIfClose renders a stage finalized during streaming if it is the
+// first one close to the cursor. Returns true if it was rendered.
+func (e *Engine) renderStageIfClose(ss *StreamingState, stage *text.Stage) bool {
+	if ss.FirstStageRendered {
+		return false
+	}
+
+	// Check if this stage is close enough to render immediately
+	viewportTop, viewportBottom := e.buffer.ViewportBounds()
+	needsNav := text.StageNeedsNavigation(
+		stage,
+		e.buffer.Row(),
+		viewportTop, viewportBottom,
+		e.config.CursorPrediction.ProximityThreshold,
+	)
+	if needsNav {
+		// Don't render - let Finalize() handle it with cursor prediction
+		return false
+	}
+
+	// Stage is close to cursor - render it immediately
+	e.renderStreamedStage(stage)
+	ss.FirstStageRendered = true
+	return true
 }
 
 // handleStreamCompleteSimple processes stream completion when lines channel closes.
@@ -1384,22 +1929,23 @@ func (e *Engine) handleStreamCompleteSimple() {
 
 	ss := e.streamingState
 	firstStageRendered := ss.FirstStageRendered
+	succeeded := e.recordStreamEnd(e.lastRequest, ss.Stream)
 
-	// Process pending line if not truncated
-	if ss.HasPendingLine {
-		ss.StageBuilder.AddLine(ss.PendingLine)
-		ss.HasPendingLine = false
+	stagingResult, providerTarget := ss.finish()
+	if succeeded {
+		e.cacheStream(e.lastRequest, ss, stagingResult, providerTarget)
 	}
 
-	// Finalize remaining stages
-	stagingResult := ss.StageBuilder.Finalize()
-
 	// Clear streaming state
 	e.streamingState = nil
 	e.streamingCancel = nil
 
-	if stagingResult == nil || len(stagingResult.Stages) == 0 {
+	if stagingResult == nil {
 		e.state = stateIdle
+		// No changes, but the provider may still know where the next edit is
+		if e.useProviderTarget(providerTarget) {
+			e.handleCursorTarget()
+		}
 		return
 	}
 
@@ -1413,6 +1959,7 @@ func (e *Engine) handleStreamCompleteSimple() {
 		CurrentIdx: 0,
 		SourcePath: e.buffer.Path(),
 	}
+	e.providerTarget = providerTarget
 
 	// If we already rendered the first stage during streaming, don't re-render it
 	if firstStageRendered {
@@ -1468,6 +2015,7 @@ func (e *Engine) renderStreamedStage(stage *text.Stage) {
 		Lines:      stage.Lines,
 	}}
 	e.cursorTarget = stage.CursorTarget
+	e.recordShown(0)
 }
 
 // handleTokenChunk processes a cumulative text chunk from token streaming.
@@ -1478,6 +2026,10 @@ func (e *Engine) handleTokenChunk(accumulatedText string) {
 		return
 	}
 
+	if ts.AccumulatedText == "" {
+		e.config.Metrics.RecordFirstLine(e.lastRequest.provider, e.clock.Now().Sub(e.lastRequest.start))
+	}
+
 	// Update accumulated text
 	ts.AccumulatedText = accumulatedText
 
@@ -1516,6 +2068,7 @@ func (e *Engine) handleTokenChunk(accumulatedText string) {
 		Lines:      []string{fullLineText},
 	}}
 	e.completionOriginalLines = []string{oldLine}
+	e.recordShown(0)
 }
 
 // handleTokenStreamComplete processes token stream completion when channel closes.
@@ -1537,6 +2090,7 @@ func (e *Engine) handleTokenStreamComplete() {
 	// Clear token streaming state
 	e.tokenStreamingState = nil
 	e.streamingCancel = nil
+	succeeded := e.recordStreamEnd(e.lastRequest, ts.Stream)
 
 	// If empty, go idle
 	if finalText == "" {
@@ -1546,19 +2100,21 @@ func (e *Engine) handleTokenStreamComplete() {
 	}
 
 	// Run postprocessors through provider
-	tokenProvider, ok := e.provider.(TokenStreamProvider)
-	if !ok {
+	if ts.Provider == nil {
 		e.buffer.ClearUI()
 		e.state = stateIdle
 		return
 	}
 
-	resp, err := tokenProvider.FinishTokenStream(providerCtx, finalText)
+	resp, err := ts.Provider.FinishTokenStream(providerCtx, finalText)
 	if err != nil {
 		e.buffer.ClearUI()
 		e.state = stateIdle
 		return
 	}
+	if succeeded && ts.Context.Err() == nil {
+		e.cache.put(e.lastRequest.cacheKey, req, &cachedCompletion{resp: resp})
+	}
 
 	// Process the response like a normal completion
 	if resp == nil || len(resp.Completions) == 0 {
@@ -1578,7 +2134,7 @@ func (e *Engine) handleTokenStreamComplete() {
 	}
 
 	// Process through normal completion flow (handles staging etc.)
-	if e.processCompletion(completion) {
+	if e.processCandidates(resp.Completions[:1], false, resp.CursorTarget) {
 		e.state = stateHasCompletion
 	} else {
 		e.buffer.ClearUI()
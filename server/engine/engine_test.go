@@ -4,8 +4,12 @@ import (
 	"context"
 	"cursortab/assert"
 	"cursortab/buffer"
+	"cursortab/metrics"
 	"cursortab/text"
 	"cursortab/types"
+	"fmt"
+	"os"
+	"path/filepath"
 	"sync"
 	"testing"
 	"time"
@@ -20,6 +24,8 @@ type mockBuffer struct {
 	row            int
 	col            int
 	path           string
+	workspacePath  string
+	fileType       string
 	version        int
 	viewportTop    int
 	viewportBottom int
@@ -27,13 +33,20 @@ type mockBuffer struct {
 	originalLines  []string
 	diffHistories  []*types.DiffEntry
 	linterErrors   *types.LinterErrors
+	openBuffers    []*buffer.WordUse // Returned by FindWord
 
 	// Track method calls
 	syncCalls              int
+	syncWorkspace          string
 	clearUICalls           int
 	commitPendingCalls     int
 	showCursorTargetLine   int
+	showFileTargetPath     string
+	openedFile             string
 	prepareCompletionCalls int
+	revertCalls            int
+	revertResult           bool // Whether RevertLastAccept finds a completion to revert
+	historyReverted        bool // Reported by the next Sync
 	lastPreparedCompletion struct {
 		startLine  int
 		endLineInc int
@@ -57,7 +70,10 @@ func (b *mockBuffer) Sync(workspacePath string) (*buffer.SyncResult, error) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 	b.syncCalls++
-	return &buffer.SyncResult{BufferChanged: false}, nil
+	b.syncWorkspace = workspacePath
+	reverted := b.historyReverted
+	b.historyReverted = false
+	return &buffer.SyncResult{BufferChanged: false, HistoryReverted: reverted}, nil
 }
 
 func (b *mockBuffer) Lines() []string {
@@ -84,6 +100,18 @@ func (b *mockBuffer) Path() string {
 	return b.path
 }
 
+func (b *mockBuffer) WorkspacePath() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.workspacePath
+}
+
+func (b *mockBuffer) FileType() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.fileType
+}
+
 func (b *mockBuffer) Version() int {
 	b.mu.Lock()
 	defer b.mu.Unlock()
@@ -147,6 +175,18 @@ func (b *mockBuffer) PrepareCompletion(startLine, endLineInc int, lines []string
 	return &mockBatch{}
 }
 
+func (b *mockBuffer) ApplyEdit(startLine, endLineInc int, lines []string, row, col int) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	newLines := append([]string{}, b.lines[:startLine-1]...)
+	newLines = append(newLines, lines...)
+	b.lines = append(newLines, b.lines[endLineInc:]...)
+	b.row = row
+	b.col = col
+	b.commitPendingCalls++
+	return nil
+}
+
 func (b *mockBuffer) CommitPending() {
 	b.mu.Lock()
 	defer b.mu.Unlock()
@@ -157,6 +197,13 @@ func (b *mockBuffer) CommitUserEdits() bool {
 	return false
 }
 
+func (b *mockBuffer) RevertLastAccept() (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.revertCalls++
+	return b.revertResult, nil
+}
+
 func (b *mockBuffer) ShowCursorTarget(line int) error {
 	b.mu.Lock()
 	defer b.mu.Unlock()
@@ -164,6 +211,14 @@ func (b *mockBuffer) ShowCursorTarget(line int) error {
 	return nil
 }
 
+func (b *mockBuffer) ShowFileTarget(path string, line int) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.showFileTargetPath = path
+	b.showCursorTargetLine = line
+	return nil
+}
+
 func (b *mockBuffer) ClearUI() error {
 	b.mu.Lock()
 	defer b.mu.Unlock()
@@ -178,12 +233,30 @@ func (b *mockBuffer) MoveCursor(line int, center, mark bool) error {
 	return nil
 }
 
+func (b *mockBuffer) OpenFile(path string, line int) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.openedFile = path
+	b.row = line
+	return nil
+}
+
 func (b *mockBuffer) LinterErrors() *types.LinterErrors {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 	return b.linterErrors
 }
 
+func (b *mockBuffer) ContextBuffers(maxBuffers, maxLines int) []*types.FileChunk {
+	return nil
+}
+
+func (b *mockBuffer) FindWord(word string) []*buffer.WordUse {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.openBuffers
+}
+
 func (b *mockBuffer) RegisterEventHandler(handler func(event string)) error {
 	return nil
 }
@@ -338,6 +411,71 @@ func TestEngineCreation(t *testing.T) {
 	assert.Equal(t, stateIdle, eng.state, "initial state")
 }
 
+func TestReconfigure(t *testing.T) {
+	buf := newMockBuffer()
+	oldProv := newMockProvider()
+	newProv := newMockProvider()
+
+	eng := createTestEngine(buf, oldProv, newMockClock())
+	eng.mainCtx = context.Background()
+
+	eng.Reconfigure(newProv, EngineConfig{
+		CompletionTimeout:  time.Second,
+		TextChangeDebounce: 10 * time.Millisecond,
+	})
+	assert.Equal(t, 10*time.Millisecond, eng.config.TextChangeDebounce, "config swapped")
+
+	eng.requestCompletion(types.CompletionSourceTyping)
+
+	select {
+	case <-eng.eventChan:
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for completion")
+	}
+	assert.Equal(t, 1, newProv.completionCalls, "new provider called")
+	assert.Equal(t, 0, oldProv.completionCalls, "old provider not called")
+}
+
+func TestSetWorkspace(t *testing.T) {
+	buf := newMockBuffer()
+	eng := createTestEngine(buf, newMockProvider(), newMockClock())
+
+	eng.SetWorkspace("/repo", "/repo-42")
+	eng.syncBuffer()
+
+	assert.Equal(t, "/repo", buf.syncWorkspace, "buffer synced against session workspace")
+	assert.Equal(t, "/repo-42", eng.WorkspaceID, "workspace ID")
+}
+
+func TestRequestCompletion_UsesBufferWorkspace(t *testing.T) {
+	buf := newMockBuffer()
+	buf.workspacePath = "/projects/api"
+	buf.path = "cmd/main.go"
+	prov := newMockProvider()
+
+	eng := createTestEngine(buf, prov, newMockClock())
+	eng.SetWorkspace("/projects/web", "/projects/web-1")
+	eng.mainCtx = context.Background()
+
+	eng.requestCompletion(types.CompletionSourceTyping)
+
+	select {
+	case <-eng.eventChan:
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for completion")
+	}
+	assert.Equal(t, "/projects/api", prov.lastRequest.WorkspacePath, "workspace reported for the buffer")
+	assert.Equal(t, "cmd/main.go", prov.lastRequest.FilePath, "path relative to that workspace")
+}
+
+func TestFileKey(t *testing.T) {
+	assert.Equal(t, "/a/main.go", fileKey("/a", "main.go"), "joined with workspace")
+	assert.Equal(t, "/b/main.go", fileKey("/b", "main.go"), "same path in another workspace")
+	assert.Equal(t, "/other/x.go", fileKey("/a", "/other/x.go"), "outside workspace")
+	assert.Equal(t, "main.go", fileKey("", "main.go"), "no workspace")
+	assert.Equal(t, "", fileKey("/a", ""), "unnamed buffer")
+}
+
 func TestStateString(t *testing.T) {
 	tests := []struct {
 		state state
@@ -493,6 +631,377 @@ func TestReject(t *testing.T) {
 	assert.Greater(t, buf.clearUICalls, 0, "ClearUI should have been called")
 }
 
+func TestMetrics_RecordsOutcomes(t *testing.T) {
+	buf := newMockBuffer()
+	buf.fileType = "go"
+	prov := newMockProvider()
+	eng := createTestEngine(buf, prov, newMockClock())
+	eng.mainCtx = context.Background()
+	eng.config.Metrics, _ = metrics.NewRecorder("")
+
+	showCompletion := func() {
+		eng.requestCompletion(types.CompletionSourceTyping)
+		select {
+		case event := <-eng.eventChan:
+			eng.handleCompletionReadyImpl(event.Data.(*types.CompletionResponse))
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for completion")
+		}
+	}
+
+	showCompletion()
+	eng.showCurrentStage() // Already counted as shown
+	eng.acceptCompletion()
+	eng.clearAll() // Drop the prefetch started on accept
+
+	showCompletion()
+	eng.reject()
+	eng.reject() // Nothing on screen anymore
+
+	stats := eng.config.Metrics.Snapshot()
+	providerStats := stats.Providers["unknown"]
+	assert.NotNil(t, providerStats, "provider stats")
+	assert.Equal(t, int64(3), providerStats.Requests, "requests, including the prefetch")
+	assert.Equal(t, metrics.Outcomes{Shown: 2, Accepted: 1, Rejected: 1}, providerStats.Outcomes, "provider outcomes")
+	assert.Equal(t, metrics.Outcomes{Shown: 2, Accepted: 1, Rejected: 1}, *stats.FileTypes["go"], "filetype outcomes")
+	assert.Len(t, 1, stats.Stages, "stages")
+}
+
+func TestCycleCandidates(t *testing.T) {
+	buf := newMockBuffer()
+	prov := newMockProvider()
+	prov.completionResp = &types.CompletionResponse{
+		Completions: []*types.Completion{
+			{StartLine: 1, EndLineInc: 1, Lines: []string{"first"}},
+			{StartLine: 1, EndLineInc: 1, Lines: []string{"line 1"}}, // No changes left, skipped
+			{StartLine: 1, EndLineInc: 1, Lines: []string{"third"}},
+		},
+	}
+	eng := createTestEngine(buf, prov, newMockClock())
+	eng.mainCtx = context.Background()
+
+	eng.requestCompletion(types.CompletionSourceTyping)
+	select {
+	case event := <-eng.eventChan:
+		eng.handleCompletionReadyImpl(event.Data.(*types.CompletionResponse))
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for completion")
+	}
+	assert.Equal(t, []string{"first"}, buf.lastPreparedCompletion.lines, "top candidate shown")
+	assert.Len(t, 3, eng.candidates, "candidates kept")
+
+	eng.dispatch(Event{Type: EventNextCandidate})
+	assert.Equal(t, stateHasCompletion, eng.state, "state after next")
+	assert.Equal(t, []string{"third"}, buf.lastPreparedCompletion.lines, "no-op candidate skipped")
+	assert.Equal(t, 2, eng.candidateIdx, "candidate index")
+
+	eng.dispatch(Event{Type: EventNextCandidate})
+	assert.Equal(t, []string{"first"}, buf.lastPreparedCompletion.lines, "wraps around")
+
+	eng.dispatch(Event{Type: EventPrevCandidate})
+	assert.Equal(t, []string{"third"}, buf.lastPreparedCompletion.lines, "previous wraps around")
+
+	eng.acceptCompletion()
+	assert.Nil(t, eng.candidates, "candidates dropped on accept")
+}
+
+func TestAcceptPartial(t *testing.T) {
+	buf := newMockBuffer()
+	buf.lines = []string{"x := foo", "// end"}
+	prov := newMockProvider()
+	prov.completionResp = &types.CompletionResponse{
+		Completions: []*types.Completion{
+			{StartLine: 1, EndLineInc: 1, Lines: []string{"x := foo.Bar(baz)", "return x"}},
+		},
+	}
+	eng := createTestEngine(buf, prov, newMockClock())
+	eng.mainCtx = context.Background()
+
+	eng.requestCompletion(types.CompletionSourceTyping)
+	select {
+	case event := <-eng.eventChan:
+		eng.handleCompletionReadyImpl(event.Data.(*types.CompletionResponse))
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for completion")
+	}
+	assert.Equal(t, stateHasCompletion, eng.state, "completion shown")
+
+	eng.dispatch(Event{Type: EventAcceptWord})
+	assert.Equal(t, []string{"x := foo.", "// end"}, buf.lines, "first word applied")
+	assert.Equal(t, 1, buf.row, "cursor row after the word")
+	assert.Equal(t, 9, buf.col, "cursor col after the word")
+	assert.Equal(t, stateHasCompletion, eng.state, "rest still shown")
+	assert.Equal(t, 1, buf.lastPreparedCompletion.startLine, "remainder start")
+	assert.Equal(t, 1, buf.lastPreparedCompletion.endLineInc, "remainder end")
+
+	eng.dispatch(Event{Type: EventAcceptLine})
+	assert.Equal(t, []string{"x := foo.Bar(baz)", "// end"}, buf.lines, "rest of the line applied")
+	assert.Equal(t, []string{"x := foo.Bar(baz)", "return x"}, buf.lastPreparedCompletion.lines, "added line still shown")
+	assert.Equal(t, 1, buf.lastPreparedCompletion.endLineInc, "next line not replaced")
+
+	commits := buf.commitPendingCalls
+	eng.dispatch(Event{Type: EventAcceptLine})
+	assert.Equal(t, commits+1, buf.commitPendingCalls, "last line accepts the whole stage")
+	assert.Nil(t, eng.stagedCompletion, "stage finished")
+	assert.NotEqual(t, stateHasCompletion, eng.state, "completion gone")
+}
+
+func TestProcessHunks(t *testing.T) {
+	buf := newMockBuffer()
+	buf.lines = make([]string, 20)
+	for i := range buf.lines {
+		buf.lines[i] = fmt.Sprintf("line %d", i+1)
+	}
+	buf.row = 10
+	eng := createTestEngine(buf, newMockProvider(), newMockClock())
+	eng.mainCtx = context.Background()
+
+	assert.False(t, eng.processHunks([]*types.Completion{
+		{StartLine: 3, EndLineInc: 4, Lines: []string{"a", "b"}},
+		{StartLine: 4, EndLineInc: 5, Lines: []string{"c", "d"}},
+	}), "overlapping hunks")
+
+	handled := eng.processCandidates([]*types.Completion{
+		{StartLine: 19, EndLineInc: 19, Lines: []string{"C"}},
+		{StartLine: 2, EndLineInc: 2, Lines: []string{"B"}},
+		{StartLine: 12, EndLineInc: 12, Lines: []string{"x", "y"}},
+	}, true, nil)
+	assert.True(t, handled, "hunks shown")
+	assert.Equal(t, stateHasCompletion, eng.state, "nearest hunk shown first")
+	assert.Len(t, 3, eng.stagedCompletion.Stages, "one stage per hunk")
+	assert.Equal(t, 12, eng.getStage(0).BufferStart, "nearest hunk")
+	assert.Equal(t, 2, eng.getStage(1).BufferStart, "then the one above")
+	assert.Equal(t, 19, eng.getStage(2).BufferStart, "then the farthest")
+
+	// The first stage adds a line: only what lies below it moves
+	eng.acceptCompletion()
+	assert.Equal(t, 2, eng.getStage(1).BufferStart, "stage above not shifted")
+	assert.Equal(t, 20, eng.getStage(2).BufferStart, "stage below shifted")
+	assert.Equal(t, int32(20), eng.getStage(1).CursorTarget.LineNumber, "jump to stage below shifted")
+	assert.Equal(t, int32(2), eng.cursorTarget.LineNumber, "jump to stage above kept")
+}
+
+func TestProcessHunks_JoinsCloseHunks(t *testing.T) {
+	buf := newMockBuffer()
+	buf.lines = []string{"a", "b", "c", "d", "e"}
+	eng := createTestEngine(buf, newMockProvider(), newMockClock())
+
+	assert.True(t, eng.processHunks([]*types.Completion{
+		{StartLine: 1, EndLineInc: 1, Lines: []string{"A"}},
+		{StartLine: 3, EndLineInc: 3, Lines: []string{"C"}},
+	}), "hunks shown")
+	assert.Len(t, 1, eng.stagedCompletion.Stages, "close hunks staged together")
+	assert.Equal(t, []string{"A", "b", "C"}, buf.lastPreparedCompletion.lines, "lines between hunks kept")
+}
+
+func TestResolveProviderTarget(t *testing.T) {
+	buf := newMockBuffer()
+	buf.lines = []string{"a", "b", "  target", "c", "d", "e", "f", "g", "h", "i"}
+	eng := createTestEngine(buf, newMockProvider(), newMockClock())
+
+	tests := []struct {
+		name     string
+		target   *types.CursorPredictionTarget
+		expected int32 // 0 = rejected
+	}{
+		{"exact line", &types.CursorPredictionTarget{LineNumber: 3, ExpectedContent: "target"}, 3},
+		{"line miscounted", &types.CursorPredictionTarget{LineNumber: 6, ExpectedContent: "target"}, 3},
+		{"content too far", &types.CursorPredictionTarget{LineNumber: 9, ExpectedContent: "target"}, 0},
+		{"content missing", &types.CursorPredictionTarget{LineNumber: 3, ExpectedContent: "other"}, 0},
+		{"no content in range", &types.CursorPredictionTarget{LineNumber: 7}, 7},
+		{"no content out of range", &types.CursorPredictionTarget{LineNumber: 11}, 0},
+		{"other file", &types.CursorPredictionTarget{RelativePath: "other.go", LineNumber: 3}, 0},
+		{"nil", nil, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolved := eng.resolveProviderTarget(tt.target)
+			if tt.expected == 0 {
+				assert.Nil(t, resolved, "target rejected")
+				return
+			}
+			assert.NotNil(t, resolved, "target accepted")
+			assert.Equal(t, tt.expected, resolved.LineNumber, "resolved line")
+			assert.Equal(t, "test.go", resolved.RelativePath, "resolved path")
+		})
+	}
+}
+
+func TestHandleCompletionReady_PrefersProviderTarget(t *testing.T) {
+	buf := newMockBuffer()
+	buf.lines = make([]string, 20)
+	for i := range buf.lines {
+		buf.lines[i] = fmt.Sprintf("line %d", i+1)
+	}
+	eng := createTestEngine(buf, newMockProvider(), newMockClock())
+	noop := []*types.Completion{{StartLine: 1, EndLineInc: 2, Lines: []string{"line 1", "line 2"}}}
+
+	eng.handleCompletionReadyImpl(&types.CompletionResponse{
+		Completions:  noop,
+		CursorTarget: &types.CursorPredictionTarget{LineNumber: 14, ExpectedContent: "line 15", ShouldRetrigger: true},
+	})
+	assert.Equal(t, stateHasCursorTarget, eng.state, "jump to provider target")
+	assert.Equal(t, 15, buf.showCursorTargetLine, "target moved to its expected content")
+
+	eng.state = stateIdle
+	buf.showCursorTargetLine = 0
+	eng.handleCompletionReadyImpl(&types.CompletionResponse{
+		Completions:  noop,
+		CursorTarget: &types.CursorPredictionTarget{LineNumber: 14, ExpectedContent: "gone"},
+	})
+	assert.Equal(t, stateIdle, eng.state, "invalid target falls back to the end of the completion")
+	assert.Equal(t, 0, buf.showCursorTargetLine, "no jump shown")
+}
+
+func TestAcceptCompletion_UsesProviderTarget(t *testing.T) {
+	buf := newMockBuffer()
+	buf.lines = make([]string, 20)
+	for i := range buf.lines {
+		buf.lines[i] = fmt.Sprintf("line %d", i+1)
+	}
+	eng := createTestEngine(buf, newMockProvider(), newMockClock())
+	eng.mainCtx = context.Background()
+
+	eng.handleCompletionReadyImpl(&types.CompletionResponse{
+		Completions:  []*types.Completion{{StartLine: 1, EndLineInc: 1, Lines: []string{"changed"}}},
+		CursorTarget: &types.CursorPredictionTarget{RelativePath: "test.go", LineNumber: 12, ExpectedContent: "line 12", ShouldRetrigger: true},
+	})
+	assert.Equal(t, stateHasCompletion, eng.state, "completion shown")
+	assert.NotNil(t, eng.providerTarget, "target kept until accept")
+
+	eng.acceptCompletion()
+	assert.Equal(t, stateHasCursorTarget, eng.state, "jump to provider target after accept")
+	assert.Equal(t, 12, buf.showCursorTargetLine, "provider target line")
+	assert.Nil(t, eng.providerTarget, "target used once")
+}
+
+func TestCursorTarget_OtherFile(t *testing.T) {
+	buf := newMockBuffer()
+	buf.workspacePath = t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(buf.workspacePath, "other.go"), []byte("a\nb\nfunc target() {\n"), 0o600), "WriteFile")
+	eng := createTestEngine(buf, newMockProvider(), newMockClock())
+	eng.mainCtx = context.Background()
+
+	target := eng.resolveProviderTarget(&types.CursorPredictionTarget{RelativePath: "other.go", LineNumber: 2, ExpectedContent: "func target() {", ShouldRetrigger: true})
+	assert.NotNil(t, target, "target found on disk")
+	assert.Equal(t, int32(3), target.LineNumber, "target moved to matching line")
+	assert.Nil(t, eng.resolveProviderTarget(&types.CursorPredictionTarget{RelativePath: "missing.go", LineNumber: 1}), "missing file")
+
+	eng.cursorTarget = target
+	eng.handleCursorTarget()
+	assert.Equal(t, stateHasCursorTarget, eng.state, "jump shown")
+	assert.Equal(t, "other.go", buf.showFileTargetPath, "file target shown")
+	assert.Equal(t, 3, buf.showCursorTargetLine, "file target line")
+
+	eng.acceptCursorTarget()
+	assert.Equal(t, filepath.Join(buf.workspacePath, "other.go"), buf.openedFile, "file opened")
+	assert.Equal(t, 3, buf.row, "cursor on target line")
+}
+
+func TestCursorTarget_OutsideWorkspace(t *testing.T) {
+	root := t.TempDir()
+	buf := newMockBuffer()
+	buf.workspacePath = filepath.Join(root, "project")
+	assert.NoError(t, os.Mkdir(buf.workspacePath, 0o700), "Mkdir")
+	secret := filepath.Join(root, "secret.txt")
+	assert.NoError(t, os.WriteFile(secret, []byte("token\n"), 0o600), "WriteFile")
+	eng := createTestEngine(buf, newMockProvider(), newMockClock())
+	eng.mainCtx = context.Background()
+
+	assert.Nil(t, eng.resolveProviderTarget(&types.CursorPredictionTarget{RelativePath: "../secret.txt", LineNumber: 1}), "relative path leaving the workspace")
+	assert.Nil(t, eng.resolveProviderTarget(&types.CursorPredictionTarget{RelativePath: secret, LineNumber: 1}), "absolute path")
+
+	eng.cursorTarget = &types.CursorPredictionTarget{RelativePath: "../secret.txt", LineNumber: 1}
+	eng.state = stateHasCursorTarget
+	eng.acceptFileTarget()
+	assert.Equal(t, "", buf.openedFile, "file not opened")
+	assert.Equal(t, stateIdle, eng.state, "target dropped")
+}
+
+func TestRenameTarget(t *testing.T) {
+	buf := newMockBuffer()
+	buf.workspacePath = t.TempDir()
+	buf.lines = []string{"func newName() {}"}
+	for _, name := range []string{"a.go", "b.go"} {
+		assert.NoError(t, os.WriteFile(filepath.Join(buf.workspacePath, name), []byte("x\n\toldName()\n"), 0o600), "WriteFile")
+	}
+	eng := createTestEngine(buf, newMockProvider(), newMockClock())
+	eng.fileStateStore[fileKey(buf.workspacePath, "a.go")] = &FileState{LastAccessNs: 1}
+	eng.fileStateStore[fileKey(buf.workspacePath, "b.go")] = &FileState{LastAccessNs: 2}
+
+	target := eng.renameTarget([]string{"func oldName() {}"}, []string{"func newName() {}"})
+	assert.NotNil(t, target, "rename followed")
+	assert.Equal(t, "b.go", target.RelativePath, "most recently used file first")
+	assert.Equal(t, int32(2), target.LineNumber, "first use of old name")
+
+	buf.lines = []string{"func newName() {}", "oldName()"}
+	assert.Nil(t, eng.renameTarget([]string{"func oldName() {}"}, []string{"func newName() {}"}), "uses left in current file")
+	assert.Nil(t, eng.renameTarget([]string{"a := 1"}, []string{"a := 2"}), "not a rename")
+}
+
+func TestRenameTarget_OpenBuffers(t *testing.T) {
+	buf := newMockBuffer()
+	buf.workspacePath = t.TempDir()
+	buf.lines = []string{"func newName() {}"}
+	for _, name := range []string{"a.go", "b.go", "c.go"} {
+		assert.NoError(t, os.WriteFile(filepath.Join(buf.workspacePath, name), []byte("x\n\toldName()\n"), 0o600), "WriteFile")
+	}
+	eng := createTestEngine(buf, newMockProvider(), newMockClock())
+	eng.fileStateStore[fileKey(buf.workspacePath, "a.go")] = &FileState{LastAccessNs: 1}
+	eng.fileStateStore[fileKey(buf.workspacePath, "c.go")] = &FileState{LastAccessNs: 3}
+
+	// b.go is open with an unsaved use; c.go is open and already fixed, but
+	// not saved. Neither needs to be in the file state store.
+	buf.openBuffers = []*buffer.WordUse{
+		{Path: "c.go"},
+		{Path: "b.go", Line: 5, Text: "\tv := oldName(1)"},
+	}
+	target := eng.renameTarget([]string{"func oldName() {}"}, []string{"func newName() {}"})
+	assert.NotNil(t, target, "rename followed")
+	assert.Equal(t, "b.go", target.RelativePath, "open buffer searched first")
+	assert.Equal(t, int32(5), target.LineNumber, "line from the buffer")
+	assert.Equal(t, "v := oldName(1)", target.ExpectedContent, "content from the buffer")
+
+	// Without uses in open buffers, files on disk are searched, except
+	// those open in a buffer
+	buf.openBuffers = []*buffer.WordUse{{Path: "b.go"}, {Path: "c.go"}}
+	target = eng.renameTarget([]string{"func oldName() {}"}, []string{"func newName() {}"})
+	assert.NotNil(t, target, "rename followed on disk")
+	assert.Equal(t, "a.go", target.RelativePath, "disk copy of open c.go skipped")
+}
+
+func TestRevertLastAccept(t *testing.T) {
+	buf := newMockBuffer()
+	eng := createTestEngine(buf, newMockProvider(), newMockClock())
+	key := fileKey(buf.workspacePath, buf.path)
+
+	eng.dispatch(Event{Type: EventRevert})
+	assert.Equal(t, 1, buf.revertCalls, "revert requested")
+	assert.Nil(t, eng.fileStateStore[key], "nothing reverted, nothing saved")
+
+	buf.revertResult = true
+	eng.completions = []*types.Completion{{StartLine: 1, EndLineInc: 1, Lines: []string{"next"}}}
+	eng.state = stateHasCompletion
+	eng.dispatch(Event{Type: EventRevert})
+	assert.Equal(t, stateIdle, eng.state, "completion on screen rejected")
+	assert.Nil(t, eng.completions, "completion cleared")
+	assert.NotNil(t, eng.fileStateStore[key], "reverted history saved")
+}
+
+func TestSyncBuffer_SavesUndoneHistory(t *testing.T) {
+	buf := newMockBuffer()
+	eng := createTestEngine(buf, newMockProvider(), newMockClock())
+	key := fileKey(buf.workspacePath, buf.path)
+
+	eng.syncBuffer()
+	assert.Nil(t, eng.fileStateStore[key], "nothing to save")
+
+	buf.historyReverted = true
+	eng.syncBuffer()
+	assert.NotNil(t, eng.fileStateStore[key], "rolled back history saved")
+}
+
 func TestClearState_Options(t *testing.T) {
 	buf := newMockBuffer()
 	prov := newMockProvider()
@@ -598,6 +1107,41 @@ func TestIsFileStateValid(t *testing.T) {
 	}
 }
 
+func TestHandleFileSwitch_PersistentStore(t *testing.T) {
+	store, err := NewDiskStore(t.TempDir(), 1<<20)
+	assert.NoError(t, err, "NewDiskStore")
+
+	// Edit a file in one engine, then switch away from it
+	buf := newMockBuffer()
+	buf.lines = []string{"a", "b"}
+	buf.originalLines = []string{"a", "b"}
+	buf.diffHistories = []*types.DiffEntry{{Original: "a", Updated: "b"}}
+	eng := createTestEngine(buf, newMockProvider(), newMockClock())
+	eng.config.FileStateStore = store
+	eng.handleFileSwitch("/repo", "main.go", "/repo", "other.go", []string{"x"})
+
+	// A fresh engine (daemon restart) restores it when the file is reopened
+	buf2 := newMockBuffer()
+	eng2 := createTestEngine(buf2, newMockProvider(), newMockClock())
+	eng2.config.FileStateStore = store
+
+	restored := eng2.handleFileSwitch("", "", "/repo", "main.go", []string{"a", "b"})
+
+	assert.True(t, restored, "state restored from disk")
+	assert.Len(t, 1, buf2.diffHistories, "diff history restored")
+	assert.NotNil(t, eng2.fileStateStore["/repo/main.go"], "cached in memory")
+
+	// A file that changed too much since is discarded from disk as well
+	eng3 := createTestEngine(newMockBuffer(), newMockProvider(), newMockClock())
+	eng3.config.FileStateStore = store
+
+	restored = eng3.handleFileSwitch("", "", "/repo", "main.go", []string{"completely", "different"})
+
+	assert.False(t, restored, "stale state not restored")
+	state, _ := store.Load("/repo", "main.go")
+	assert.Nil(t, state, "stale state deleted")
+}
+
 func TestTrimFileStateStore(t *testing.T) {
 	buf := newMockBuffer()
 	prov := newMockProvider()
@@ -622,6 +1166,83 @@ func TestTrimFileStateStore(t *testing.T) {
 	assert.True(t, existsE, "should keep e.go (most recent)")
 }
 
+// --- Diff History Tests ---
+
+func diffEntry(original, updated string) *types.DiffEntry {
+	return &types.DiffEntry{Original: original, Updated: updated}
+}
+
+func TestGetAllFileDiffHistories_CurrentFileOnly(t *testing.T) {
+	buf := newMockBuffer()
+	buf.workspacePath = "/repo"
+	buf.diffHistories = []*types.DiffEntry{diffEntry("a", "b")}
+	eng := createTestEngine(buf, newMockProvider(), newMockClock())
+	eng.fileStateStore["/repo/other.go"] = &FileState{
+		DiffHistories: []*types.DiffEntry{diffEntry("x", "y")},
+	}
+
+	histories := eng.getAllFileDiffHistories()
+
+	assert.Len(t, 1, histories, "only the current file by default")
+	assert.Equal(t, "test.go", histories[0].FileName, "current file")
+}
+
+func TestGetAllFileDiffHistories_CrossFile(t *testing.T) {
+	buf := newMockBuffer()
+	buf.workspacePath = "/repo"
+	buf.diffHistories = []*types.DiffEntry{diffEntry("a", "b")}
+	eng := createTestEngine(buf, newMockProvider(), newMockClock())
+	eng.config.DiffHistoryFiles = 3
+	eng.fileStateStore["/repo/old.go"] = &FileState{
+		DiffHistories: []*types.DiffEntry{diffEntry("1", "2")},
+		LastAccessNs:  100,
+	}
+	eng.fileStateStore["/repo/caller.go"] = &FileState{
+		DiffHistories: []*types.DiffEntry{diffEntry("3", "4")},
+		LastAccessNs:  300,
+	}
+	eng.fileStateStore["/repo/oldest.go"] = &FileState{
+		DiffHistories: []*types.DiffEntry{diffEntry("5", "6")},
+		LastAccessNs:  50,
+	}
+	eng.fileStateStore["/repo/clean.go"] = &FileState{LastAccessNs: 500}
+	eng.fileStateStore["/repo/test.go"] = &FileState{
+		DiffHistories: []*types.DiffEntry{diffEntry("stale", "state")},
+		LastAccessNs:  400,
+	}
+
+	histories := eng.getAllFileDiffHistories()
+
+	names := make([]string, len(histories))
+	for i, h := range histories {
+		names[i] = h.FileName
+	}
+	assert.Equal(t, []string{"old.go", "caller.go", "test.go"}, names, "oldest first, current file last")
+	assert.Equal(t, "a", histories[2].DiffHistory[0].Original, "current file uses live buffer diffs")
+}
+
+func TestTrimDiffHistories_SharedBudget(t *testing.T) {
+	histories := []*types.FileDiffHistory{
+		{FileName: "current.go", DiffHistory: []*types.DiffEntry{diffEntry("aaaa", "bbbb"), diffEntry("cccc", "dddd")}},
+		{FileName: "recent.go", DiffHistory: []*types.DiffEntry{diffEntry("ee", "ff")}},
+		{FileName: "older.go", DiffHistory: []*types.DiffEntry{diffEntry("gggggggg", "hhhhhhhh")}},
+	}
+
+	// 12 tokens = 24 chars: current.go takes 16, recent.go 4, older.go does not fit
+	trimmed := trimDiffHistories(histories, 12)
+
+	assert.Len(t, 2, trimmed, "older file dropped")
+	assert.Len(t, 2, trimmed[0].DiffHistory, "current file kept whole")
+	assert.Equal(t, "recent.go", trimmed[1].FileName, "recent file kept")
+
+	// A budget smaller than the current file's latest diff keeps only that diff
+	trimmed = trimDiffHistories(histories, 2)
+
+	assert.Len(t, 1, trimmed, "only the current file")
+	assert.Len(t, 1, trimmed[0].DiffHistory, "latest diff only")
+	assert.Equal(t, "cccc", trimmed[0].DiffHistory[0].Original, "latest diff kept")
+}
+
 // --- Token Streaming Keep Partial Tests ---
 
 func TestTokenStreamingKeepPartial_TypingMatchesPartial(t *testing.T) {
@@ -2,6 +2,8 @@ package engine
 
 import (
 	"cursortab/logger"
+	"cursortab/metrics"
+	"cursortab/text"
 	"cursortab/types"
 )
 
@@ -21,6 +23,11 @@ const (
 	EventCompletionError   EventType = "completion_error"
 	EventPrefetchReady     EventType = "prefetch_ready"
 	EventPrefetchError     EventType = "prefetch_error"
+	EventNextCandidate     EventType = "next_candidate"
+	EventPrevCandidate     EventType = "prev_candidate"
+	EventAcceptWord        EventType = "accept_word"
+	EventAcceptLine        EventType = "accept_line"
+	EventRevert            EventType = "revert"
 
 	// Streaming events (handled directly via channel selection, not through eventChan)
 	EventStreamLine     EventType = "stream_line"     // A line was received from the stream
@@ -51,6 +58,11 @@ func buildEventTypeMap() map[string]EventType {
 		EventCompletionError,
 		EventPrefetchReady,
 		EventPrefetchError,
+		EventNextCandidate,
+		EventPrevCandidate,
+		EventAcceptWord,
+		EventAcceptLine,
+		EventRevert,
 		EventStreamLine,
 		EventStreamComplete,
 		EventStreamError,
@@ -94,6 +106,7 @@ func (e *Engine) handleTextChangeImpl() {
 			return
 		}
 		// User typed everything - completion fully typed
+		e.recordOutcome(metrics.Typed)
 		e.clearAll()
 		e.state = stateIdle
 		e.startTextChangeTimer()
@@ -145,25 +158,16 @@ func (e *Engine) checkTypingMatchesPrediction() (bool, bool) {
 		return false, false
 	}
 
-	// Check each current line is a prefix of the corresponding target line
-	// and track if user made progress
+	// Each current line must be a prefix of the corresponding target line;
+	// partial accepts continue the completion from the same point
+	next, _, ok := text.TypedPrefix(currentLines, targetLines)
+	if !ok {
+		return false, false
+	}
+
+	// Check if user made progress compared to original
 	madeProgress := false
 	for i, currentLine := range currentLines {
-		if i >= len(targetLines) {
-			return false, false
-		}
-
-		targetLine := targetLines[i]
-
-		// Current line must be a prefix of target line
-		if len(currentLine) > len(targetLine) {
-			return false, false
-		}
-		if currentLine != targetLine[:len(currentLine)] {
-			return false, false
-		}
-
-		// Check if user made progress compared to original
 		if i < len(originalLines) {
 			if currentLine != originalLines[i] && len(currentLine) > len(originalLines[i]) {
 				madeProgress = true
@@ -177,18 +181,8 @@ func (e *Engine) checkTypingMatchesPrediction() (bool, bool) {
 		return false, false
 	}
 
-	// Check if there's remaining content to predict
-	hasRemaining := len(currentLines) < len(targetLines)
-	if !hasRemaining {
-		for i := range currentLines {
-			if i < len(targetLines) && len(currentLines[i]) < len(targetLines[i]) {
-				hasRemaining = true
-				break
-			}
-		}
-	}
-
-	return true, hasRemaining
+	// Content is left to predict until every target line is typed
+	return true, next < len(targetLines)
 }
 
 // handleCompletionReadyImpl contains the actual completion handling logic
@@ -198,6 +192,7 @@ func (e *Engine) handleCompletionReadyImpl(response *types.CompletionResponse) {
 	e.syncBuffer()
 
 	if len(response.Completions) == 0 {
+		e.useProviderTarget(response.CursorTarget)
 		e.handleCursorTarget()
 		return
 	}
@@ -205,16 +200,15 @@ func (e *Engine) handleCompletionReadyImpl(response *types.CompletionResponse) {
 	completion := response.Completions[0]
 
 	// Use unified processCompletion for all completion handling
-	if e.processCompletion(completion) {
-		if len(response.Completions) > 1 {
-			logger.Debug("multiple completions: %v", response.Completions)
-		}
+	if e.processCandidates(response.Completions, response.Disjoint, response.CursorTarget) {
 		return
 	}
 
-	// No changes - handle no-op case
+	// No changes - handle no-op case, preferring the provider's next edit
+	// location over jumping to the end of the completion
 	logger.Debug("no changes to completion")
-	if e.config.CursorPrediction.AutoAdvance && e.config.CursorPrediction.Enabled {
+	if !e.useProviderTarget(response.CursorTarget) &&
+		e.config.CursorPrediction.AutoAdvance && e.config.CursorPrediction.Enabled {
 		e.cursorTarget = &types.CursorPredictionTarget{
 			LineNumber:      int32(completion.EndLineInc),
 			ShouldRetrigger: true,
@@ -0,0 +1,79 @@
+package engine
+
+import (
+	"time"
+
+	"cursortab/metrics"
+)
+
+// NamedProvider is implemented by providers that report a name for metrics.
+// Implemented by provider.Provider and provider.Chain.
+type NamedProvider interface {
+	ProviderName() string
+}
+
+// requestInfo identifies the request a completion came from
+type requestInfo struct {
+	provider string
+	fileType string
+	start    time.Time
+	cacheKey string // Key its completion is cached under ("" = not cached)
+}
+
+// shownCompletion is the completion stage on screen whose outcome has not
+// been recorded yet
+type shownCompletion struct {
+	request requestInfo
+	stage   int
+}
+
+func providerName(p Provider) string {
+	if named, ok := p.(NamedProvider); ok {
+		return named.ProviderName()
+	}
+	return "unknown"
+}
+
+// startRequest counts a request to provider and returns its info
+func (e *Engine) startRequest(p Provider, fileType string) requestInfo {
+	info := requestInfo{
+		provider: providerName(p),
+		fileType: fileType,
+		start:    e.clock.Now(),
+	}
+	e.config.Metrics.RecordRequest(info.provider)
+	return info
+}
+
+// finishReasonError is the finish reason of a stream that failed, e.g. on an
+// HTTP error or an open circuit breaker
+const finishReasonError = "error"
+
+// recordStreamEnd records the total time of a request whose stream ended, or
+// an error if the stream failed. Returns false if it failed.
+func (e *Engine) recordStreamEnd(info requestInfo, stream LineStream) bool {
+	if stream != nil && stream.FinishReason() == finishReasonError {
+		e.config.Metrics.RecordError(info.provider)
+		return false
+	}
+	e.config.Metrics.RecordTotal(info.provider, e.clock.Now().Sub(info.start))
+	return true
+}
+
+// recordShown counts a stage of the latest completion as shown, once
+func (e *Engine) recordShown(stage int) {
+	if e.shown != nil && e.shown.request == e.lastRequest && e.shown.stage == stage {
+		return
+	}
+	e.shown = &shownCompletion{request: e.lastRequest, stage: stage}
+	e.config.Metrics.RecordOutcome(e.lastRequest.provider, e.lastRequest.fileType, stage, metrics.Shown)
+}
+
+// recordOutcome records what happened to the stage on screen, if any
+func (e *Engine) recordOutcome(outcome metrics.Outcome) {
+	if e.shown == nil {
+		return
+	}
+	e.config.Metrics.RecordOutcome(e.shown.request.provider, e.shown.request.fileType, e.shown.stage, outcome)
+	e.shown = nil
+}
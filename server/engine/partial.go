@@ -0,0 +1,127 @@
+package engine
+
+import (
+	"math"
+	"slices"
+
+	"cursortab/logger"
+	"cursortab/text"
+	"cursortab/types"
+)
+
+// acceptPartial applies the next word or line of the completion on screen
+// and keeps the rest of it displayed. Accepting what is left of a stage is
+// the same as accepting the whole stage.
+func (e *Engine) acceptPartial(unit text.AcceptUnit) {
+	if len(e.completions) == 0 {
+		return
+	}
+
+	e.syncBuffer()
+	completion := e.completions[0]
+	bufferLines := e.buffer.Lines()
+	var oldLines []string
+	for i := completion.StartLine; i <= completion.EndLineInc && i-1 < len(bufferLines); i++ {
+		oldLines = append(oldLines, bufferLines[i-1])
+	}
+
+	newLines, line, col := text.PartialAccept(oldLines, completion.Lines, unit)
+	if slices.Equal(newLines, completion.Lines) {
+		e.acceptCompletion()
+		return
+	}
+
+	row := completion.StartLine + line - 1
+	if err := e.buffer.ApplyEdit(completion.StartLine, completion.EndLineInc, newLines, row, col); err != nil {
+		logger.Error("error applying partial completion: %v", err)
+		return
+	}
+	e.saveCurrentFileState()
+
+	// Only the completion that was partially applied can still be shown
+	e.candidates = nil
+	e.candidateIdx = 0
+
+	if !e.restageRemainder(completion, len(newLines)-len(oldLines)) {
+		e.clearAll()
+		e.state = stateIdle
+		return
+	}
+	e.showCurrentStage()
+}
+
+// restageRemainder replaces the current stage with what is left of
+// completion after a partial accept that changed the line count of its range
+// by delta. Stages below it and cursor targets past it move by delta.
+// Returns false if nothing is left to show.
+func (e *Engine) restageRemainder(completion *types.Completion, delta int) bool {
+	endLine := completion.EndLineInc + delta
+	bufferLines := e.buffer.Lines()
+	var currentLines []string
+	for i := completion.StartLine; i <= endLine && i-1 < len(bufferLines); i++ {
+		currentLines = append(currentLines, bufferLines[i-1])
+	}
+
+	// Without a viewport and with no proximity limit the remainder is one stage.
+	// It keeps the whole range so lines the diff sees as pure additions are
+	// not applied as replacements of the line below them.
+	diffResult := text.AnalyzeDiffForStagingWithViewport(
+		text.JoinLines(currentLines), text.JoinLines(completion.Lines),
+		0, 0,
+		completion.StartLine,
+	)
+	stagingResult := text.CreateStages(
+		diffResult,
+		e.buffer.Row(),
+		0, 0,
+		completion.StartLine,
+		math.MaxInt,
+		e.buffer.Path(),
+		completion.Lines,
+		currentLines,
+	)
+	if stagingResult == nil || len(stagingResult.Stages) == 0 {
+		return false
+	}
+	remainder := stagingResult.Stages[0]
+	remainder.BufferStart = completion.StartLine
+	remainder.BufferEnd = endLine
+	remainder.Lines = completion.Lines
+
+	if e.stagedCompletion == nil {
+		e.stagedCompletion = &types.StagedCompletion{
+			Stages:     []any{remainder},
+			CurrentIdx: 0,
+			SourcePath: e.buffer.Path(),
+		}
+		return true
+	}
+
+	current := e.getStage(e.stagedCompletion.CurrentIdx)
+	if current == nil {
+		return false
+	}
+	shift := func(target *types.CursorPredictionTarget) {
+		if target != nil && int(target.LineNumber) >= completion.EndLineInc {
+			target.LineNumber += int32(delta)
+		}
+	}
+
+	shift(current.CursorTarget)
+	remainder.CursorTarget = current.CursorTarget
+	remainder.IsLastStage = current.IsLastStage
+	e.stagedCompletion.Stages[e.stagedCompletion.CurrentIdx] = remainder
+
+	for i := e.stagedCompletion.CurrentIdx + 1; i < len(e.stagedCompletion.Stages); i++ {
+		stage := e.getStage(i)
+		if stage == nil {
+			continue
+		}
+		if stage.BufferStart > completion.EndLineInc {
+			stage.BufferStart += delta
+			stage.BufferEnd += delta
+		}
+		shift(stage.CursorTarget)
+	}
+	return true
+}
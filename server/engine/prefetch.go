@@ -32,41 +32,68 @@ func (e *Engine) requestPrefetch(source types.CompletionSource, overrideRow int,
 		e.prefetchCancel = nil
 		e.prefetchState = prefetchNone
 	}
+	e.prefetchLinesChan = nil
+	e.prefetchStreamingState = nil
 
 	// Sync buffer to ensure latest context
 	e.syncBuffer()
 
+	workspacePath := e.buffer.WorkspacePath()
+	filePath := e.buffer.Path()
+	fileType := e.buffer.FileType()
+	provider := e.providerFor(filePath, fileType)
+	if provider == nil {
+		return
+	}
+
 	ctx, cancel := context.WithTimeout(e.mainCtx, e.config.CompletionTimeout)
 	e.prefetchCancel = cancel
 	e.prefetchState = prefetchInFlight
+	e.prefetchRequest = e.startRequest(provider, fileType)
 
 	// Snapshot required values to avoid races with buffer mutation
-	lines := append([]string{}, e.buffer.Lines()...)
-	previousLines := append([]string{}, e.buffer.PreviousLines()...)
-	version := e.buffer.Version()
-	filePath := e.buffer.Path()
-	linterErrors := e.buffer.LinterErrors()
-	viewportHeight := e.getViewportHeightConstraint()
+	req := &types.CompletionRequest{
+		Source:            source,
+		WorkspacePath:     workspacePath,
+		WorkspaceID:       e.WorkspaceID,
+		FilePath:          filePath,
+		FileType:          fileType,
+		Lines:             append([]string{}, e.buffer.Lines()...),
+		Version:           e.buffer.Version(),
+		PreviousLines:     append([]string{}, e.buffer.PreviousLines()...),
+		FileDiffHistories: e.getAllFileDiffHistories(),
+		ContextBuffers:    e.getContextBuffers(),
+		CursorRow:         overrideRow,
+		CursorCol:         overrideCol,
+		ViewportHeight:    e.getViewportHeightConstraint(),
+		LinterErrors:      e.buffer.LinterErrors(),
+	}
+
+	if cached := e.lookupCache(&e.prefetchRequest, provider, req); cached != nil {
+		cancel()
+		e.prefetchCancel = nil
+		e.handleCachedPrefetch(cached)
+		return
+	}
+
+	if streamProvider, ok := provider.(LineStreamProvider); ok && streamProvider.GetStreamingType() == StreamingTypeLines {
+		e.requestStreamingPrefetch(ctx, streamProvider, req)
+		return
+	}
+
+	recorder := e.config.Metrics
+	cache := e.cache
+	info := e.prefetchRequest
 
 	go func() {
 		defer cancel()
 
-		result, err := e.provider.GetCompletion(ctx, &types.CompletionRequest{
-			Source:            source,
-			WorkspacePath:     e.WorkspacePath,
-			WorkspaceID:       e.WorkspaceID,
-			FilePath:          filePath,
-			Lines:             lines,
-			Version:           version,
-			PreviousLines:     previousLines,
-			FileDiffHistories: e.getAllFileDiffHistories(),
-			CursorRow:         overrideRow,
-			CursorCol:         overrideCol,
-			ViewportHeight:    viewportHeight,
-			LinterErrors:      linterErrors,
-		})
+		result, err := provider.GetCompletion(ctx, req)
 
 		if err != nil {
+			if !errors.Is(err, context.Canceled) {
+				recorder.RecordError(info.provider)
+			}
 			select {
 			case e.eventChan <- Event{Type: EventPrefetchError, Data: err}:
 			case <-e.mainCtx.Done():
@@ -74,6 +101,11 @@ func (e *Engine) requestPrefetch(source types.CompletionSource, overrideRow int,
 			return
 		}
 
+		elapsed := e.clock.Now().Sub(info.start)
+		recorder.RecordFirstLine(info.provider, elapsed)
+		recorder.RecordTotal(info.provider, elapsed)
+		cache.put(info.cacheKey, req, &cachedCompletion{resp: result})
+
 		select {
 		case e.eventChan <- Event{Type: EventPrefetchReady, Data: result}:
 		case <-e.mainCtx.Done():
@@ -81,10 +113,125 @@ func (e *Engine) requestPrefetch(source types.CompletionSource, overrideRow int,
 	}()
 }
 
+// requestStreamingPrefetch streams a prefetch line by line like a completion,
+// building its stages off-screen. The event loop feeds the lines to
+// handlePrefetchStreamLine.
+func (e *Engine) requestStreamingPrefetch(ctx context.Context, provider LineStreamProvider, req *types.CompletionRequest) {
+	stream, providerCtx, err := provider.PrepareLineStream(ctx, req)
+	if err != nil {
+		e.config.Metrics.RecordError(e.prefetchRequest.provider)
+		e.prefetchCancel()
+		e.prefetchCancel = nil
+		e.prefetchState = prefetchNone
+		return
+	}
+
+	e.prefetchStreamingState = e.newStreamingState(ctx, provider, stream, providerCtx, req)
+	e.prefetchLinesChan = stream.LinesChan()
+}
+
+// handlePrefetchStreamLine adds a line of the prefetch stream to its stages
+// without rendering anything
+func (e *Engine) handlePrefetchStreamLine(line string) {
+	ss := e.prefetchStreamingState
+	if ss == nil {
+		return
+	}
+
+	validated := ss.Validated
+	if _, err := ss.addLine(line); err != nil {
+		logger.Debug("prefetch rejected: %v", err)
+		e.prefetchLinesChan = nil
+		e.prefetchStreamingState = nil
+		e.prefetchCancel()
+		e.prefetchCancel = nil
+		e.handlePrefetchError(nil)
+		return
+	}
+	if !validated {
+		e.config.Metrics.RecordFirstLine(e.prefetchRequest.provider, e.clock.Now().Sub(e.prefetchRequest.start))
+	}
+}
+
+// handlePrefetchStreamComplete finalizes the stages of the prefetch stream
+// when its lines channel closes
+func (e *Engine) handlePrefetchStreamComplete() {
+	ss := e.prefetchStreamingState
+	e.prefetchLinesChan = nil
+	e.prefetchStreamingState = nil
+	if ss == nil {
+		return
+	}
+	succeeded := e.recordStreamEnd(e.prefetchRequest, ss.Stream)
+
+	e.prefetchedStaging, e.prefetchedCursorTarget = ss.finish()
+	if succeeded {
+		e.cacheStream(e.prefetchRequest, ss, e.prefetchedStaging, e.prefetchedCursorTarget)
+	}
+	e.prefetchedCompletions = nil
+	e.prefetchedDisjoint = false
+	e.finishPrefetch()
+}
+
+// promotePrefetchStream makes the in-flight prefetch stream the current
+// completion stream once the cursor has jumped to the prefetch position.
+// A stage already buffered near the cursor is shown right away; the rest
+// arrive as with any streamed completion.
+func (e *Engine) promotePrefetchStream() {
+	ss := e.prefetchStreamingState
+	e.syncBuffer()
+
+	// Stages are ordered and placed from where the cursor is now
+	viewportTop, viewportBottom := e.buffer.ViewportBounds()
+	ss.StageBuilder.CursorRow = e.buffer.Row()
+	ss.StageBuilder.ViewportTop = viewportTop
+	ss.StageBuilder.ViewportBottom = viewportBottom
+
+	e.state = stateStreamingCompletion
+	e.cursorTarget = nil
+	e.lastRequest = e.prefetchRequest
+	e.streamingState = ss
+	e.streamingCancel = e.prefetchCancel
+	e.streamLinesChan = e.prefetchLinesChan
+	e.streamLineNum = 0
+
+	e.prefetchStreamingState = nil
+	e.prefetchLinesChan = nil
+	e.prefetchCancel = nil
+	e.prefetchState = prefetchNone
+
+	for _, stage := range ss.StageBuilder.Stages() {
+		if e.renderStageIfClose(ss, stage) {
+			break
+		}
+	}
+}
+
+// handleCachedPrefetch stores a cached completion as the prefetch result
+func (e *Engine) handleCachedPrefetch(cached *cachedCompletion) {
+	if cached.resp != nil {
+		e.handlePrefetchReady(cached.resp)
+		return
+	}
+	e.prefetchedStaging = cached.staging
+	e.prefetchedCursorTarget = cached.target
+	e.prefetchedCompletions = nil
+	e.prefetchedDisjoint = false
+	e.finishPrefetch()
+}
+
 // handlePrefetchReady processes a successful prefetch response
 func (e *Engine) handlePrefetchReady(resp *types.CompletionResponse) {
 	e.prefetchedCompletions = resp.Completions
+	e.prefetchedDisjoint = resp.Disjoint
+	e.prefetchedStaging = nil
 	e.prefetchedCursorTarget = resp.CursorTarget
+	e.finishPrefetch()
+}
+
+// finishPrefetch marks the stored prefetch result ready and shows it if the
+// user is already waiting for it
+func (e *Engine) finishPrefetch() {
 	previousPrefetchState := e.prefetchState
 	e.prefetchState = prefetchReady
 
@@ -96,55 +243,89 @@ func (e *Engine) handlePrefetchReady(resp *types.CompletionResponse) {
 	// If we were waiting for prefetch to show cursor prediction (last stage case),
 	// check if first change is close enough to show completion, otherwise show cursor prediction
 	if previousPrefetchState == prefetchWaitingForCursorPrediction {
-		if len(e.prefetchedCompletions) > 0 {
-			comp := e.prefetchedCompletions[0]
-			// Extract old lines from buffer for the completion range
-			bufferLines := e.buffer.Lines()
-			var oldLines []string
-			for i := comp.StartLine; i <= comp.EndLineInc && i-1 < len(bufferLines); i++ {
-				oldLines = append(oldLines, bufferLines[i-1])
-			}
-			// Find the first line that actually differs
-			targetLine := text.FindFirstChangedLine(oldLines, comp.Lines, comp.StartLine-1)
-
-			if targetLine > 0 {
-				distance := abs(targetLine - e.buffer.Row())
-				if distance <= e.config.CursorPrediction.ProximityThreshold {
-					// Close enough - show completion immediately
-					e.tryShowPrefetchedCompletion()
-				} else {
-					// Far away - show cursor prediction to that line
-					e.cursorTarget = &types.CursorPredictionTarget{
-						RelativePath:    e.buffer.Path(),
-						LineNumber:      int32(targetLine),
-						ShouldRetrigger: false, // Will use prefetched data
-					}
-					e.state = stateHasCursorTarget
-					e.buffer.ShowCursorTarget(targetLine)
+		if targetLine := e.prefetchedFirstChange(); targetLine > 0 {
+			distance := abs(targetLine - e.buffer.Row())
+			if distance <= e.config.CursorPrediction.ProximityThreshold {
+				// Close enough - show completion immediately
+				e.tryShowPrefetchedCompletion()
+			} else {
+				// Far away - show cursor prediction to that line
+				e.cursorTarget = &types.CursorPredictionTarget{
+					RelativePath:    e.buffer.Path(),
+					LineNumber:      int32(targetLine),
+					ShouldRetrigger: false, // Will use prefetched data
 				}
+				e.state = stateHasCursorTarget
+				e.buffer.ShowCursorTarget(targetLine)
 			}
 		}
 	}
 }
 
-// tryShowPrefetchedCompletion attempts to show prefetched completion immediately.
-// Returns true if completion was shown, false otherwise.
-func (e *Engine) tryShowPrefetchedCompletion() bool {
+// prefetchedFirstChange returns the first buffer line the prefetched
+// completion changes, or 0 if it has no changes
+func (e *Engine) prefetchedFirstChange() int {
+	if e.prefetchedStaging != nil {
+		return e.prefetchedStaging.Stages[0].BufferStart
+	}
 	if len(e.prefetchedCompletions) == 0 {
-		return false
+		return 0
+	}
+
+	comp := e.prefetchedCompletions[0]
+	// Extract old lines from buffer for the completion range
+	bufferLines := e.buffer.Lines()
+	var oldLines []string
+	for i := comp.StartLine; i <= comp.EndLineInc && i-1 < len(bufferLines); i++ {
+		oldLines = append(oldLines, bufferLines[i-1])
 	}
+	// Find the first line that actually differs
+	return text.FindFirstChangedLine(oldLines, comp.Lines, comp.StartLine-1)
+}
 
+// hasPrefetchedCompletion reports whether a prefetch result with changes is stored
+func (e *Engine) hasPrefetchedCompletion() bool {
+	return len(e.prefetchedCompletions) > 0 || e.prefetchedStaging != nil
+}
+
+// showPrefetchedCompletion clears the prefetch state and shows the stored
+// prefetch result. Returns false, along with the provider's cursor target,
+// if the result has no changes.
+func (e *Engine) showPrefetchedCompletion() (bool, *types.CursorPredictionTarget) {
 	// Sync buffer to get current cursor position
 	e.syncBuffer()
 
-	comp := e.prefetchedCompletions[0]
+	comps := e.prefetchedCompletions
+	disjoint := e.prefetchedDisjoint
+	staging := e.prefetchedStaging
+	target := e.prefetchedCursorTarget
 
 	// Clear prefetch state before processing
+	e.lastRequest = e.prefetchRequest
 	e.prefetchedCompletions = nil
+	e.prefetchedDisjoint = false
+	e.prefetchedStaging = nil
 	e.prefetchedCursorTarget = nil
 	e.prefetchState = prefetchNone
 
-	return e.processCompletion(comp)
+	if staging != nil {
+		if !e.showStaging(staging) {
+			return false, target
+		}
+		e.providerTarget = target
+		return true, target
+	}
+	return e.processCandidates(comps, disjoint, target), target
+}
+
+// tryShowPrefetchedCompletion attempts to show prefetched completion immediately.
+// Returns true if completion was shown, false otherwise.
+func (e *Engine) tryShowPrefetchedCompletion() bool {
+	if !e.hasPrefetchedCompletion() {
+		return false
+	}
+	shown, _ := e.showPrefetchedCompletion()
+	return shown
 }
 
 // handlePrefetchError processes a prefetch error
@@ -168,23 +349,15 @@ func (e *Engine) handleDeferredCursorTarget() {
 	}
 
 	// Check if we now have prefetched completions
-	if len(e.prefetchedCompletions) > 0 {
-		// Sync buffer to get updated cursor position
-		e.syncBuffer()
-
-		comp := e.prefetchedCompletions[0]
-
-		// Clear prefetch state before processing
-		e.prefetchedCompletions = nil
-		e.prefetchedCursorTarget = nil
-		e.prefetchState = prefetchNone
-
-		if e.processCompletion(comp) {
+	if e.hasPrefetchedCompletion() {
+		shown, target := e.showPrefetchedCompletion()
+		if shown {
 			return
 		}
 
 		// No changes
 		logger.Debug("no changes to completion (deferred prefetched)")
+		e.useProviderTarget(target)
 		e.handleCursorTarget()
 		return
 	}
@@ -204,26 +377,18 @@ func (e *Engine) handleDeferredCursorTarget() {
 // usePrefetchedCompletion attempts to use prefetched data when accepting a cursor target.
 // Returns true if prefetched data was used, false if caller should handle normally.
 func (e *Engine) usePrefetchedCompletion() bool {
-	if len(e.prefetchedCompletions) == 0 {
+	if !e.hasPrefetchedCompletion() {
 		return false
 	}
 
-	// Sync buffer to get updated cursor position after move
-	e.syncBuffer()
-
-	comp := e.prefetchedCompletions[0]
-
-	// Clear prefetch state before processing
-	e.prefetchedCompletions = nil
-	e.prefetchedCursorTarget = nil
-	e.prefetchState = prefetchNone
-
-	if e.processCompletion(comp) {
+	shown, target := e.showPrefetchedCompletion()
+	if shown {
 		return true
 	}
 
 	// No changes - handle cursor target
 	logger.Debug("no changes to completion (prefetched)")
+	e.useProviderTarget(target)
 	e.handleCursorTarget()
 	return true
 }
@@ -0,0 +1,77 @@
+package engine
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ProviderRule routes completions for matching files to a specific provider.
+// Rules are checked in order and the first match wins; files that match no
+// rule use the engine's default provider.
+type ProviderRule struct {
+	Pattern  string   // Glob matched against the workspace-relative path ("" = any path)
+	FileType string   // Neovim filetype ("" = any filetype)
+	Provider Provider // nil disables completions for matching files
+}
+
+// matches reports whether the rule applies to a file
+func (r *ProviderRule) matches(filePath, fileType string) bool {
+	if r.FileType != "" && r.FileType != fileType {
+		return false
+	}
+	if r.Pattern != "" && !matchGlob(r.Pattern, filePath) {
+		return false
+	}
+	return true
+}
+
+// providerFor returns the provider that serves completions for a file,
+// or nil if a rule disables completions for it
+func (e *Engine) providerFor(filePath, fileType string) Provider {
+	for i := range e.config.ProviderRules {
+		rule := &e.config.ProviderRules[i]
+		if rule.matches(filePath, fileType) {
+			return rule.Provider
+		}
+	}
+	return e.provider
+}
+
+// matchGlob reports whether a workspace-relative path matches a glob pattern.
+// Patterns without a slash match the file name in any directory (like
+// .gitignore); patterns with a slash match the whole path, where "**" matches
+// any number of directories.
+func matchGlob(pattern, filePath string) bool {
+	filePath = filepath.ToSlash(filePath)
+	if !strings.Contains(pattern, "/") {
+		ok, _ := path.Match(pattern, path.Base(filePath))
+		return ok
+	}
+
+	pattern = strings.TrimPrefix(pattern, "/")
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(filePath, "/"))
+}
+
+// matchSegments matches path segments against pattern segments, expanding "**"
+func matchSegments(pattern, segments []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(segments); i++ {
+				if matchSegments(pattern[1:], segments[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(segments) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], segments[0]); !ok {
+			return false
+		}
+		pattern, segments = pattern[1:], segments[1:]
+	}
+	return len(segments) == 0
+}
@@ -0,0 +1,91 @@
+package engine
+
+import (
+	"context"
+	"cursortab/assert"
+	"cursortab/types"
+	"testing"
+	"time"
+)
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{"*.go", "main.go", true},
+		{"*.go", "engine/engine.go", true},
+		{"*.go", "README.md", false},
+		{"*_gen.go", "api/types_gen.go", true},
+		{"vendor/**", "vendor/a/b.go", true},
+		{"vendor/**", "src/vendor/a.go", false},
+		{"**/testdata/*", "engine/testdata/x.txt", true},
+		{"**/testdata/*", "testdata/x.txt", true},
+		{"**/testdata/*", "engine/testdata/deep/x.txt", false},
+		{"/docs/*.md", "docs/a.md", true},
+		{"docs/*.md", "other/docs/a.md", false},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, matchGlob(tt.pattern, tt.path), tt.pattern+" vs "+tt.path)
+	}
+}
+
+func TestProviderFor(t *testing.T) {
+	defaultProv := newMockProvider()
+	markdownProv := newMockProvider()
+	goProv := newMockProvider()
+
+	eng := createTestEngine(newMockBuffer(), defaultProv, newMockClock())
+	eng.config.ProviderRules = []ProviderRule{
+		{Pattern: "*_gen.go", Provider: nil},
+		{FileType: "markdown", Provider: markdownProv},
+		{Pattern: "*.go", FileType: "go", Provider: goProv},
+	}
+
+	assert.True(t, eng.providerFor("api/types_gen.go", "go") == nil, "generated file disabled")
+	assert.True(t, eng.providerFor("README.md", "markdown") == markdownProv, "filetype rule")
+	assert.True(t, eng.providerFor("main.go", "go") == goProv, "pattern and filetype rule")
+	assert.True(t, eng.providerFor("main.go", "") == defaultProv, "both fields must match")
+	assert.True(t, eng.providerFor("main.py", "python") == defaultProv, "unmatched file uses default")
+}
+
+func TestRequestCompletion_UsesRuleProvider(t *testing.T) {
+	buf := newMockBuffer()
+	buf.path = "notes.md"
+	buf.fileType = "markdown"
+	defaultProv := newMockProvider()
+	markdownProv := newMockProvider()
+
+	eng := createTestEngine(buf, defaultProv, newMockClock())
+	eng.config.ProviderRules = []ProviderRule{{FileType: "markdown", Provider: markdownProv}}
+	eng.mainCtx = context.Background()
+
+	eng.requestCompletion(types.CompletionSourceTyping)
+
+	select {
+	case evt := <-eng.eventChan:
+		assert.Equal(t, EventCompletionReady, evt.Type, "completion event")
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for completion")
+	}
+	assert.Equal(t, 1, markdownProv.completionCalls, "rule provider called")
+	assert.Equal(t, 0, defaultProv.completionCalls, "default provider not called")
+	assert.Equal(t, "markdown", markdownProv.lastRequest.FileType, "request carries filetype")
+}
+
+func TestRequestCompletion_DisabledByRule(t *testing.T) {
+	buf := newMockBuffer()
+	buf.path = "api/types_gen.go"
+	prov := newMockProvider()
+
+	eng := createTestEngine(buf, prov, newMockClock())
+	eng.config.ProviderRules = []ProviderRule{{Pattern: "*_gen.go"}}
+	eng.mainCtx = context.Background()
+
+	eng.requestCompletion(types.CompletionSourceTyping)
+
+	assert.Equal(t, stateIdle, eng.state, "state stays idle")
+	assert.Equal(t, 0, prov.completionCalls, "no provider called")
+}
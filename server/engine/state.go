@@ -1,6 +1,8 @@
 package engine
 
 import (
+	"cursortab/metrics"
+	"cursortab/text"
 	"cursortab/types"
 )
 
@@ -43,6 +45,8 @@ type Transition struct {
 //	│                                                              │                           │
 //	│                                                              │                           ├─[Tab + prefetch ready]──► stateHasCompletion
 //	│                                                              │                           │
+//	│                                                              │                           ├─[Tab + prefetch streaming]──► stateStreamingCompletion
+//	│                                                              │                           │
 //	│                                                              │                           └─[Tab + shouldRetrigger]──► statePendingCompletion
 //	│                                                              │
 //	│                                                              └─[Tab + no cursor target]
@@ -53,7 +57,7 @@ type Transition struct {
 //	│
 //	└─[CursorMovedNormal]──► resets idle timer, stays idle
 //
-// Rejection (all → stateIdle): Esc, InsertLeave, TextChanged mismatch
+// Rejection (all → stateIdle): Esc, InsertLeave, TextChanged mismatch, Revert
 var transitions = []Transition{
 	// From stateIdle
 	{stateIdle, EventTextChangeTimeout, (*Engine).doRequestCompletion},
@@ -63,12 +67,14 @@ var transitions = []Transition{
 	{stateIdle, EventInsertLeave, (*Engine).doStartIdleTimer},
 	{stateIdle, EventEsc, (*Engine).doStopIdleTimer},
 	{stateIdle, EventTextChanged, (*Engine).doStartTextChangeTimer},
+	{stateIdle, EventRevert, (*Engine).doRevert},
 
 	// From statePendingCompletion
 	{statePendingCompletion, EventTextChanged, (*Engine).doTextChangePending},
 	{statePendingCompletion, EventEsc, (*Engine).doReject},
 	{statePendingCompletion, EventInsertLeave, (*Engine).doRejectAndStartIdleTimer},
 	{statePendingCompletion, EventCursorMovedNormal, (*Engine).doResetIdleTimer},
+	{statePendingCompletion, EventRevert, (*Engine).doRevert},
 
 	// From stateHasCompletion
 	{stateHasCompletion, EventTab, (*Engine).doAcceptCompletion},
@@ -76,6 +82,11 @@ var transitions = []Transition{
 	{stateHasCompletion, EventTextChanged, (*Engine).doTextChangeWithCompletion},
 	{stateHasCompletion, EventInsertLeave, (*Engine).doRejectAndStartIdleTimer},
 	{stateHasCompletion, EventCursorMovedNormal, (*Engine).doResetIdleTimer},
+	{stateHasCompletion, EventNextCandidate, (*Engine).doNextCandidate},
+	{stateHasCompletion, EventPrevCandidate, (*Engine).doPrevCandidate},
+	{stateHasCompletion, EventAcceptWord, (*Engine).doAcceptWord},
+	{stateHasCompletion, EventAcceptLine, (*Engine).doAcceptLine},
+	{stateHasCompletion, EventRevert, (*Engine).doRevert},
 
 	// From stateHasCursorTarget
 	{stateHasCursorTarget, EventTab, (*Engine).doAcceptCursorTarget},
@@ -83,6 +94,9 @@ var transitions = []Transition{
 	{stateHasCursorTarget, EventTextChanged, (*Engine).doRejectAndDebounce},
 	{stateHasCursorTarget, EventInsertLeave, (*Engine).doRejectAndStartIdleTimer},
 	{stateHasCursorTarget, EventCursorMovedNormal, (*Engine).doResetIdleTimer},
+	{stateHasCursorTarget, EventNextCandidate, (*Engine).doNextCandidate},
+	{stateHasCursorTarget, EventPrevCandidate, (*Engine).doPrevCandidate},
+	{stateHasCursorTarget, EventRevert, (*Engine).doRevert},
 
 	// From stateStreamingCompletion
 	{stateStreamingCompletion, EventEsc, (*Engine).doRejectStreaming},
@@ -204,6 +218,30 @@ func (e *Engine) doAcceptCursorTarget(event Event) {
 	// Note: acceptCursorTarget handles state transitions internally
 }
 
+func (e *Engine) doAcceptWord(event Event) {
+	e.acceptPartial(text.AcceptWord)
+	// Note: acceptPartial handles state transitions internally
+}
+
+func (e *Engine) doAcceptLine(event Event) {
+	e.acceptPartial(text.AcceptLine)
+	// Note: acceptPartial handles state transitions internally
+}
+
+func (e *Engine) doNextCandidate(event Event) {
+	e.cycleCandidate(1)
+}
+
+func (e *Engine) doPrevCandidate(event Event) {
+	e.cycleCandidate(-1)
+}
+
+func (e *Engine) doRevert(event Event) {
+	// Whatever is on screen was predicted from the state being reverted
+	e.reject()
+	e.revertLastAccept()
+}
+
 func (e *Engine) doTextChangeWithCompletion(event Event) {
 	e.handleTextChangeImpl()
 	// Note: handleTextChangeImpl handles state transitions internally
@@ -233,6 +271,7 @@ func (e *Engine) doRejectStreamingAndDebounce(event Event) {
 				return
 			}
 			// User typed everything
+			e.recordOutcome(metrics.Typed)
 			e.clearAll()
 			e.state = stateIdle
 			e.startTextChangeTimer()
@@ -259,6 +298,7 @@ func (e *Engine) doRejectStreamingAndDebounce(event Event) {
 				return
 			}
 			// User typed everything
+			e.recordOutcome(metrics.Typed)
 			e.clearAll()
 			e.state = stateIdle
 			e.startTextChangeTimer()
@@ -1,7 +1,11 @@
 package engine
 
 import (
+	"context"
 	"cursortab/assert"
+	"cursortab/metrics"
+	"cursortab/types"
+	"fmt"
 	"sync"
 	"testing"
 	"time"
@@ -9,8 +13,9 @@ import (
 
 // mockLineStream implements LineStream for testing
 type mockLineStream struct {
-	lines  chan string
-	cancel func()
+	lines        chan string
+	cancel       func()
+	finishReason string
 }
 
 func newMockLineStream() *mockLineStream {
@@ -22,6 +27,102 @@ func newMockLineStream() *mockLineStream {
 
 func (s *mockLineStream) LinesChan() <-chan string { return s.lines }
 func (s *mockLineStream) Cancel()                  { s.cancel() }
+func (s *mockLineStream) FinishReason() string     { return s.finishReason }
+
+// mockLineStreamProvider serves completions as a line stream fed by the test
+type mockLineStreamProvider struct {
+	*mockProvider
+	stream *mockLineStream
+}
+
+func (p *mockLineStreamProvider) GetStreamingType() int { return StreamingTypeLines }
+
+func (p *mockLineStreamProvider) PrepareLineStream(ctx context.Context, req *types.CompletionRequest) (LineStream, any, error) {
+	return p.stream, nil, nil
+}
+
+func (p *mockLineStreamProvider) ValidateFirstLine(providerCtx any, firstLine string) error {
+	return nil
+}
+
+func (p *mockLineStreamProvider) FinishLineStream(providerCtx any, text string, finishReason string, stoppedEarly bool) (*types.CompletionResponse, error) {
+	return nil, nil
+}
+
+// newStreamingPrefetchEngine starts a streamed prefetch at line 6 of a 12-line
+// buffer, with the cursor target waiting there
+func newStreamingPrefetchEngine() (*Engine, *mockBuffer) {
+	buf := newMockBuffer()
+	buf.lines = nil
+	for i := 1; i <= 12; i++ {
+		buf.lines = append(buf.lines, fmt.Sprintf("line %d", i))
+	}
+	eng := createTestEngine(buf, newMockProvider(), newMockClock())
+	eng.mainCtx = context.Background()
+	eng.provider = &mockLineStreamProvider{mockProvider: newMockProvider(), stream: newMockLineStream()}
+
+	eng.requestPrefetch(types.CompletionSourceTyping, 6, 0)
+	eng.cursorTarget = &types.CursorPredictionTarget{RelativePath: "test.go", LineNumber: 6}
+	eng.state = stateHasCursorTarget
+	return eng, buf
+}
+
+// streamedLines returns the buffer lines with line 6 changed, up to line n
+func streamedLines(n int) []string {
+	var lines []string
+	for i := 1; i <= n; i++ {
+		if i == 6 {
+			lines = append(lines, "LINE 6")
+		} else {
+			lines = append(lines, fmt.Sprintf("line %d", i))
+		}
+	}
+	return lines
+}
+
+func TestStreamingPrefetch_ShownOnTabWhileInFlight(t *testing.T) {
+	eng, buf := newStreamingPrefetchEngine()
+	assert.NotNil(t, eng.prefetchStreamingState, "prefetch streams")
+	assert.Equal(t, prefetchInFlight, eng.prefetchState, "prefetch in flight")
+
+	for _, line := range streamedLines(11) {
+		eng.handlePrefetchStreamLine(line)
+	}
+	assert.Len(t, 1, eng.prefetchStreamingState.StageBuilder.Stages(), "stage buffered")
+	assert.Equal(t, 0, buf.prepareCompletionCalls, "nothing rendered before tab")
+
+	eng.acceptCursorTarget()
+
+	assert.Equal(t, stateStreamingCompletion, eng.state, "prefetch stream promoted")
+	assert.Nil(t, eng.prefetchStreamingState, "prefetch stream handed over")
+	assert.NotNil(t, eng.streamingState, "stream continues")
+	assert.Equal(t, 6, buf.lastPreparedCompletion.startLine, "buffered stage shown")
+	assert.Equal(t, []string{"LINE 6"}, buf.lastPreparedCompletion.lines, "buffered stage lines")
+
+	eng.handleStreamLine("line 12")
+	eng.handleStreamCompleteSimple()
+	assert.Equal(t, stateHasCompletion, eng.state, "state after stream ends")
+	assert.Equal(t, 1, buf.prepareCompletionCalls, "stage not rendered again")
+}
+
+func TestStreamingPrefetch_ReadyBeforeTab(t *testing.T) {
+	eng, buf := newStreamingPrefetchEngine()
+
+	for _, line := range streamedLines(12) {
+		eng.handlePrefetchStreamLine(line)
+	}
+	eng.handlePrefetchStreamComplete()
+	assert.Equal(t, prefetchReady, eng.prefetchState, "prefetch ready")
+	assert.NotNil(t, eng.prefetchedStaging, "stages stored")
+	assert.Equal(t, 0, buf.prepareCompletionCalls, "nothing rendered before tab")
+
+	eng.acceptCursorTarget()
+
+	assert.Equal(t, stateHasCompletion, eng.state, "completion shown")
+	assert.Equal(t, 6, buf.lastPreparedCompletion.startLine, "stage start")
+	assert.Equal(t, []string{"LINE 6"}, buf.lastPreparedCompletion.lines, "stage lines")
+	assert.Nil(t, eng.prefetchedStaging, "prefetch consumed")
+}
 
 // TestStreamContaminationPrevention verifies that lines from an old stream
 // are not processed when a new stream starts.
@@ -158,3 +259,26 @@ func TestRapidStreamSwitching(t *testing.T) {
 
 	assert.Equal(t, 10, processedCount, "processed count")
 }
+
+func TestStreamMetrics_FailedStreamRecordedAsError(t *testing.T) {
+	buf := newMockBuffer()
+	eng := createTestEngine(buf, newMockProvider(), newMockClock())
+	eng.mainCtx = context.Background()
+	eng.config.Metrics, _ = metrics.NewRecorder("")
+	stream := newMockLineStream()
+	eng.provider = &mockLineStreamProvider{mockProvider: newMockProvider(), stream: stream}
+
+	eng.requestCompletion(types.CompletionSourceTyping)
+	stream.finishReason = "stop"
+	eng.handleStreamCompleteSimple()
+
+	eng.state = stateIdle
+	eng.requestCompletion(types.CompletionSourceTyping)
+	stream.finishReason = "error"
+	eng.handleStreamCompleteSimple()
+
+	stats := eng.config.Metrics.Snapshot().Providers["unknown"]
+	assert.Equal(t, int64(2), stats.Requests, "requests")
+	assert.Equal(t, int64(1), stats.Errors, "failed stream counted as an error")
+	assert.Equal(t, int64(1), stats.Total.Count, "only the finished stream timed")
+}
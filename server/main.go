@@ -5,10 +5,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 )
 
 // CursorPredictionConfig holds cursor prediction settings
@@ -32,18 +35,93 @@ type FIMTokensConfig struct {
 	Middle string `json:"middle"`
 }
 
+// CircuitBreakerConfig holds the settings of the circuit breaker in front of
+// a provider's server
+type CircuitBreakerConfig struct {
+	Failures int `json:"failures"` // Consecutive failures that stop requests (0 = off)
+	Cooldown int `json:"cooldown"` // in milliseconds before the server is probed
+}
+
+// SamplingConfig holds optional sampling parameters; unset ones are not sent
+type SamplingConfig struct {
+	MinP              *float64           `json:"min_p"`
+	RepetitionPenalty *float64           `json:"repetition_penalty"`
+	PresencePenalty   *float64           `json:"presence_penalty"`
+	Seed              *int64             `json:"seed"`
+	Grammar           string             `json:"grammar"`
+	LogitBias         map[string]float64 `json:"logit_bias"` // Token ID -> bias
+}
+
 // ProviderConfig holds provider-specific settings
 type ProviderConfig struct {
-	Type                 string          `json:"type"` // "inline", "sweep", "zeta"
-	URL                  string          `json:"url"`
-	Model                string          `json:"model"`
-	Temperature          float64         `json:"temperature"`
-	MaxTokens            int             `json:"max_tokens"` // Max tokens to generate (also drives input trimming)
-	TopK                 int             `json:"top_k"`
-	CompletionTimeout    int             `json:"completion_timeout"` // in milliseconds
-	MaxDiffHistoryTokens int             `json:"max_diff_history_tokens"`
-	CompletionPath       string          `json:"completion_path"`
-	FIMTokens            FIMTokensConfig `json:"fim_tokens"`
+	Type                 string               `json:"type"` // "inline", "fim", "infill", "sweep", "zeta", "nextedit"
+	URL                  string               `json:"url"`
+	Model                string               `json:"model"`
+	Temperature          float64              `json:"temperature"`
+	MaxTokens            int                  `json:"max_tokens"` // Max tokens to generate (also drives input trimming)
+	TopK                 int                  `json:"top_k"`
+	Candidates           int                  `json:"candidates"`         // Completions requested per request
+	CompletionTimeout    int                  `json:"completion_timeout"` // in milliseconds
+	MaxConcurrent        int                  `json:"max_concurrent"`     // Requests sent to the server at once (0 = no limit)
+	MaxRetries           int                  `json:"max_retries"`        // Retries of requests turned away with 429 or 503
+	CircuitBreaker       CircuitBreakerConfig `json:"circuit_breaker"`
+	MaxDiffHistoryTokens int                  `json:"max_diff_history_tokens"`
+	DiffHistoryFiles     int                  `json:"diff_history_files"` // Recently edited files in diff history
+	ContextBuffers       int                  `json:"context_buffers"`    // Other open buffers sent as context
+	CompletionPath       string               `json:"completion_path"`
+	API                  string               `json:"api"` // "completions", "chat", "fim" or "ollama"
+	Headers              map[string]string    `json:"headers"`
+	APIKeyEnv            string               `json:"api_key_env"`  // Name of env var holding the API key
+	APIKeyFile           string               `json:"api_key_file"` // Path to file holding the API key
+	FIMTokens            FIMTokensConfig      `json:"fim_tokens"`
+	FIMMode              string               `json:"fim_mode"`   // "tokens" or "server"
+	KeepAlive            string               `json:"keep_alive"` // How long Ollama keeps the model loaded
+	Sampling             SamplingConfig       `json:"sampling"`
+	ExtraBody            map[string]any       `json:"extra_body"`           // Fields merged into every request body
+	CursorTargetMarker   string               `json:"cursor_target_marker"` // Output line prefix naming the next edit location ("" = off)
+	Fallbacks            []ProviderConfig     `json:"fallbacks"`            // Providers tried in order when this one fails
+	FallbackTimeout      int                  `json:"fallback_timeout"`     // in milliseconds (0 = wait for completion_timeout)
+}
+
+// ResolveAPIKey reads the API key from the configured env var or key file.
+// Returns "" when neither is configured.
+func (p *ProviderConfig) ResolveAPIKey() (string, error) {
+	if p.APIKeyEnv != "" {
+		key := strings.TrimSpace(os.Getenv(p.APIKeyEnv))
+		if key == "" {
+			return "", fmt.Errorf("api_key_env: environment variable %s is not set", p.APIKeyEnv)
+		}
+		return key, nil
+	}
+
+	if p.APIKeyFile != "" {
+		data, err := os.ReadFile(p.APIKeyFile)
+		if err != nil {
+			return "", fmt.Errorf("api_key_file: %w", err)
+		}
+		key := strings.TrimSpace(string(data))
+		if key == "" {
+			return "", fmt.Errorf("api_key_file: %s is empty", p.APIKeyFile)
+		}
+		return key, nil
+	}
+
+	return "", nil
+}
+
+// ProviderRuleConfig routes files matching a glob or filetype to another provider
+type ProviderRuleConfig struct {
+	Pattern  string          `json:"pattern"`  // Glob matched against the workspace-relative path
+	FileType string          `json:"filetype"` // Neovim filetype
+	Disabled bool            `json:"disabled"` // Turn completions off for matching files
+	Provider *ProviderConfig `json:"provider"` // Provider for matching files (unused when disabled)
+}
+
+// HistoryConfig controls the on-disk edit history kept across daemon restarts
+type HistoryConfig struct {
+	Persist   bool   `json:"persist"`
+	Dir       string `json:"dir"`         // Absolute cache directory; nothing is written outside it
+	MaxSizeMB int    `json:"max_size_mb"` // Size cap for the stored history
 }
 
 // DebugConfig holds debug settings
@@ -53,20 +131,20 @@ type DebugConfig struct {
 
 // Config is the main configuration structure
 type Config struct {
-	NsID     int            `json:"ns_id"`
-	LogLevel string         `json:"log_level"`
-	Behavior BehaviorConfig `json:"behavior"`
-	Provider ProviderConfig `json:"provider"`
-	Debug    DebugConfig    `json:"debug"`
+	NsID     int                  `json:"ns_id"`
+	LogLevel string               `json:"log_level"`
+	Behavior BehaviorConfig       `json:"behavior"`
+	Provider ProviderConfig       `json:"provider"`
+	Rules    []ProviderRuleConfig `json:"rules"` // Per-file provider overrides; first match wins
+	History  HistoryConfig        `json:"history"`
+	Debug    DebugConfig          `json:"debug"`
 }
 
 // Validate checks that the config has valid values.
 // All config must come from the Lua client - no defaults are applied here.
 func (c *Config) Validate() error {
-	// Validate provider type
-	validProviders := map[string]bool{"inline": true, "fim": true, "sweep": true, "zeta": true}
-	if !validProviders[c.Provider.Type] {
-		return fmt.Errorf("invalid provider.type %q: must be one of inline, fim, sweep, zeta", c.Provider.Type)
+	if err := c.Provider.validate("provider"); err != nil {
+		return err
 	}
 
 	// Validate log level
@@ -82,35 +160,259 @@ func (c *Config) Validate() error {
 	if c.Behavior.TextChangeDebounce < 0 {
 		return fmt.Errorf("invalid behavior.text_change_debounce %d: must be >= 0", c.Behavior.TextChangeDebounce)
 	}
-	if c.Provider.MaxTokens < 0 {
-		return fmt.Errorf("invalid provider.max_tokens %d: must be >= 0", c.Provider.MaxTokens)
-	}
 	if c.Provider.CompletionTimeout < 0 {
 		return fmt.Errorf("invalid provider.completion_timeout %d: must be >= 0", c.Provider.CompletionTimeout)
 	}
 	if c.Provider.MaxDiffHistoryTokens < 0 {
 		return fmt.Errorf("invalid provider.max_diff_history_tokens %d: must be >= 0", c.Provider.MaxDiffHistoryTokens)
 	}
+	if c.Provider.DiffHistoryFiles < 1 {
+		return fmt.Errorf("invalid provider.diff_history_files %d: must be >= 1", c.Provider.DiffHistoryFiles)
+	}
+	if c.Provider.ContextBuffers < 0 {
+		return fmt.Errorf("invalid provider.context_buffers %d: must be >= 0", c.Provider.ContextBuffers)
+	}
+
+	if c.History.Persist {
+		if !filepath.IsAbs(c.History.Dir) {
+			return fmt.Errorf("invalid history.dir %q: must be an absolute path", c.History.Dir)
+		}
+		if c.History.MaxSizeMB <= 0 {
+			return fmt.Errorf("invalid history.max_size_mb %d: must be > 0", c.History.MaxSizeMB)
+		}
+	}
+
+	// Validate per-file rules
+	for i, rule := range c.Rules {
+		field := fmt.Sprintf("rules[%d]", i+1)
+		if rule.Pattern == "" && rule.FileType == "" {
+			return fmt.Errorf("invalid %s: pattern or filetype is required", field)
+		}
+		if _, err := path.Match(strings.ReplaceAll(rule.Pattern, "**", "*"), ""); err != nil {
+			return fmt.Errorf("invalid %s.pattern %q: %w", field, rule.Pattern, err)
+		}
+		if rule.Disabled {
+			continue
+		}
+		if rule.Provider == nil {
+			return fmt.Errorf("invalid %s: provider is required unless disabled", field)
+		}
+		if err := rule.Provider.validate(field + ".provider"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validate checks the settings needed to build a single provider.
+// field is the config path used in error messages (e.g. "provider").
+func (p *ProviderConfig) validate(field string) error {
+	// Validate provider type
+	validProviders := map[string]bool{"inline": true, "fim": true, "infill": true, "sweep": true, "zeta": true, "nextedit": true}
+	if !validProviders[p.Type] {
+		return fmt.Errorf("invalid %s.type %q: must be one of inline, fim, infill, sweep, zeta, nextedit", field, p.Type)
+	}
+
+	if p.MaxTokens < 0 {
+		return fmt.Errorf("invalid %s.max_tokens %d: must be >= 0", field, p.MaxTokens)
+	}
+	if p.Candidates < 0 {
+		return fmt.Errorf("invalid %s.candidates %d: must be >= 0", field, p.Candidates)
+	}
+	if p.MaxConcurrent < 0 {
+		return fmt.Errorf("invalid %s.max_concurrent %d: must be >= 0", field, p.MaxConcurrent)
+	}
+	if p.MaxRetries < 0 {
+		return fmt.Errorf("invalid %s.max_retries %d: must be >= 0", field, p.MaxRetries)
+	}
+	if p.CircuitBreaker.Failures < 0 {
+		return fmt.Errorf("invalid %s.circuit_breaker.failures %d: must be >= 0", field, p.CircuitBreaker.Failures)
+	}
+	if p.CircuitBreaker.Cooldown < 0 {
+		return fmt.Errorf("invalid %s.circuit_breaker.cooldown %d: must be >= 0", field, p.CircuitBreaker.Cooldown)
+	}
 
 	// Validate completion_path starts with /
-	if !strings.HasPrefix(c.Provider.CompletionPath, "/") {
-		return fmt.Errorf("invalid provider.completion_path %q: must start with /", c.Provider.CompletionPath)
+	if !strings.HasPrefix(p.CompletionPath, "/") {
+		return fmt.Errorf("invalid %s.completion_path %q: must start with /", field, p.CompletionPath)
+	}
+
+	// Validate api wire format
+	validAPIs := map[string]bool{"completions": true, "chat": true, "fim": true, "ollama": true}
+	if !validAPIs[p.API] {
+		return fmt.Errorf("invalid %s.api %q: must be one of completions, chat, fim, ollama", field, p.API)
+	}
+	if p.KeepAlive != "" {
+		if _, err := time.ParseDuration(p.KeepAlive); err != nil {
+			return fmt.Errorf("invalid %s.keep_alive %q: must be a duration such as \"10m\"", field, p.KeepAlive)
+		}
+	}
+
+	if err := p.Sampling.validate(field + ".sampling"); err != nil {
+		return err
+	}
+	if _, ok := p.ExtraBody["stream"]; ok {
+		return fmt.Errorf("invalid %s.extra_body: stream is set by the provider", field)
+	}
+
+	// Validate API key source
+	if p.APIKeyEnv != "" && p.APIKeyFile != "" {
+		return fmt.Errorf("invalid %s: api_key_env and api_key_file are mutually exclusive", field)
+	}
+
+	validFIMModes := map[string]bool{"tokens": true, "server": true}
+	if !validFIMModes[p.FIMMode] {
+		return fmt.Errorf("invalid %s.fim_mode %q: must be one of tokens, server", field, p.FIMMode)
+	}
+	// The chat wire format has no suffix field to carry the text after the cursor
+	if p.FIMMode == "server" && p.API == "chat" {
+		return fmt.Errorf("invalid %s.fim_mode \"server\": needs api completions, fim, or ollama", field)
 	}
 
 	// Validate fim_tokens fields are all non-empty
-	if c.Provider.FIMTokens.Prefix == "" {
-		return fmt.Errorf("invalid provider.fim_tokens.prefix: must be non-empty")
+	if p.FIMTokens.Prefix == "" {
+		return fmt.Errorf("invalid %s.fim_tokens.prefix: must be non-empty", field)
 	}
-	if c.Provider.FIMTokens.Suffix == "" {
-		return fmt.Errorf("invalid provider.fim_tokens.suffix: must be non-empty")
+	if p.FIMTokens.Suffix == "" {
+		return fmt.Errorf("invalid %s.fim_tokens.suffix: must be non-empty", field)
+	}
+	if p.FIMTokens.Middle == "" {
+		return fmt.Errorf("invalid %s.fim_tokens.middle: must be non-empty", field)
+	}
+
+	// Validate fallback chain members
+	if p.FallbackTimeout < 0 {
+		return fmt.Errorf("invalid %s.fallback_timeout %d: must be >= 0", field, p.FallbackTimeout)
 	}
-	if c.Provider.FIMTokens.Middle == "" {
-		return fmt.Errorf("invalid provider.fim_tokens.middle: must be non-empty")
+	for i := range p.Fallbacks {
+		fallback := &p.Fallbacks[i]
+		fallbackField := fmt.Sprintf("%s.fallbacks[%d]", field, i+1)
+		if len(fallback.Fallbacks) > 0 {
+			return fmt.Errorf("invalid %s.fallbacks: fallbacks cannot be nested", fallbackField)
+		}
+		if err := fallback.validate(fallbackField); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
+// validate checks that the sampling parameters are in range
+func (s *SamplingConfig) validate(field string) error {
+	if s.MinP != nil && (*s.MinP < 0 || *s.MinP > 1) {
+		return fmt.Errorf("invalid %s.min_p %g: must be between 0 and 1", field, *s.MinP)
+	}
+	if s.RepetitionPenalty != nil && *s.RepetitionPenalty <= 0 {
+		return fmt.Errorf("invalid %s.repetition_penalty %g: must be > 0", field, *s.RepetitionPenalty)
+	}
+	if s.PresencePenalty != nil && (*s.PresencePenalty < -2 || *s.PresencePenalty > 2) {
+		return fmt.Errorf("invalid %s.presence_penalty %g: must be between -2 and 2", field, *s.PresencePenalty)
+	}
+	for token, bias := range s.LogitBias {
+		if _, err := strconv.Atoi(token); err != nil {
+			return fmt.Errorf("invalid %s.logit_bias key %q: must be a token ID", field, token)
+		}
+		if bias < -100 || bias > 100 {
+			return fmt.Errorf("invalid %s.logit_bias[%s] %g: must be between -100 and 100", field, token, bias)
+		}
+	}
+	return nil
+}
+
+// redactedValue replaces secret values in logged config
+const redactedValue = "<redacted>"
+
+// Redacted returns a copy of the config that is safe to log.
+// Header and extra_body values may carry credentials, so they are masked.
+func (c Config) Redacted() Config {
+	c.Provider = c.Provider.redacted()
+	if len(c.Rules) > 0 {
+		rules := make([]ProviderRuleConfig, len(c.Rules))
+		for i, rule := range c.Rules {
+			if rule.Provider != nil {
+				provider := rule.Provider.redacted()
+				rule.Provider = &provider
+			}
+			rules[i] = rule
+		}
+		c.Rules = rules
+	}
+	return c
+}
+
+func (p ProviderConfig) redacted() ProviderConfig {
+	if len(p.Headers) > 0 {
+		headers := make(map[string]string, len(p.Headers))
+		for key := range p.Headers {
+			headers[key] = redactedValue
+		}
+		p.Headers = headers
+	}
+	if len(p.ExtraBody) > 0 {
+		extraBody := make(map[string]any, len(p.ExtraBody))
+		for key := range p.ExtraBody {
+			extraBody[key] = redactedValue
+		}
+		p.ExtraBody = extraBody
+	}
+	if len(p.Fallbacks) > 0 {
+		fallbacks := make([]ProviderConfig, len(p.Fallbacks))
+		for i, fallback := range p.Fallbacks {
+			fallbacks[i] = fallback.redacted()
+		}
+		p.Fallbacks = fallbacks
+	}
+	return p
+}
+
+// Diff returns the dotted paths of settings that differ between two configs,
+// e.g. "provider.model". ns_id is assigned per Neovim instance and ignored.
+func (c Config) Diff(other Config) []string {
+	a, b := c.flatten(), other.flatten()
+	diff := []string{}
+	for key, value := range a {
+		if b[key] != value {
+			diff = append(diff, key)
+		}
+	}
+	for key := range b {
+		if _, ok := a[key]; !ok {
+			diff = append(diff, key)
+		}
+	}
+	sort.Strings(diff)
+	return diff
+}
+
+// flatten maps each leaf setting's dotted path to its JSON encoding.
+// Lists are compared as a whole.
+func (c Config) flatten() map[string]string {
+	data, _ := json.Marshal(c)
+	var tree map[string]any
+	json.Unmarshal(data, &tree)
+	delete(tree, "ns_id")
+
+	flat := make(map[string]string)
+	var walk func(prefix string, value any)
+	walk = func(prefix string, value any) {
+		if object, ok := value.(map[string]any); ok && len(object) > 0 {
+			for key, child := range object {
+				if prefix != "" {
+					key = prefix + "." + key
+				}
+				walk(key, child)
+			}
+			return
+		}
+		encoded, _ := json.Marshal(value)
+		flat[prefix] = string(encoded)
+	}
+	walk("", tree)
+	return flat
+}
+
 type ServerMode string
 
 const (
@@ -155,6 +457,15 @@ func getPidPath() string {
 	return filepath.Join(execDir, "cursortab.pid")
 }
 
+func getStatsPath() string {
+	execPath, err := os.Executable()
+	if err != nil {
+		logger.Fatal("error getting executable path: %v", err)
+	}
+	execDir := filepath.Dir(execPath)
+	return filepath.Join(execDir, "cursortab-stats.json")
+}
+
 func isDaemonRunning() (bool, int) {
 	pidPath := getPidPath()
 	data, err := os.ReadFile(pidPath)
@@ -178,17 +489,27 @@ func isDaemonRunning() (bool, int) {
 	return err == nil, pid
 }
 
-func loadConfig() Config {
+// parseConfig decodes and validates a config sent by the Lua client
+func parseConfig(data string) (Config, error) {
 	var config Config
-	if err := json.Unmarshal([]byte(os.Getenv("CURSORTAB_CONFIG")), &config); err != nil {
-		logger.Fatal("invalid config JSON: %v", err)
+	if err := json.Unmarshal([]byte(data), &config); err != nil {
+		return Config{}, fmt.Errorf("invalid config JSON: %w", err)
 	}
 
 	if err := config.Validate(); err != nil {
-		logger.Fatal("config validation failed: %v", err)
+		return Config{}, fmt.Errorf("config validation failed: %w", err)
+	}
+
+	return config, nil
+}
+
+func loadConfig() Config {
+	config, err := parseConfig(os.Getenv("CURSORTAB_CONFIG"))
+	if err != nil {
+		logger.Fatal("%v", err)
 	}
 
-	logger.Info("config: %+v", config)
+	logger.Info("config: %+v", config.Redacted())
 	return config
 }
 
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"cursortab/assert"
+	"testing"
+)
+
+func TestProviderConfigValidate_FIMModeServer(t *testing.T) {
+	tests := []struct {
+		api     string
+		wantErr bool
+	}{
+		{"completions", false},
+		{"fim", false},
+		{"ollama", false},
+		{"chat", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.api, func(t *testing.T) {
+			config := &ProviderConfig{
+				Type:           "fim",
+				CompletionPath: "/v1/completions",
+				API:            tt.api,
+				FIMMode:        "server",
+				FIMTokens:      FIMTokensConfig{Prefix: "<PRE>", Suffix: "<SUF>", Middle: "<MID>"},
+			}
+
+			err := config.validate("provider")
+			if tt.wantErr {
+				assert.Error(t, err, "fim_mode server with api "+tt.api)
+			} else {
+				assert.NoError(t, err, "fim_mode server with api "+tt.api)
+			}
+		})
+	}
+}
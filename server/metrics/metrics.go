@@ -0,0 +1,281 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// LatencyBucketsMs are the upper bounds of the latency histogram buckets in
+// milliseconds. Histograms have one more bucket for slower requests.
+var LatencyBucketsMs = []int64{50, 100, 200, 300, 500, 750, 1000, 1500, 2000, 3000, 5000}
+
+// Outcome is what happened to a completion stage shown to the user
+type Outcome int
+
+const (
+	Shown    Outcome = iota
+	Accepted         // Accepted with the accept key
+	Typed            // Typed through by the user
+	Rejected         // Dismissed or typed over
+)
+
+// Histogram counts latencies in LatencyBucketsMs buckets
+type Histogram struct {
+	Counts []int64 `json:"counts" msgpack:"counts"`
+	Count  int64   `json:"count" msgpack:"count"`
+	SumMs  int64   `json:"sum_ms" msgpack:"sum_ms"`
+}
+
+func (h *Histogram) observe(d time.Duration) {
+	if len(h.Counts) != len(LatencyBucketsMs)+1 {
+		h.Counts = make([]int64, len(LatencyBucketsMs)+1)
+	}
+	ms := d.Milliseconds()
+	bucket := len(LatencyBucketsMs)
+	for i, bound := range LatencyBucketsMs {
+		if ms <= bound {
+			bucket = i
+			break
+		}
+	}
+	h.Counts[bucket]++
+	h.Count++
+	h.SumMs += ms
+}
+
+// Outcomes counts what happened to shown completion stages
+type Outcomes struct {
+	Shown    int64 `json:"shown" msgpack:"shown"`
+	Accepted int64 `json:"accepted" msgpack:"accepted"`
+	Typed    int64 `json:"typed" msgpack:"typed"`
+	Rejected int64 `json:"rejected" msgpack:"rejected"`
+}
+
+func (o *Outcomes) add(outcome Outcome) {
+	switch outcome {
+	case Shown:
+		o.Shown++
+	case Accepted:
+		o.Accepted++
+	case Typed:
+		o.Typed++
+	case Rejected:
+		o.Rejected++
+	}
+}
+
+// ProviderStats holds the request counts and latencies of one provider
+type ProviderStats struct {
+	Requests  int64     `json:"requests" msgpack:"requests"`
+	Errors    int64     `json:"errors" msgpack:"errors"`
+	CacheHits int64     `json:"cache_hits" msgpack:"cache_hits"` // Requests served from the completion cache
+	FirstLine Histogram `json:"first_line" msgpack:"first_line"` // Request to first line of output
+	Total     Histogram `json:"total" msgpack:"total"`           // Request to full completion
+	Outcomes  Outcomes  `json:"outcomes" msgpack:"outcomes"`
+}
+
+// Stats is a snapshot of everything recorded since Since
+type Stats struct {
+	Since     int64                     `json:"since" msgpack:"since"` // Unix seconds
+	BucketsMs []int64                   `json:"buckets_ms" msgpack:"buckets_ms"`
+	Providers map[string]*ProviderStats `json:"providers" msgpack:"providers"`
+	FileTypes map[string]*Outcomes      `json:"file_types" msgpack:"file_types"`
+	Stages    []Outcomes                `json:"stages" msgpack:"stages"` // Index 0 is the first stage of a completion
+}
+
+func newStats() Stats {
+	return Stats{
+		Since:     time.Now().Unix(),
+		BucketsMs: LatencyBucketsMs,
+		Providers: make(map[string]*ProviderStats),
+		FileTypes: make(map[string]*Outcomes),
+		Stages:    []Outcomes{},
+	}
+}
+
+// Recorder collects completion metrics. It is safe for concurrent use, and
+// all methods are no-ops on a nil Recorder.
+type Recorder struct {
+	mu    sync.Mutex
+	stats Stats
+	path  string // File the stats are saved to ("" = memory only)
+}
+
+// NewRecorder creates a Recorder that saves its stats to path. Stats already
+// saved there are loaded; an unreadable file is reported and replaced on the
+// next save.
+func NewRecorder(path string) (*Recorder, error) {
+	r := &Recorder{stats: newStats(), path: path}
+	if path == "" {
+		return r, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return r, nil
+	}
+	if err != nil {
+		return r, err
+	}
+
+	stats := newStats()
+	if err := json.Unmarshal(data, &stats); err != nil {
+		return r, fmt.Errorf("decoding stats: %w", err)
+	}
+	stats.BucketsMs = LatencyBucketsMs
+	r.stats = stats
+	return r, nil
+}
+
+func (r *Recorder) provider(name string) *ProviderStats {
+	stats, ok := r.stats.Providers[name]
+	if !ok {
+		stats = &ProviderStats{}
+		r.stats.Providers[name] = stats
+	}
+	return stats
+}
+
+// RecordRequest counts a completion request sent to a provider
+func (r *Recorder) RecordRequest(provider string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.provider(provider).Requests++
+}
+
+// RecordError counts a failed completion request
+func (r *Recorder) RecordError(provider string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.provider(provider).Errors++
+}
+
+// RecordCacheHit counts a completion request served from the cache instead
+// of the provider
+func (r *Recorder) RecordCacheHit(provider string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.provider(provider).CacheHits++
+}
+
+// RecordFirstLine records the time from request to the first line of output
+func (r *Recorder) RecordFirstLine(provider string, d time.Duration) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.provider(provider).FirstLine.observe(d)
+}
+
+// RecordTotal records the time from request to the full completion
+func (r *Recorder) RecordTotal(provider string, d time.Duration) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.provider(provider).Total.observe(d)
+}
+
+// RecordOutcome counts what happened to a shown completion stage
+func (r *Recorder) RecordOutcome(provider, fileType string, stage int, outcome Outcome) {
+	if r == nil || stage < 0 {
+		return
+	}
+	if fileType == "" {
+		fileType = "none"
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.provider(provider).Outcomes.add(outcome)
+
+	fileTypeStats, ok := r.stats.FileTypes[fileType]
+	if !ok {
+		fileTypeStats = &Outcomes{}
+		r.stats.FileTypes[fileType] = fileTypeStats
+	}
+	fileTypeStats.add(outcome)
+
+	for len(r.stats.Stages) <= stage {
+		r.stats.Stages = append(r.stats.Stages, Outcomes{})
+	}
+	r.stats.Stages[stage].add(outcome)
+}
+
+// Snapshot returns a copy of the recorded stats
+func (r *Recorder) Snapshot() Stats {
+	if r == nil {
+		return newStats()
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	snapshot := r.stats
+	snapshot.Providers = make(map[string]*ProviderStats, len(r.stats.Providers))
+	for name, stats := range r.stats.Providers {
+		copied := *stats
+		copied.FirstLine.Counts = append([]int64{}, stats.FirstLine.Counts...)
+		copied.Total.Counts = append([]int64{}, stats.Total.Counts...)
+		snapshot.Providers[name] = &copied
+	}
+	snapshot.FileTypes = make(map[string]*Outcomes, len(r.stats.FileTypes))
+	for fileType, outcomes := range r.stats.FileTypes {
+		copied := *outcomes
+		snapshot.FileTypes[fileType] = &copied
+	}
+	snapshot.Stages = append([]Outcomes{}, r.stats.Stages...)
+	return snapshot
+}
+
+// Reset discards everything recorded so far
+func (r *Recorder) Reset() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stats = newStats()
+}
+
+// Save writes the stats to the recorder's file
+func (r *Recorder) Save() error {
+	if r == nil || r.path == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(r.Snapshot())
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so a crash never leaves a partial file
+	tmp, err := os.CreateTemp(filepath.Dir(r.path), filepath.Base(r.path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), r.path)
+}
@@ -0,0 +1,93 @@
+package metrics
+
+import (
+	"cursortab/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRecorder_Latency(t *testing.T) {
+	r, err := NewRecorder("")
+	assert.NoError(t, err, "NewRecorder")
+
+	r.RecordRequest("zeta")
+	r.RecordFirstLine("zeta", 40*time.Millisecond)
+	r.RecordTotal("zeta", 250*time.Millisecond)
+	r.RecordTotal("zeta", time.Minute)
+	r.RecordError("zeta")
+	r.RecordCacheHit("zeta")
+
+	stats := r.Snapshot().Providers["zeta"]
+	assert.Equal(t, int64(1), stats.Requests, "requests")
+	assert.Equal(t, int64(1), stats.Errors, "errors")
+	assert.Equal(t, int64(1), stats.CacheHits, "cache hits")
+	assert.Equal(t, int64(1), stats.FirstLine.Counts[0], "first line in the 50ms bucket")
+	assert.Equal(t, int64(1), stats.Total.Counts[3], "total in the 300ms bucket")
+	assert.Equal(t, int64(1), stats.Total.Counts[len(LatencyBucketsMs)], "slow request in the overflow bucket")
+	assert.Equal(t, int64(2), stats.Total.Count, "total count")
+	assert.Equal(t, int64(60250), stats.Total.SumMs, "total sum")
+}
+
+func TestRecorder_Outcomes(t *testing.T) {
+	r, _ := NewRecorder("")
+
+	r.RecordOutcome("zeta", "go", 0, Shown)
+	r.RecordOutcome("zeta", "go", 0, Accepted)
+	r.RecordOutcome("zeta", "go", 1, Shown)
+	r.RecordOutcome("zeta", "go", 1, Rejected)
+	r.RecordOutcome("fim", "", 0, Shown)
+	r.RecordOutcome("fim", "", 0, Typed)
+
+	stats := r.Snapshot()
+	assert.Equal(t, Outcomes{Shown: 2, Accepted: 1, Rejected: 1}, stats.Providers["zeta"].Outcomes, "zeta outcomes")
+	assert.Equal(t, Outcomes{Shown: 2, Accepted: 1, Rejected: 1}, *stats.FileTypes["go"], "go outcomes")
+	assert.Equal(t, Outcomes{Shown: 1, Typed: 1}, *stats.FileTypes["none"], "buffers without a filetype")
+	assert.Len(t, 2, stats.Stages, "stages")
+	assert.Equal(t, Outcomes{Shown: 2, Accepted: 1, Typed: 1}, stats.Stages[0], "first stage")
+	assert.Equal(t, Outcomes{Shown: 1, Rejected: 1}, stats.Stages[1], "second stage")
+}
+
+func TestRecorder_SnapshotIsCopy(t *testing.T) {
+	r, _ := NewRecorder("")
+	r.RecordTotal("zeta", time.Millisecond)
+
+	snapshot := r.Snapshot()
+	r.RecordTotal("zeta", time.Millisecond)
+
+	assert.Equal(t, int64(1), snapshot.Providers["zeta"].Total.Counts[0], "snapshot unchanged")
+}
+
+func TestRecorder_NilIsNoop(t *testing.T) {
+	var r *Recorder
+	r.RecordRequest("zeta")
+	r.RecordOutcome("zeta", "go", 0, Shown)
+	r.Reset()
+	assert.NoError(t, r.Save(), "Save")
+	assert.Len(t, 0, r.Snapshot().Providers, "no providers")
+}
+
+func TestRecorder_SaveAndLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stats.json")
+
+	r, err := NewRecorder(path)
+	assert.NoError(t, err, "NewRecorder without a file")
+	r.RecordRequest("zeta")
+	r.RecordOutcome("zeta", "go", 0, Shown)
+	assert.NoError(t, r.Save(), "Save")
+
+	loaded, err := NewRecorder(path)
+	assert.NoError(t, err, "NewRecorder with a file")
+	stats := loaded.Snapshot()
+	assert.Equal(t, int64(1), stats.Providers["zeta"].Requests, "requests")
+	assert.Equal(t, int64(1), stats.FileTypes["go"].Shown, "shown")
+
+	loaded.Reset()
+	assert.Len(t, 0, loaded.Snapshot().Providers, "reset")
+
+	assert.NoError(t, os.WriteFile(path, []byte("{"), 0o600), "WriteFile")
+	corrupt, err := NewRecorder(path)
+	assert.Error(t, err, "corrupt file reported")
+	assert.NotNil(t, corrupt, "usable recorder despite the corrupt file")
+}
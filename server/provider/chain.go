@@ -0,0 +1,487 @@
+package provider
+
+import (
+	"context"
+	"cursortab/client/openai"
+	"cursortab/engine"
+	"cursortab/logger"
+	"cursortab/types"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Compile-time checks that Chain implements the required interfaces
+var _ engine.Provider = (*Chain)(nil)
+var _ engine.LineStreamProvider = (*Chain)(nil)
+var _ engine.TokenStreamProvider = (*Chain)(nil)
+var _ engine.NamedProvider = (*Chain)(nil)
+var _ engine.CacheKeyProvider = (*Chain)(nil)
+
+// Chain implements engine.Provider over an ordered list of providers.
+// The first member is the primary. The next member is tried when the current one
+// skips the request, fails, or produces no output within AttemptTimeout.
+//
+// A chain streams the way its primary does. Members with the same streaming type
+// and trim window are streamed through as-is; any other member runs in batch mode
+// and its completion is replayed in the primary's stream format.
+type Chain struct {
+	Members        []*Provider
+	AttemptTimeout time.Duration // Max wait for a member's first output before falling back (0 = no limit)
+}
+
+// NewChain creates a fallback chain; members[0] is the primary
+func NewChain(members []*Provider, attemptTimeout time.Duration) *Chain {
+	return &Chain{
+		Members:        members,
+		AttemptTimeout: attemptTimeout,
+	}
+}
+
+// chainContext is the provider context handed to the engine for a chained request.
+// The embedded Context belongs to the first member that accepted the request and
+// defines the window the engine diffs streamed lines against.
+type chainContext struct {
+	*Context
+
+	mu        sync.Mutex
+	active    *Provider                 // Member currently serving the request
+	activeCtx *Context                  // Pipeline context of the active member
+	replayed  *types.CompletionResponse // Set when output was replayed from a batch completion
+}
+
+func (c *chainContext) setActive(p *Provider, pctx *Context, replayed *types.CompletionResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.active = p
+	c.activeCtx = pctx
+	c.replayed = replayed
+}
+
+func (c *chainContext) snapshot() (*Provider, *Context, *types.CompletionResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.active, c.activeCtx, c.replayed
+}
+
+// GetCursorTarget returns the cursor target named by the member that served
+// the request. Implements engine.CursorTargetContext interface.
+func (c *chainContext) GetCursorTarget() *types.CursorPredictionTarget {
+	_, activeCtx, replayed := c.snapshot()
+	if replayed != nil {
+		return replayed.CursorTarget
+	}
+	if activeCtx == nil {
+		return nil
+	}
+	return activeCtx.CursorTarget
+}
+
+// chainStream is the stream handed to the engine for a chained request.
+// It forwards output from whichever member is serving the request.
+type chainStream struct {
+	lines        chan string
+	cancel       context.CancelFunc
+	finishReason string // Set before lines is closed
+}
+
+// LinesChan returns the channel for receiving lines (implements engine.LineStream)
+func (s *chainStream) LinesChan() <-chan string { return s.lines }
+
+// Cancel cancels the stream and any in-flight member request (implements engine.LineStream)
+func (s *chainStream) Cancel() { s.cancel() }
+
+// FinishReason returns why the stream ended, "error" if every member failed
+// (implements engine.LineStream)
+func (s *chainStream) FinishReason() string { return s.finishReason }
+
+// ProviderName returns the member names in fallback order, e.g. "zeta>fim" (implements engine.NamedProvider)
+func (c *Chain) ProviderName() string {
+	names := make([]string, len(c.Members))
+	for i, m := range c.Members {
+		names[i] = m.ProviderName()
+	}
+	return strings.Join(names, ">")
+}
+
+// CacheKey returns the cache key of the first member that would send the
+// request (implements engine.CacheKeyProvider)
+func (c *Chain) CacheKey(req *types.CompletionRequest) string {
+	for _, m := range c.Members {
+		if key := m.CacheKey(req); key != "" {
+			return key
+		}
+	}
+	return ""
+}
+
+// GetStreamingType returns the primary's streaming type (implements engine.LineStreamProvider)
+func (c *Chain) GetStreamingType() int {
+	return c.Members[0].GetStreamingType()
+}
+
+// GetCompletion tries each member in batch mode until one succeeds (implements engine.Provider)
+func (c *Chain) GetCompletion(ctx context.Context, req *types.CompletionRequest) (*types.CompletionResponse, error) {
+	defer logger.Trace("Chain.GetCompletion")()
+	var lastErr error
+
+	for _, m := range c.Members {
+		pctx, err := c.prepare(m, req)
+		if err != nil {
+			if !errors.Is(err, ErrSkipCompletion) {
+				lastErr = err
+			}
+			continue
+		}
+
+		m.logRequest(pctx.CompletionRequest, pctx.MaxLines)
+		resp, err := c.completeAttempt(ctx, m, pctx)
+		if err == nil {
+			return resp, nil
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		logger.Warn("%v, falling back", err)
+		lastErr = err
+	}
+
+	if lastErr != nil {
+		return nil, lastErr
+	}
+	return c.Members[0].EmptyResponse(), nil
+}
+
+// PrepareLineStream starts a line stream served by the first member that accepts
+// the request (implements engine.LineStreamProvider)
+func (c *Chain) PrepareLineStream(ctx context.Context, req *types.CompletionRequest) (engine.LineStream, any, error) {
+	defer logger.Trace("Chain.PrepareLineStream")()
+	return c.start(ctx, req, c.runLines)
+}
+
+// ValidateFirstLine delegates to the member that produced the line (implements engine.LineStreamProvider)
+func (c *Chain) ValidateFirstLine(providerCtx any, firstLine string) error {
+	cctx, ok := providerCtx.(*chainContext)
+	if !ok {
+		return fmt.Errorf("invalid provider context type")
+	}
+
+	active, activeCtx, replayed := cctx.snapshot()
+	if active == nil || replayed != nil {
+		return nil
+	}
+	return active.ValidateFirstLine(activeCtx, firstLine)
+}
+
+// FinishLineStream delegates to the member that served the stream (implements engine.LineStreamProvider)
+func (c *Chain) FinishLineStream(providerCtx any, text string, finishReason string, stoppedEarly bool) (*types.CompletionResponse, error) {
+	cctx, ok := providerCtx.(*chainContext)
+	if !ok {
+		return c.Members[0].EmptyResponse(), fmt.Errorf("invalid provider context type")
+	}
+
+	active, activeCtx, replayed := cctx.snapshot()
+	if replayed != nil {
+		return replayed, nil
+	}
+	if active == nil {
+		return c.Members[0].EmptyResponse(), nil
+	}
+	return active.FinishLineStream(activeCtx, text, finishReason, stoppedEarly)
+}
+
+// PrepareTokenStream starts a token stream served by the first member that accepts
+// the request (implements engine.TokenStreamProvider)
+func (c *Chain) PrepareTokenStream(ctx context.Context, req *types.CompletionRequest) (engine.LineStream, any, error) {
+	defer logger.Trace("Chain.PrepareTokenStream")()
+	return c.start(ctx, req, c.runTokens)
+}
+
+// FinishTokenStream delegates to the member that served the stream (implements engine.TokenStreamProvider)
+func (c *Chain) FinishTokenStream(providerCtx any, text string) (*types.CompletionResponse, error) {
+	cctx, ok := providerCtx.(*chainContext)
+	if !ok {
+		return c.Members[0].EmptyResponse(), fmt.Errorf("invalid provider context type")
+	}
+
+	active, activeCtx, replayed := cctx.snapshot()
+	if replayed != nil {
+		return replayed, nil
+	}
+	if active == nil {
+		return c.Members[0].EmptyResponse(), nil
+	}
+	return active.FinishTokenStream(activeCtx, text)
+}
+
+// chainRunner serves a chained stream starting at Members[start], whose pipeline
+// context has already been prepared, and returns the stream's finish reason
+type chainRunner func(ctx context.Context, out chan<- string, cctx *chainContext, start int) string
+
+// start finds the first member that accepts the request and runs the chain from it.
+// Skips and preprocessor errors fall through to the next member synchronously.
+func (c *Chain) start(ctx context.Context, req *types.CompletionRequest, run chainRunner) (engine.LineStream, any, error) {
+	lastErr := ErrSkipCompletion
+
+	for i, m := range c.Members {
+		pctx, err := c.prepare(m, req)
+		if err != nil {
+			if !errors.Is(err, ErrSkipCompletion) {
+				lastErr = err
+			}
+			continue
+		}
+
+		ctx, cancel := context.WithCancel(ctx)
+		stream := &chainStream{
+			lines:  make(chan string, 100),
+			cancel: cancel,
+		}
+		cctx := &chainContext{Context: pctx}
+
+		go func() {
+			defer close(stream.lines)
+			stream.finishReason = run(ctx, stream.lines, cctx, i)
+		}()
+
+		return stream, cctx, nil
+	}
+
+	return nil, nil, lastErr
+}
+
+// prepare runs a member's preprocessors, logging why it was passed over
+func (c *Chain) prepare(m *Provider, req *types.CompletionRequest) (*Context, error) {
+	pctx, err := m.prepareOnce(req)
+	if errors.Is(err, ErrSkipCompletion) {
+		logger.Debug("%s: skipped request, trying next provider", m.Name)
+	} else if err != nil {
+		logger.Warn("%v, falling back", err)
+	}
+	return pctx, err
+}
+
+// completeAttempt runs a member in batch mode, bounded by AttemptTimeout
+func (c *Chain) completeAttempt(ctx context.Context, m *Provider, pctx *Context) (*types.CompletionResponse, error) {
+	if c.AttemptTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.AttemptTimeout)
+		defer cancel()
+	}
+	return m.complete(ctx, pctx)
+}
+
+// runLines serves a line stream, moving down the chain until a member produces output
+func (c *Chain) runLines(ctx context.Context, out chan<- string, cctx *chainContext, start int) string {
+	pctx := cctx.Context
+	for i := start; i < len(c.Members); i++ {
+		m := c.Members[i]
+		if i > start {
+			var err error
+			if pctx, err = c.prepare(m, cctx.Request); err != nil {
+				continue
+			}
+		}
+
+		var served bool
+		finishReason := "stop"
+		if m.StreamingType == StreamingLines && sameWindow(pctx, cctx.Context) {
+			m.logRequest(pctx.CompletionRequest, pctx.MaxLines)
+			cctx.setActive(m, pctx, nil)
+			stream := m.Client.DoLineStream(ctx, pctx.CompletionRequest, pctx.MaxLines, m.StopTokens)
+			served, finishReason = c.forward(ctx, out, m, pctx, stream)
+		} else {
+			served = c.replay(ctx, out, cctx, m, pctx, windowLines)
+		}
+
+		if served || ctx.Err() != nil {
+			return finishReason
+		}
+	}
+	return "error"
+}
+
+// runTokens serves a token stream, moving down the chain until a member produces output
+func (c *Chain) runTokens(ctx context.Context, out chan<- string, cctx *chainContext, start int) string {
+	pctx := cctx.Context
+	for i := start; i < len(c.Members); i++ {
+		m := c.Members[i]
+		if i > start {
+			var err error
+			if pctx, err = c.prepare(m, cctx.Request); err != nil {
+				continue
+			}
+		}
+
+		var served bool
+		finishReason := "stop"
+		if m.StreamingType == StreamingTokens {
+			m.logRequest(pctx.CompletionRequest, 0)
+			cctx.setActive(m, pctx, nil)
+			stream := m.Client.DoTokenStream(ctx, pctx.CompletionRequest, 0, m.StopTokens)
+			served, finishReason = c.forward(ctx, out, m, nil, stream)
+		} else {
+			served = c.replay(ctx, out, cctx, m, pctx, ghostText)
+		}
+
+		if served || ctx.Err() != nil {
+			return finishReason
+		}
+	}
+	return "error"
+}
+
+// forward relays a member's stream to out, returning whether the member served
+// the request and its stream's finish reason. The member did not serve it when
+// it failed or timed out before producing anything, meaning the next member
+// should be tried.
+// Once output has been forwarded the member owns the request. Cursor target
+// marker lines are taken out of line streams, which pass the member's pctx.
+func (c *Chain) forward(ctx context.Context, out chan<- string, m *Provider, pctx *Context, stream *openai.LineStream) (bool, string) {
+	var timeout <-chan time.Time
+	if c.AttemptTimeout > 0 {
+		timer := time.NewTimer(c.AttemptTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	emitted := false
+	for {
+		select {
+		case line, ok := <-stream.LinesChan():
+			if !ok {
+				result := <-stream.DoneChan()
+				if !emitted && result.FinishReason == "error" {
+					logger.Warn("%s: stream failed, falling back", m.Name)
+					return false, result.FinishReason
+				}
+				return true, result.FinishReason
+			}
+			emitted = true
+			timeout = nil
+			if pctx != nil && pctx.takeCursorTarget(m.cursorTargetMarker(), line) {
+				continue
+			}
+			select {
+			case out <- line:
+			case <-ctx.Done():
+				return true, "cancelled"
+			}
+		case <-timeout:
+			stream.Cancel()
+			logger.Warn("%s: no output after %v, falling back", m.Name, c.AttemptTimeout)
+			return false, ""
+		case <-ctx.Done():
+			return true, "cancelled"
+		}
+	}
+}
+
+// replayFormat renders a batch completion as stream output for the chain's
+// streaming type. Returns false if the completion cannot be represented.
+type replayFormat func(window *Context, resp *types.CompletionResponse) ([]string, bool)
+
+// replay runs a member in batch mode and replays its completion as stream output
+func (c *Chain) replay(ctx context.Context, out chan<- string, cctx *chainContext, m *Provider, pctx *Context, format replayFormat) bool {
+	m.logRequest(pctx.CompletionRequest, pctx.MaxLines)
+	resp, err := c.completeAttempt(ctx, m, pctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			logger.Warn("%v, falling back", err)
+		}
+		return false
+	}
+
+	cctx.setActive(m, pctx, resp)
+	lines, ok := format(cctx.Context, resp)
+	if !ok {
+		logger.Debug("%s: completion cannot be shown in %s stream, dropped", m.Name, c.Members[0].Name)
+		return true
+	}
+
+	for _, line := range lines {
+		select {
+		case out <- line:
+		case <-ctx.Done():
+			return true
+		}
+	}
+	return true
+}
+
+// bounds returns the window the engine diffs line streams against:
+// the trimmed lines if the provider trimmed, otherwise the whole file
+func (c *Context) bounds() (start int, lines []string) {
+	if len(c.TrimmedLines) > 0 {
+		return c.WindowStart, c.TrimmedLines
+	}
+	return 0, c.Request.Lines
+}
+
+// sameWindow reports whether two contexts for the same request trimmed identically
+func sameWindow(a, b *Context) bool {
+	aStart, aLines := a.bounds()
+	bStart, bLines := b.bounds()
+	return aStart == bStart && len(aLines) == len(bLines)
+}
+
+// windowLines renders a completion as the full window with the edits applied,
+// which is what line-streaming providers emit
+func windowLines(window *Context, resp *types.CompletionResponse) ([]string, bool) {
+	if len(resp.Completions) == 0 {
+		return nil, true
+	}
+
+	// Candidates are alternatives, only the hunks of a disjoint edit combine
+	start, old := window.bounds()
+	completions := []*types.Completion{resp.Completions[0]}
+	if resp.Disjoint {
+		completions = append([]*types.Completion(nil), resp.Completions...)
+	}
+	sort.Slice(completions, func(i, j int) bool {
+		return completions[i].StartLine > completions[j].StartLine
+	})
+
+	lines := append([]string(nil), old...)
+	for _, comp := range completions {
+		from := comp.StartLine - 1 - start
+		to := comp.EndLineInc - start
+		if from < 0 || to < from || to > len(lines) {
+			return nil, false
+		}
+		lines = append(append(append([]string(nil), lines[:from]...), comp.Lines...), lines[to:]...)
+	}
+	return lines, true
+}
+
+// ghostText renders a completion as the cumulative text token streams emit:
+// only a single-line insertion at the cursor can be shown
+func ghostText(window *Context, resp *types.CompletionResponse) ([]string, bool) {
+	if len(resp.Completions) == 0 {
+		return nil, true
+	}
+
+	req := window.Request
+	comp := resp.Completions[0]
+	if len(resp.Completions) > 1 || len(comp.Lines) != 1 ||
+		comp.StartLine != req.CursorRow || comp.EndLineInc != req.CursorRow ||
+		req.CursorRow < 1 || req.CursorRow > len(req.Lines) {
+		return nil, false
+	}
+
+	currentLine := req.Lines[req.CursorRow-1]
+	cursorCol := min(req.CursorCol, len(currentLine))
+	prefix, suffix := currentLine[:cursorCol], currentLine[cursorCol:]
+	if !strings.HasPrefix(comp.Lines[0], prefix) {
+		return nil, false
+	}
+
+	text := strings.TrimSuffix(comp.Lines[0][len(prefix):], suffix)
+	if text == "" {
+		return nil, true
+	}
+	return []string{text}, true
+}
@@ -0,0 +1,257 @@
+package provider
+
+import (
+	"context"
+	"cursortab/assert"
+	"cursortab/client/openai"
+	"cursortab/types"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestServer serves text as a completion, streamed or batch depending on the request
+func newTestServer(t *testing.T, text string) (*httptest.Server, *int32) {
+	var hits int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+
+		var req openai.CompletionRequest
+		json.NewDecoder(r.Body).Decode(&req)
+
+		chunk, _ := json.Marshal(map[string]any{
+			"choices": []map[string]any{{"index": 0, "text": text, "finish_reason": "stop"}},
+		})
+		if !req.Stream {
+			w.Write(chunk)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: " + string(chunk) + "\n\ndata: [DONE]\n\n"))
+	}))
+	t.Cleanup(server.Close)
+	return server, &hits
+}
+
+// newFailingServer answers every request with HTTP 500
+func newFailingServer(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// newStalledServer never answers until the client gives up
+func newStalledServer(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body) // lets the server notice the client hanging up
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// newTestMember builds a provider whose completion replaces the cursor line with the model text
+func newTestMember(name, url string, streaming StreamingType, pre ...Preprocessor) *Provider {
+	config := &types.ProviderConfig{ProviderURL: url, CompletionPath: "/v1/completions"}
+	return &Provider{
+		Name:          name,
+		Config:        config,
+		Client:        NewClient(config),
+		StreamingType: streaming,
+		Preprocessors: pre,
+		PromptBuilder: func(p *Provider, ctx *Context) *openai.CompletionRequest {
+			return &openai.CompletionRequest{Model: p.Name, Prompt: "prompt"}
+		},
+		Postprocessors: []Postprocessor{
+			func(p *Provider, ctx *Context) (*types.CompletionResponse, bool) {
+				row := ctx.Request.CursorRow
+				return p.BuildCompletion(ctx, row, row, []string{ctx.Result.Text})
+			},
+		},
+	}
+}
+
+func skipAll() Preprocessor {
+	return func(p *Provider, ctx *Context) error { return ErrSkipCompletion }
+}
+
+func collect(stream interface{ LinesChan() <-chan string }) []string {
+	var lines []string
+	for line := range stream.LinesChan() {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func TestChainGetCompletion_FallsBackOnError(t *testing.T) {
+	fallback, _ := newTestServer(t, "fixed")
+	chain := NewChain([]*Provider{
+		newTestMember("primary", newFailingServer(t).URL, StreamingLines),
+		newTestMember("fallback", fallback.URL, StreamingLines),
+	}, 0)
+
+	resp, err := chain.GetCompletion(context.Background(), &types.CompletionRequest{
+		Lines:     []string{"broken", "b"},
+		CursorRow: 1,
+	})
+
+	assert.NoError(t, err, "GetCompletion")
+	assert.Len(t, 1, resp.Completions, "completions")
+	assert.Equal(t, []string{"fixed"}, resp.Completions[0].Lines, "fallback lines")
+}
+
+func TestChainGetCompletion_AllFail(t *testing.T) {
+	chain := NewChain([]*Provider{
+		newTestMember("primary", newFailingServer(t).URL, StreamingLines),
+		newTestMember("fallback", newFailingServer(t).URL, StreamingLines),
+	}, 0)
+
+	_, err := chain.GetCompletion(context.Background(), &types.CompletionRequest{
+		Lines:     []string{"a"},
+		CursorRow: 1,
+	})
+
+	assert.Error(t, err, "error when every member fails")
+}
+
+func TestChainGetCompletion_FallsBackOnSkip(t *testing.T) {
+	primary, primaryHits := newTestServer(t, "primary")
+	fallback, _ := newTestServer(t, "fallback")
+	chain := NewChain([]*Provider{
+		newTestMember("primary", primary.URL, StreamingLines, skipAll()),
+		newTestMember("fallback", fallback.URL, StreamingLines),
+	}, 0)
+
+	resp, err := chain.GetCompletion(context.Background(), &types.CompletionRequest{
+		Lines:     []string{"a"},
+		CursorRow: 1,
+	})
+
+	assert.NoError(t, err, "GetCompletion")
+	assert.Equal(t, int32(0), atomic.LoadInt32(primaryHits), "skipped member is not called")
+	assert.Equal(t, []string{"fallback"}, resp.Completions[0].Lines, "fallback lines")
+}
+
+func TestChainPrepareLineStream_AllSkip(t *testing.T) {
+	chain := NewChain([]*Provider{
+		newTestMember("primary", "http://unused", StreamingLines, skipAll()),
+	}, 0)
+
+	_, _, err := chain.PrepareLineStream(context.Background(), &types.CompletionRequest{Lines: []string{"a"}})
+
+	assert.True(t, err == ErrSkipCompletion, "ErrSkipCompletion when every member skips")
+}
+
+func TestChainPrepareLineStream_FallsBackOnTimeout(t *testing.T) {
+	fallback, _ := newTestServer(t, "x\ny\n")
+	chain := NewChain([]*Provider{
+		newTestMember("primary", newStalledServer(t).URL, StreamingLines),
+		newTestMember("fallback", fallback.URL, StreamingLines),
+	}, 50*time.Millisecond)
+
+	stream, providerCtx, err := chain.PrepareLineStream(context.Background(), &types.CompletionRequest{
+		Lines:     []string{"a", "b"},
+		CursorRow: 1,
+	})
+	assert.NoError(t, err, "PrepareLineStream")
+
+	assert.Equal(t, []string{"x", "y"}, collect(stream), "streamed fallback lines")
+	assert.NoError(t, chain.ValidateFirstLine(providerCtx, "x"), "ValidateFirstLine delegates")
+	assert.NotEqual(t, "error", stream.FinishReason(), "served by the fallback")
+}
+
+func TestChainPrepareLineStream_AllFail(t *testing.T) {
+	chain := NewChain([]*Provider{
+		newTestMember("primary", newFailingServer(t).URL, StreamingLines),
+		newTestMember("fallback", newFailingServer(t).URL, StreamingLines),
+	}, 0)
+
+	stream, _, err := chain.PrepareLineStream(context.Background(), &types.CompletionRequest{
+		Lines:     []string{"a"},
+		CursorRow: 1,
+	})
+	assert.NoError(t, err, "PrepareLineStream")
+
+	assert.Len(t, 0, collect(stream), "no lines")
+	assert.Equal(t, "error", stream.FinishReason(), "stream failed")
+}
+
+func TestChainPrepareLineStream_ReplaysBatchMember(t *testing.T) {
+	fallback, _ := newTestServer(t, "fixed")
+	chain := NewChain([]*Provider{
+		newTestMember("primary", newFailingServer(t).URL, StreamingLines),
+		newTestMember("fallback", fallback.URL, StreamingTokens),
+	}, 0)
+
+	stream, providerCtx, err := chain.PrepareLineStream(context.Background(), &types.CompletionRequest{
+		Lines:     []string{"a", "b", "c"},
+		CursorRow: 2,
+	})
+	assert.NoError(t, err, "PrepareLineStream")
+
+	assert.Equal(t, []string{"a", "fixed", "c"}, collect(stream), "completion replayed as window lines")
+
+	resp, err := chain.FinishLineStream(providerCtx, "", "stop", false)
+	assert.NoError(t, err, "FinishLineStream")
+	assert.Equal(t, []string{"fixed"}, resp.Completions[0].Lines, "replayed response")
+}
+
+func TestChainPrepareTokenStream_ReplaysBatchMember(t *testing.T) {
+	fallback, _ := newTestServer(t, "foobar")
+	chain := NewChain([]*Provider{
+		newTestMember("primary", "http://unused", StreamingTokens, skipAll()),
+		newTestMember("fallback", fallback.URL, StreamingLines),
+	}, 0)
+
+	stream, providerCtx, err := chain.PrepareTokenStream(context.Background(), &types.CompletionRequest{
+		Lines:     []string{"foo"},
+		CursorRow: 1,
+		CursorCol: 3,
+	})
+	assert.NoError(t, err, "PrepareTokenStream")
+
+	assert.Equal(t, []string{"bar"}, collect(stream), "completion replayed as ghost text")
+
+	resp, err := chain.FinishTokenStream(providerCtx, "bar")
+	assert.NoError(t, err, "FinishTokenStream")
+	assert.Equal(t, []string{"foobar"}, resp.Completions[0].Lines, "replayed response")
+}
+
+func TestWindowLines(t *testing.T) {
+	window := &Context{
+		Request:      &types.CompletionRequest{Lines: []string{"0", "1", "2", "3", "4"}},
+		TrimmedLines: []string{"1", "2", "3"},
+		WindowStart:  1,
+	}
+
+	lines, ok := windowLines(window, &types.CompletionResponse{
+		Completions: []*types.Completion{{StartLine: 3, EndLineInc: 3, Lines: []string{"two", "more"}}},
+	})
+	assert.True(t, ok, "inside window")
+	assert.Equal(t, []string{"1", "two", "more", "3"}, lines, "edit applied to window")
+
+	lines, ok = windowLines(window, &types.CompletionResponse{
+		Completions: []*types.Completion{
+			{StartLine: 2, EndLineInc: 2, Lines: []string{"one"}},
+			{StartLine: 4, EndLineInc: 4, Lines: []string{"three"}},
+		},
+		Disjoint: true,
+	})
+	assert.True(t, ok, "hunks inside window")
+	assert.Equal(t, []string{"one", "2", "three"}, lines, "all hunks applied")
+
+	_, ok = windowLines(window, &types.CompletionResponse{
+		Completions: []*types.Completion{{StartLine: 5, EndLineInc: 5, Lines: []string{"x"}}},
+	})
+	assert.False(t, ok, "outside window")
+}
@@ -0,0 +1,149 @@
+package provider
+
+import (
+	"context"
+	"cursortab/client/openai"
+	"cursortab/engine"
+	"cursortab/logger"
+	"cursortab/types"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Next-edit models configured with a cursor target marker may end their output
+// with a line naming where the next edit is, e.g. for the marker "<|next|>":
+//
+//	<|next|>42: return nil, err
+//
+// The number is the 1-indexed line in the file after the completion is
+// applied, and the optional text after the colon is what that line is expected
+// to contain. A target in another file of the workspace puts its relative path
+// in front of the line number:
+//
+//	<|next|>server/handler.go:17: func handle(req *Request) error {
+//
+// The marker line is taken out of the completion and the target is returned
+// in CompletionResponse.CursorTarget for the engine to validate.
+
+// cursorTargetMarker returns the configured marker, or "" when turned off
+func (p *Provider) cursorTargetMarker() string {
+	if p.Config == nil {
+		return ""
+	}
+	return p.Config.CursorTargetMarker
+}
+
+// parseCursorTarget parses a marker line. Returns false if line is not one.
+// A marker line with a malformed target still reports true with a nil target
+// so it is dropped from the completion.
+func parseCursorTarget(marker, filePath, line string) (*types.CursorPredictionTarget, bool) {
+	rest, ok := strings.CutPrefix(strings.TrimSpace(line), marker)
+	if marker == "" || !ok {
+		return nil, false
+	}
+
+	number, content, _ := strings.Cut(rest, ":")
+	if _, err := strconv.Atoi(strings.TrimSpace(number)); err != nil && content != "" {
+		// Not a line number, so it is the path of another file
+		filePath = strings.TrimSpace(number)
+		number, content, _ = strings.Cut(content, ":")
+		if filepath.IsAbs(filePath) {
+			logger.Debug("ignoring cursor target outside the workspace %q", line)
+			return nil, true
+		}
+	}
+	lineNumber, err := strconv.Atoi(strings.TrimSpace(number))
+	if err != nil || lineNumber < 1 {
+		logger.Debug("ignoring malformed cursor target %q", line)
+		return nil, true
+	}
+
+	return &types.CursorPredictionTarget{
+		RelativePath:    filePath,
+		LineNumber:      int32(lineNumber),
+		ExpectedContent: strings.TrimSpace(content),
+		ShouldRetrigger: true,
+	}, true
+}
+
+// takeCursorTarget records the target if line is a marker line.
+// Returns true if the line should be dropped from the completion.
+func (c *Context) takeCursorTarget(marker, line string) bool {
+	target, ok := parseCursorTarget(marker, c.Request.FilePath, line)
+	if !ok {
+		return false
+	}
+	if target != nil {
+		c.CursorTarget = target
+	}
+	return true
+}
+
+// extractCursorTarget takes marker lines out of the model text ahead of the
+// postprocessors
+func (p *Provider) extractCursorTarget(ctx *Context) {
+	marker := p.cursorTargetMarker()
+	if marker == "" || !strings.Contains(ctx.Result.Text, marker) {
+		return
+	}
+
+	lines := strings.Split(ctx.Result.Text, "\n")
+	kept := lines[:0]
+	for _, line := range lines {
+		if !ctx.takeCursorTarget(marker, line) {
+			kept = append(kept, line)
+		}
+	}
+	ctx.Result.Text = strings.Join(kept, "\n")
+}
+
+// GetCursorTarget returns the cursor target the model named, if any.
+// Implements engine.CursorTargetContext interface.
+func (c *Context) GetCursorTarget() *types.CursorPredictionTarget {
+	return c.CursorTarget
+}
+
+// cursorTargetStream relays a line stream without its marker lines, recording
+// the target on the pipeline context before the stream closes
+type cursorTargetStream struct {
+	lines  chan string
+	source *openai.LineStream
+}
+
+// LinesChan returns the channel for receiving lines (implements engine.LineStream)
+func (s *cursorTargetStream) LinesChan() <-chan string { return s.lines }
+
+// Cancel cancels the underlying stream (implements engine.LineStream)
+func (s *cursorTargetStream) Cancel() { s.source.Cancel() }
+
+// FinishReason returns why the underlying stream ended (implements engine.LineStream)
+func (s *cursorTargetStream) FinishReason() string { return s.source.FinishReason() }
+
+// filterCursorTarget wraps a line stream to take marker lines out of it.
+// Returns the stream unchanged when no marker is configured.
+func (p *Provider) filterCursorTarget(ctx context.Context, pctx *Context, stream *openai.LineStream) engine.LineStream {
+	marker := p.cursorTargetMarker()
+	if marker == "" {
+		return stream
+	}
+
+	filtered := &cursorTargetStream{
+		lines:  make(chan string, 100),
+		source: stream,
+	}
+	go func() {
+		defer close(filtered.lines)
+		for line := range stream.LinesChan() {
+			if pctx.takeCursorTarget(marker, line) {
+				continue
+			}
+			select {
+			case filtered.lines <- line:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return filtered
+}
@@ -0,0 +1,126 @@
+package provider
+
+import (
+	"context"
+	"cursortab/assert"
+	"cursortab/types"
+	"testing"
+)
+
+func TestParseCursorTarget(t *testing.T) {
+	tests := []struct {
+		name     string
+		line     string
+		isMarker bool
+		target   *types.CursorPredictionTarget
+	}{
+		{
+			name:     "line and content",
+			line:     "<|next|>42: return nil, err",
+			isMarker: true,
+			target:   &types.CursorPredictionTarget{RelativePath: "a.go", LineNumber: 42, ExpectedContent: "return nil, err", ShouldRetrigger: true},
+		},
+		{
+			name:     "line only",
+			line:     "  <|next|> 7",
+			isMarker: true,
+			target:   &types.CursorPredictionTarget{RelativePath: "a.go", LineNumber: 7, ShouldRetrigger: true},
+		},
+		{
+			name:     "other file",
+			line:     "<|next|>server/handler.go:17: func handle() {",
+			isMarker: true,
+			target:   &types.CursorPredictionTarget{RelativePath: "server/handler.go", LineNumber: 17, ExpectedContent: "func handle() {", ShouldRetrigger: true},
+		},
+		{
+			name:     "absolute path",
+			line:     "<|next|>/home/u/.ssh/id_rsa:1: key",
+			isMarker: true,
+		},
+		{
+			name:     "malformed line number",
+			line:     "<|next|>soon: x",
+			isMarker: true,
+		},
+		{
+			name: "ordinary line",
+			line: "x := next(1)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target, ok := parseCursorTarget("<|next|>", "a.go", tt.line)
+			assert.Equal(t, tt.isMarker, ok, "marker line")
+			assert.Equal(t, tt.target, target, "target")
+		})
+	}
+}
+
+func TestGetCompletion_ExtractsCursorTarget(t *testing.T) {
+	server, _ := newTestServer(t, "fixed\n<|next|>3: c")
+	p := newTestMember("test", server.URL, StreamingNone)
+	p.Config.CursorTargetMarker = "<|next|>"
+
+	resp, err := p.GetCompletion(context.Background(), &types.CompletionRequest{
+		FilePath:  "a.go",
+		Lines:     []string{"a", "b", "c"},
+		CursorRow: 1,
+	})
+
+	assert.NoError(t, err, "GetCompletion")
+	assert.Equal(t, []string{"fixed"}, resp.Completions[0].Lines, "marker line removed")
+	assert.NotNil(t, resp.CursorTarget, "cursor target")
+	assert.Equal(t, int32(3), resp.CursorTarget.LineNumber, "target line")
+	assert.Equal(t, "c", resp.CursorTarget.ExpectedContent, "expected content")
+}
+
+func TestGetCompletion_CursorTargetWithoutChanges(t *testing.T) {
+	server, _ := newTestServer(t, "a\n<|next|>3: c")
+	p := newTestMember("test", server.URL, StreamingNone)
+	p.Config.CursorTargetMarker = "<|next|>"
+
+	resp, err := p.GetCompletion(context.Background(), &types.CompletionRequest{
+		Lines:     []string{"a", "b", "c"},
+		CursorRow: 1,
+	})
+
+	assert.NoError(t, err, "GetCompletion")
+	assert.Len(t, 0, resp.Completions, "no-op dropped")
+	assert.NotNil(t, resp.CursorTarget, "cursor target kept")
+}
+
+func TestPrepareLineStream_FiltersCursorTarget(t *testing.T) {
+	server, _ := newTestServer(t, "x\n<|next|>2: b\ny\n")
+	p := newTestMember("test", server.URL, StreamingLines)
+	p.Config.CursorTargetMarker = "<|next|>"
+
+	stream, providerCtx, err := p.PrepareLineStream(context.Background(), &types.CompletionRequest{
+		Lines:     []string{"a", "b"},
+		CursorRow: 1,
+	})
+	assert.NoError(t, err, "PrepareLineStream")
+
+	assert.Equal(t, []string{"x", "y"}, collect(stream), "marker line not streamed")
+	target := providerCtx.(*Context).GetCursorTarget()
+	assert.NotNil(t, target, "cursor target recorded")
+	assert.Equal(t, int32(2), target.LineNumber, "target line")
+}
+
+func TestChainPrepareLineStream_FiltersCursorTarget(t *testing.T) {
+	server, _ := newTestServer(t, "x\n<|next|>2: b\n")
+	member := newTestMember("test", server.URL, StreamingLines)
+	member.Config.CursorTargetMarker = "<|next|>"
+	chain := NewChain([]*Provider{member}, 0)
+
+	stream, providerCtx, err := chain.PrepareLineStream(context.Background(), &types.CompletionRequest{
+		Lines:     []string{"a", "b"},
+		CursorRow: 1,
+	})
+	assert.NoError(t, err, "PrepareLineStream")
+
+	assert.Equal(t, []string{"x"}, collect(stream), "marker line not streamed")
+	target := providerCtx.(*chainContext).GetCursorTarget()
+	assert.NotNil(t, target, "cursor target recorded")
+	assert.Equal(t, int32(2), target.LineNumber, "target line")
+}
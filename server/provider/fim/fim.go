@@ -4,20 +4,26 @@ import (
 	"cursortab/client/openai"
 	"cursortab/provider"
 	"cursortab/types"
-	"strings"
 )
 
-// NewProvider creates a new fill-in-the-middle completion provider
+// NewProvider creates a new fill-in-the-middle completion provider.
+// The prompt joins prefix and suffix with the configured FIM tokens, unless
+// the server applies its own (FIMModeServer, or the Mistral FIM API).
 func NewProvider(config *types.ProviderConfig) *provider.Provider {
+	promptBuilder := buildPrompt
+	if config.FIMMode == types.FIMModeServer || config.API == types.APITypeFIM {
+		promptBuilder = buildServerPrompt
+	}
+
 	return &provider.Provider{
 		Name:          "fim",
 		Config:        config,
-		Client:        openai.NewClient(config.ProviderURL, config.CompletionPath),
+		Client:        provider.NewClient(config),
 		StreamingType: provider.StreamingLines,
 		Preprocessors: []provider.Preprocessor{
 			provider.TrimContent(),
 		},
-		PromptBuilder: buildPrompt,
+		PromptBuilder: promptBuilder,
 		Postprocessors: []provider.Postprocessor{
 			provider.RejectEmpty(),
 			provider.DropLastLineIfTruncated(),
@@ -33,69 +39,36 @@ func getFIMTokens(config *types.ProviderConfig) (prefix, suffix, middle string)
 
 func buildPrompt(p *provider.Provider, ctx *provider.Context) *openai.CompletionRequest {
 	prefixToken, suffixToken, middleToken := getFIMTokens(p.Config)
-	var prompt string
-
-	if len(ctx.TrimmedLines) == 0 {
-		prompt = prefixToken + suffixToken + middleToken
-	} else {
-		var prefixBuilder strings.Builder
-		var suffixBuilder strings.Builder
-
-		for i := range ctx.CursorLine {
-			prefixBuilder.WriteString(ctx.TrimmedLines[i])
-			prefixBuilder.WriteString("\n")
-		}
-
-		if ctx.CursorLine < len(ctx.TrimmedLines) {
-			currentLine := ctx.TrimmedLines[ctx.CursorLine]
-			cursorCol := min(ctx.Request.CursorCol, len(currentLine))
-			prefixBuilder.WriteString(currentLine[:cursorCol])
-			suffixBuilder.WriteString(currentLine[cursorCol:])
-		}
-
-		for i := ctx.CursorLine + 1; i < len(ctx.TrimmedLines); i++ {
-			suffixBuilder.WriteString("\n")
-			suffixBuilder.WriteString(ctx.TrimmedLines[i])
-		}
-
-		prompt = prefixToken + prefixBuilder.String() + suffixToken + suffixBuilder.String() + middleToken
-	}
+	prefix, suffix := provider.SplitAtCursor(ctx)
 
 	return &openai.CompletionRequest{
 		Model:       p.Config.ProviderModel,
-		Prompt:      prompt,
+		Prompt:      prefixToken + prefix + suffixToken + suffix + middleToken,
 		Temperature: p.Config.ProviderTemperature,
 		MaxTokens:   p.Config.ProviderMaxTokens,
 		TopK:        p.Config.ProviderTopK,
-		N:           1,
+		N:           p.Candidates(),
 		Echo:        false,
 	}
 }
 
-func parseCompletion(p *provider.Provider, ctx *provider.Context) (*types.CompletionResponse, bool) {
-	completionText := ctx.Result.Text
-	req := ctx.Request
-
-	currentLine := ""
-	if req.CursorRow >= 1 && req.CursorRow <= len(req.Lines) {
-		currentLine = req.Lines[req.CursorRow-1]
-	}
-	cursorCol := min(req.CursorCol, len(currentLine))
-
-	completionLines := strings.Split(completionText, "\n")
+// buildServerPrompt sends the text before the cursor as the prompt and the
+// text after it as the suffix, for servers that apply the FIM tokens themselves
+func buildServerPrompt(p *provider.Provider, ctx *provider.Context) *openai.CompletionRequest {
+	prefix, suffix := provider.SplitAtCursor(ctx)
 
-	beforeCursor := currentLine[:cursorCol]
-	afterCursor := currentLine[cursorCol:]
-
-	resultLines := make([]string, len(completionLines))
-	resultLines[0] = beforeCursor + completionLines[0]
-
-	for i := 1; i < len(completionLines); i++ {
-		resultLines[i] = completionLines[i]
+	return &openai.CompletionRequest{
+		Model:       p.Config.ProviderModel,
+		Prompt:      prefix,
+		Suffix:      suffix,
+		Temperature: p.Config.ProviderTemperature,
+		MaxTokens:   p.Config.ProviderMaxTokens,
+		TopK:        p.Config.ProviderTopK,
+		N:           p.Candidates(),
+		Echo:        false,
 	}
-
-	resultLines[len(resultLines)-1] += afterCursor
-
-	// FIM inserts content at cursor position - always replace only the current line
-	return p.BuildCompletion(ctx, req.CursorRow, req.CursorRow, resultLines)
 }
+
+// parseCompletion inserts the completion at the cursor. FIM inserts content
+// at the cursor position, so only the current line is replaced.
+var parseCompletion = provider.InsertAtCursor()
@@ -201,3 +201,23 @@ func TestParseCompletion_SingleLineWithAfterCursor(t *testing.T) {
 	// "func" + "tion" + "()"
 	assert.Equal(t, "function()", resp.Completions[0].Lines[0], "completion inserted at cursor with suffix")
 }
+
+func TestBuildServerPrompt(t *testing.T) {
+	for _, config := range []*types.ProviderConfig{
+		{ProviderModel: "test-model", FIMMode: types.FIMModeServer},
+		{ProviderModel: "test-model", API: types.APITypeFIM},
+	} {
+		p := NewProvider(config)
+
+		ctx := &provider.Context{
+			Request:      &types.CompletionRequest{CursorCol: 4},
+			TrimmedLines: []string{"package main", "func()", "}"},
+			CursorLine:   1,
+		}
+
+		req := p.PromptBuilder(p, ctx)
+
+		assert.Equal(t, "package main\nfunc", req.Prompt, "prompt holds the text before the cursor")
+		assert.Equal(t, "()\n}", req.Suffix, "suffix holds the text after the cursor")
+	}
+}
@@ -0,0 +1,92 @@
+package infill
+
+import (
+	"cursortab/client/openai"
+	"cursortab/provider"
+	"cursortab/types"
+	"slices"
+	"strings"
+)
+
+// NewProvider creates a new provider for the llama.cpp server's /infill
+// endpoint, which applies the model's FIM tokens itself
+func NewProvider(config *types.ProviderConfig) *provider.Provider {
+	return &provider.Provider{
+		Name:          "infill",
+		Config:        config,
+		Client:        provider.NewInfillClient(config),
+		StreamingType: provider.StreamingLines,
+		Preprocessors: []provider.Preprocessor{
+			provider.TrimContent(),
+		},
+		PromptBuilder: buildPrompt,
+		Postprocessors: []provider.Postprocessor{
+			provider.RejectEmpty(),
+			provider.DropLastLineIfTruncated(),
+			provider.InsertAtCursor(),
+		},
+	}
+}
+
+func buildPrompt(p *provider.Provider, ctx *provider.Context) *openai.CompletionRequest {
+	prefix, suffix := provider.SplitAtCursor(ctx)
+
+	return &openai.CompletionRequest{
+		Model:       p.Config.ProviderModel,
+		Prompt:      prefix,
+		Suffix:      suffix,
+		InputExtra:  buildExtraContext(ctx.Request),
+		Temperature: p.Config.ProviderTemperature,
+		MaxTokens:   p.Config.ProviderMaxTokens,
+		TopK:        p.Config.ProviderTopK,
+		N:           p.Candidates(),
+	}
+}
+
+// buildExtraContext returns the input_extra chunks: the text of other open
+// buffers, then the code recently written in other files. The server places
+// them before the prefix, so the most relevant chunks go last.
+func buildExtraContext(req *types.CompletionRequest) []openai.InfillChunk {
+	var chunks []openai.InfillChunk
+
+	// Context buffers come most recently used first
+	for _, buf := range slices.Backward(req.ContextBuffers) {
+		if strings.TrimSpace(buf.Text) == "" {
+			continue
+		}
+		chunks = append(chunks, openai.InfillChunk{
+			Filename: buf.FileName,
+			Text:     withTrailingNewline(buf.Text),
+		})
+	}
+
+	// Diff histories come oldest file first; the current file's edits are
+	// already in the prefix and suffix
+	for _, history := range req.FileDiffHistories {
+		if history.FileName == req.FilePath {
+			continue
+		}
+		var written []string
+		for _, diff := range history.DiffHistory {
+			if strings.TrimSpace(diff.Updated) != "" {
+				written = append(written, strings.TrimSuffix(diff.Updated, "\n"))
+			}
+		}
+		if len(written) == 0 {
+			continue
+		}
+		chunks = append(chunks, openai.InfillChunk{
+			Filename: history.FileName,
+			Text:     withTrailingNewline(strings.Join(written, "\n")),
+		})
+	}
+
+	return chunks
+}
+
+func withTrailingNewline(text string) string {
+	if strings.HasSuffix(text, "\n") {
+		return text
+	}
+	return text + "\n"
+}
@@ -0,0 +1,66 @@
+package infill
+
+import (
+	"cursortab/assert"
+	"cursortab/client/openai"
+	"cursortab/provider"
+	"cursortab/types"
+	"testing"
+)
+
+func TestNewProvider_InfillPath(t *testing.T) {
+	p := NewProvider(&types.ProviderConfig{CompletionPath: openai.DefaultCompletionPath})
+
+	client, ok := p.Client.(*openai.InfillClient)
+	assert.True(t, ok, "infill client")
+	assert.Equal(t, openai.DefaultInfillPath, client.CompletionPath, "default path swapped for /infill")
+
+	p = NewProvider(&types.ProviderConfig{CompletionPath: "/custom/infill"})
+	assert.Equal(t, "/custom/infill", p.Client.(*openai.InfillClient).CompletionPath, "custom path kept")
+}
+
+func TestBuildPrompt_PrefixAndSuffix(t *testing.T) {
+	p := NewProvider(&types.ProviderConfig{ProviderModel: "test-model", ProviderMaxTokens: 64})
+
+	ctx := &provider.Context{
+		Request:      &types.CompletionRequest{CursorCol: 4},
+		TrimmedLines: []string{"package main", "func()", "}"},
+		CursorLine:   1,
+	}
+
+	req := p.PromptBuilder(p, ctx)
+
+	assert.Equal(t, "package main\nfunc", req.Prompt, "text before the cursor")
+	assert.Equal(t, "()\n}", req.Suffix, "text after the cursor")
+	assert.Equal(t, 64, req.MaxTokens, "max tokens")
+	assert.Nil(t, req.InputExtra, "no extra context")
+}
+
+func TestBuildExtraContext(t *testing.T) {
+	req := &types.CompletionRequest{
+		FilePath: "main.go",
+		ContextBuffers: []*types.FileChunk{
+			{FileName: "recent.go", Text: "func recent() {}"},
+			{FileName: "older.go", Text: "func older() {}\n"},
+			{FileName: "empty.go", Text: "  "},
+		},
+		FileDiffHistories: []*types.FileDiffHistory{
+			{FileName: "edited.go", DiffHistory: []*types.DiffEntry{
+				{Original: "", Updated: "x := 1\n"},
+				{Original: "y := 2", Updated: ""},
+				{Original: "", Updated: "z := 3"},
+			}},
+			{FileName: "main.go", DiffHistory: []*types.DiffEntry{
+				{Original: "", Updated: "current file edit"},
+			}},
+		},
+	}
+
+	chunks := buildExtraContext(req)
+
+	assert.Equal(t, []openai.InfillChunk{
+		{Filename: "older.go", Text: "func older() {}\n"},
+		{Filename: "recent.go", Text: "func recent() {}\n"},
+		{Filename: "edited.go", Text: "x := 1\nz := 3\n"},
+	}, chunks, "chunks, most relevant last")
+}
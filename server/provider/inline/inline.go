@@ -12,7 +12,7 @@ func NewProvider(config *types.ProviderConfig) *provider.Provider {
 	return &provider.Provider{
 		Name:          "inline",
 		Config:        config,
-		Client:        openai.NewClient(config.ProviderURL, config.CompletionPath),
+		Client:        provider.NewClient(config),
 		StreamingType: provider.StreamingTokens, // Token-by-token streaming for ghost text
 		Preprocessors: []provider.Preprocessor{
 			provider.SkipIfTextAfterCursor(),
@@ -39,7 +39,7 @@ func buildPrompt(p *provider.Provider, ctx *provider.Context) *openai.Completion
 			MaxTokens:   p.Config.ProviderMaxTokens,
 			TopK:        p.Config.ProviderTopK,
 			Stop:        []string{"\n"},
-			N:           1,
+			N:           p.Candidates(),
 			Echo:        false,
 		}
 	}
@@ -66,7 +66,7 @@ func buildPrompt(p *provider.Provider, ctx *provider.Context) *openai.Completion
 		MaxTokens:   p.Config.ProviderMaxTokens,
 		TopK:        p.Config.ProviderTopK,
 		Stop:        []string{"\n"},
-		N:           1,
+		N:           p.Candidates(),
 		Echo:        false,
 	}
 }
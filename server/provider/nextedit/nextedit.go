@@ -0,0 +1,267 @@
+package nextedit
+
+import (
+	"cursortab/client/openai"
+	"cursortab/logger"
+	"cursortab/provider"
+	"cursortab/types"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// NewProvider creates a new next-edit provider for general instruction models.
+// The model is asked for a JSON list of line edits over the trimmed window
+// instead of a rewritten excerpt, so no fine-tuned next-edit model is needed.
+func NewProvider(config *types.ProviderConfig) *provider.Provider {
+	return &provider.Provider{
+		Name:          "nextedit",
+		Config:        config,
+		Client:        provider.NewClient(config),
+		StreamingType: provider.StreamingNone, // JSON is only usable once complete
+		Preprocessors: []provider.Preprocessor{
+			provider.TrimContent(),
+		},
+		DiffBuilder: provider.FormatDiffHistory(provider.DiffHistoryOptions{
+			HeaderTemplate: "User edited %q:\n",
+			Prefix:         "```diff\n",
+			Suffix:         "\n```",
+			Separator:      "\n\n",
+		}),
+		PromptBuilder: buildPrompt,
+		Postprocessors: []provider.Postprocessor{
+			provider.RejectEmpty(),
+			provider.RejectTruncated(),
+			stripCodeFence,
+			parseEdits,
+		},
+	}
+}
+
+// cursorMarker marks the cursor position in the numbered excerpt
+const cursorMarker = "<|cursor|>"
+
+const instructions = `You are a code editing assistant. Predict the next edits the user will make to the file excerpt below, based on their recent edits and the cursor position (marked ` + cursorMarker + `). Only suggest edits you are confident about.
+
+Respond with only a JSON object, no prose and no code fence, of the form:
+{"edits": [{"start_line": 12, "end_line": 13, "replacement": "new text\n"}]}
+
+- start_line and end_line are the numbers of the first and last excerpt lines the edit replaces, inclusive.
+- replacement is the new text for those lines, each line ending with a newline. Use "" to delete the lines.
+- To insert lines without replacing any, set end_line to start_line - 1; they are inserted before start_line.
+- Edits must not overlap. Return {"edits": []} if no edit is needed.`
+
+func buildPrompt(p *provider.Provider, ctx *provider.Context) *openai.CompletionRequest {
+	req := ctx.Request
+
+	var b strings.Builder
+	b.WriteString(instructions)
+	b.WriteString("\n\n")
+
+	if p.DiffBuilder != nil {
+		if userEdits := p.DiffBuilder(req.FileDiffHistories); userEdits != "" {
+			b.WriteString("### Recent edits:\n\n")
+			b.WriteString(userEdits)
+			b.WriteString("\n\n")
+		}
+	}
+
+	if diagnostics := formatDiagnostics(req); diagnostics != "" {
+		b.WriteString("### Diagnostics:\n\n")
+		b.WriteString(diagnostics)
+		b.WriteString("\n")
+	}
+
+	fmt.Fprintf(&b, "### Excerpt of %q (line number, then |, then the line):\n\n", req.FilePath)
+	b.WriteString(buildExcerpt(ctx))
+	b.WriteString("\n### Edits:\n")
+
+	return &openai.CompletionRequest{
+		Model:       p.Config.ProviderModel,
+		Prompt:      b.String(),
+		Temperature: p.Config.ProviderTemperature,
+		MaxTokens:   p.Config.ProviderMaxTokens,
+		TopK:        p.Config.ProviderTopK,
+		N:           p.Candidates(),
+		Echo:        false,
+	}
+}
+
+// buildExcerpt numbers the lines of the trimmed window with their buffer line
+// numbers and marks the cursor
+func buildExcerpt(ctx *provider.Context) string {
+	req := ctx.Request
+	cursorLine := req.CursorRow - 1
+
+	var b strings.Builder
+	for i := ctx.WindowStart; i < ctx.WindowEnd; i++ {
+		line := req.Lines[i]
+		if i == cursorLine {
+			col := min(max(req.CursorCol, 0), len(line))
+			line = line[:col] + cursorMarker + line[col:]
+		}
+		fmt.Fprintf(&b, "%d|%s\n", i+1, line)
+	}
+	return b.String()
+}
+
+func formatDiagnostics(req *types.CompletionRequest) string {
+	if req.LinterErrors == nil || len(req.LinterErrors.Errors) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	for _, err := range req.LinterErrors.Errors {
+		if err.Range != nil {
+			fmt.Fprintf(&b, "line %d: ", err.Range.StartLine)
+		}
+		fmt.Fprintf(&b, "[%s] %s\n", err.Severity, err.Message)
+	}
+	return b.String()
+}
+
+// stripCodeFence removes a markdown code fence around the JSON, which chat
+// models tend to add even when told not to
+func stripCodeFence(p *provider.Provider, ctx *provider.Context) (*types.CompletionResponse, bool) {
+	text := strings.TrimSpace(ctx.Result.Text)
+	if rest, ok := strings.CutPrefix(text, "```"); ok {
+		// Drop the info string (e.g. "json") along with the opening fence
+		if _, body, found := strings.Cut(rest, "\n"); found {
+			rest = body
+		}
+		text = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(rest), "```"))
+	}
+	ctx.Result.Text = text
+	return nil, false
+}
+
+// edit is a single line-range replacement returned by the model.
+// Lines are 1-indexed; end_line = start_line - 1 inserts before start_line.
+type edit struct {
+	StartLine   int    `json:"start_line"`
+	EndLine     int    `json:"end_line"`
+	Replacement string `json:"replacement"`
+}
+
+// lines returns the replacement split into lines, none for a deletion
+func (e edit) lines() []string {
+	if e.Replacement == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(e.Replacement, "\n"), "\n")
+}
+
+// decodeEdits accepts either {"edits": [...]} or a bare [...]
+func decodeEdits(text string) ([]edit, error) {
+	var wrapped struct {
+		Edits *[]edit `json:"edits"`
+	}
+	if strings.HasPrefix(text, "{") {
+		if err := json.Unmarshal([]byte(text), &wrapped); err != nil {
+			return nil, err
+		}
+		if wrapped.Edits == nil {
+			return nil, fmt.Errorf("missing \"edits\" field")
+		}
+		return *wrapped.Edits, nil
+	}
+
+	var edits []edit
+	if err := json.Unmarshal([]byte(text), &edits); err != nil {
+		return nil, err
+	}
+	return edits, nil
+}
+
+// validateEdits sorts edits by position and checks that each lies within the
+// window sent to the model and that none overlap
+func validateEdits(edits []edit, ctx *provider.Context) error {
+	sort.SliceStable(edits, func(i, j int) bool { return edits[i].StartLine < edits[j].StartLine })
+
+	prevEnd := ctx.WindowStart
+	for _, e := range edits {
+		switch {
+		case e.StartLine < ctx.WindowStart+1 || e.EndLine > ctx.WindowEnd:
+			return fmt.Errorf("edit %d-%d outside lines %d-%d", e.StartLine, e.EndLine, ctx.WindowStart+1, ctx.WindowEnd)
+		case e.EndLine < e.StartLine-1:
+			return fmt.Errorf("edit %d-%d has end before start", e.StartLine, e.EndLine)
+		case e.StartLine <= prevEnd:
+			return fmt.Errorf("edit %d-%d overlaps the edit before it", e.StartLine, e.EndLine)
+		}
+		prevEnd = e.EndLine
+	}
+	return nil
+}
+
+// parseEdits decodes and validates the model's edits and returns them as the
+// disjoint hunks of one edit. Edits that touch are merged into one hunk.
+// Malformed or invalid output is rejected.
+func parseEdits(p *provider.Provider, ctx *provider.Context) (*types.CompletionResponse, bool) {
+	edits, err := decodeEdits(ctx.Result.Text)
+	if err == nil {
+		err = validateEdits(edits, ctx)
+	}
+	if err != nil {
+		logger.Debug("%s: rejected, malformed edits: %v", p.Name, err)
+		return p.EmptyResponse(), true
+	}
+
+	var hunks []*types.Completion
+	for i := 0; i < len(edits); {
+		j := i + 1
+		for j < len(edits) && edits[j].StartLine <= edits[j-1].EndLine+1 {
+			j++
+		}
+		if hunk := buildHunk(ctx, edits[i:j]); hunk != nil {
+			hunks = append(hunks, hunk)
+		}
+		i = j
+	}
+
+	if len(hunks) == 0 {
+		return p.EmptyResponse(), true
+	}
+	return &types.CompletionResponse{
+		Completions: hunks,
+		Disjoint:    len(hunks) > 1,
+	}, true
+}
+
+// buildHunk applies a run of touching edits to the lines they cover.
+// Returns nil if the result changes nothing.
+func buildHunk(ctx *provider.Context, edits []edit) *types.Completion {
+	req := ctx.Request
+	startLine := edits[0].StartLine
+	next := startLine // Next buffer line not yet covered
+	var newLines []string
+	for _, e := range edits {
+		newLines = append(newLines, req.Lines[next-1:e.StartLine-1]...)
+		newLines = append(newLines, e.lines()...)
+		next = e.EndLine + 1
+	}
+	endLineInc := next - 1
+
+	// Pure insertions replace no line; widen them to a neighbouring line
+	if endLineInc < startLine {
+		switch {
+		case startLine <= ctx.WindowEnd:
+			newLines = append(newLines, req.Lines[startLine-1])
+			endLineInc = startLine
+		case startLine > 1:
+			startLine--
+			newLines = append([]string{req.Lines[startLine-1]}, newLines...)
+		default:
+			return nil
+		}
+	}
+
+	if provider.IsNoOpReplacement(newLines, req.Lines[startLine-1:endLineInc]) {
+		return nil
+	}
+	return &types.Completion{
+		StartLine:  startLine,
+		EndLineInc: endLineInc,
+		Lines:      newLines,
+	}
+}
@@ -0,0 +1,140 @@
+package nextedit
+
+import (
+	"cursortab/assert"
+	"cursortab/client/openai"
+	"cursortab/provider"
+	"cursortab/types"
+	"strings"
+	"testing"
+)
+
+func newTestContext(text string) *provider.Context {
+	return &provider.Context{
+		Request: &types.CompletionRequest{
+			FilePath:  "main.go",
+			Lines:     []string{"a", "b", "c", "d", "e"},
+			CursorRow: 2,
+			CursorCol: 1,
+		},
+		Result:      &openai.StreamResult{Text: text},
+		WindowStart: 1,
+		WindowEnd:   5,
+	}
+}
+
+// runPostprocessors runs the provider's postprocessors like Provider.postprocess
+func runPostprocessors(p *provider.Provider, ctx *provider.Context) *types.CompletionResponse {
+	for _, post := range p.Postprocessors {
+		if resp, done := post(p, ctx); done {
+			return resp
+		}
+	}
+	return p.EmptyResponse()
+}
+
+func TestBuildPrompt(t *testing.T) {
+	p := NewProvider(&types.ProviderConfig{ProviderModel: "test-model"})
+	ctx := newTestContext("")
+
+	req := buildPrompt(p, ctx)
+
+	assert.Equal(t, "test-model", req.Model, "model")
+	assert.True(t, strings.Contains(req.Prompt, `"main.go"`), "file path")
+	assert.True(t, strings.Contains(req.Prompt, "2|b<|cursor|>\n3|c\n"), "numbered lines with cursor")
+	assert.False(t, strings.Contains(req.Prompt, "1|a"), "lines outside the window left out")
+	assert.False(t, strings.Contains(req.Prompt, "### Diagnostics"), "no diagnostics section")
+}
+
+func TestParseEdits(t *testing.T) {
+	tests := []struct {
+		name      string
+		text      string
+		startLine int
+		endLine   int
+		lines     []string
+	}{
+		{
+			name:      "single replacement",
+			text:      `{"edits": [{"start_line": 3, "end_line": 3, "replacement": "C\n"}]}`,
+			startLine: 3, endLine: 3,
+			lines: []string{"C"},
+		},
+		{
+			name:      "touching edits merged",
+			text:      `{"edits": [{"start_line": 3, "end_line": 3, "replacement": "C\n"}, {"start_line": 2, "end_line": 2, "replacement": "B\n"}]}`,
+			startLine: 2, endLine: 3,
+			lines: []string{"B", "C"},
+		},
+		{
+			name:      "bare list in code fence",
+			text:      "```json\n[{\"start_line\": 4, \"end_line\": 4, \"replacement\": \"\"}]\n```",
+			startLine: 4, endLine: 4,
+		},
+		{
+			name:      "insertion widened to next line",
+			text:      `{"edits": [{"start_line": 3, "end_line": 2, "replacement": "x\ny\n"}]}`,
+			startLine: 3, endLine: 3,
+			lines: []string{"x", "y", "c"},
+		},
+		{
+			name:      "insertion at end widened to previous line",
+			text:      `{"edits": [{"start_line": 6, "end_line": 5, "replacement": "f\n"}]}`,
+			startLine: 5, endLine: 5,
+			lines: []string{"e", "f"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewProvider(&types.ProviderConfig{})
+			resp := runPostprocessors(p, newTestContext(tt.text))
+
+			assert.Len(t, 1, resp.Completions, "one completion")
+			completion := resp.Completions[0]
+			assert.Equal(t, tt.startLine, completion.StartLine, "start line")
+			assert.Equal(t, tt.endLine, completion.EndLineInc, "end line")
+			assert.Equal(t, tt.lines, completion.Lines, "lines")
+		})
+	}
+}
+
+func TestParseEdits_Disjoint(t *testing.T) {
+	p := NewProvider(&types.ProviderConfig{})
+	resp := runPostprocessors(p, newTestContext(`{"edits": [
+		{"start_line": 5, "end_line": 5, "replacement": "E\n"},
+		{"start_line": 4, "end_line": 4, "replacement": "d\n"},
+		{"start_line": 2, "end_line": 2, "replacement": "B\n"}
+	]}`))
+
+	assert.True(t, resp.Disjoint, "disjoint hunks")
+	assert.Len(t, 2, resp.Completions, "one hunk per run of touching edits")
+	assert.Equal(t, []string{"B"}, resp.Completions[0].Lines, "first hunk")
+	assert.Equal(t, 4, resp.Completions[1].StartLine, "second hunk start")
+	assert.Equal(t, []string{"d", "E"}, resp.Completions[1].Lines, "second hunk")
+}
+
+func TestParseEdits_Rejected(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+	}{
+		{"not json", "I would rename the variable."},
+		{"truncated json", `{"edits": [{"start_line": 3, "end_`},
+		{"missing edits field", `{"changes": []}`},
+		{"outside window", `{"edits": [{"start_line": 1, "end_line": 1, "replacement": "A\n"}]}`},
+		{"past window", `{"edits": [{"start_line": 5, "end_line": 6, "replacement": "E\n"}]}`},
+		{"end before start", `{"edits": [{"start_line": 4, "end_line": 2, "replacement": "x\n"}]}`},
+		{"overlapping", `{"edits": [{"start_line": 2, "end_line": 3, "replacement": "x\n"}, {"start_line": 3, "end_line": 3, "replacement": "y\n"}]}`},
+		{"no edits", `{"edits": []}`},
+		{"no change", `{"edits": [{"start_line": 2, "end_line": 2, "replacement": "b\n"}]}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewProvider(&types.ProviderConfig{})
+			resp := runPostprocessors(p, newTestContext(tt.text))
+			assert.Len(t, 0, resp.Completions, "no completion")
+		})
+	}
+}
@@ -168,6 +168,32 @@ func TrimContent() Preprocessor {
 	}
 }
 
+// SplitAtCursor returns the trimmed content before and after the cursor, for
+// fill-in-the-middle prompts
+func SplitAtCursor(ctx *Context) (prefix, suffix string) {
+	var prefixBuilder strings.Builder
+	var suffixBuilder strings.Builder
+
+	for i := range ctx.CursorLine {
+		prefixBuilder.WriteString(ctx.TrimmedLines[i])
+		prefixBuilder.WriteString("\n")
+	}
+
+	if ctx.CursorLine < len(ctx.TrimmedLines) {
+		currentLine := ctx.TrimmedLines[ctx.CursorLine]
+		cursorCol := min(ctx.Request.CursorCol, len(currentLine))
+		prefixBuilder.WriteString(currentLine[:cursorCol])
+		suffixBuilder.WriteString(currentLine[cursorCol:])
+	}
+
+	for i := ctx.CursorLine + 1; i < len(ctx.TrimmedLines); i++ {
+		suffixBuilder.WriteString("\n")
+		suffixBuilder.WriteString(ctx.TrimmedLines[i])
+	}
+
+	return prefixBuilder.String(), suffixBuilder.String()
+}
+
 // SkipIfTextAfterCursor returns a preprocessor that skips if there's text after cursor
 func SkipIfTextAfterCursor() Preprocessor {
 	return func(p *Provider, ctx *Context) error {
@@ -196,6 +222,26 @@ func RejectEmpty() Postprocessor {
 	}
 }
 
+// InsertAtCursor returns a postprocessor that inserts the completion text at
+// the cursor, for fill-in-the-middle models. Only the cursor line is replaced.
+func InsertAtCursor() Postprocessor {
+	return func(p *Provider, ctx *Context) (*types.CompletionResponse, bool) {
+		req := ctx.Request
+
+		currentLine := ""
+		if req.CursorRow >= 1 && req.CursorRow <= len(req.Lines) {
+			currentLine = req.Lines[req.CursorRow-1]
+		}
+		cursorCol := min(req.CursorCol, len(currentLine))
+
+		resultLines := strings.Split(ctx.Result.Text, "\n")
+		resultLines[0] = currentLine[:cursorCol] + resultLines[0]
+		resultLines[len(resultLines)-1] += currentLine[cursorCol:]
+
+		return p.BuildCompletion(ctx, req.CursorRow, req.CursorRow, resultLines)
+	}
+}
+
 // RejectTruncated returns a postprocessor that rejects truncated completions
 func RejectTruncated() Postprocessor {
 	return func(p *Provider, ctx *Context) (*types.CompletionResponse, bool) {
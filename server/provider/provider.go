@@ -8,6 +8,9 @@ import (
 	"cursortab/types"
 	"errors"
 	"fmt"
+	"sort"
+	"sync"
+	"time"
 )
 
 // StreamingType defines how completion content is streamed
@@ -26,6 +29,8 @@ const (
 var _ engine.Provider = (*Provider)(nil)
 var _ engine.LineStreamProvider = (*Provider)(nil)
 var _ engine.TokenStreamProvider = (*Provider)(nil)
+var _ engine.NamedProvider = (*Provider)(nil)
+var _ engine.CacheKeyProvider = (*Provider)(nil)
 
 // Client interface for API calls (enables mocking in tests)
 type Client interface {
@@ -34,6 +39,108 @@ type Client interface {
 	DoTokenStream(ctx context.Context, req *openai.CompletionRequest, maxChars int, stopTokens []string) *openai.LineStream
 }
 
+// NewClient returns the API client matching config.API.
+// Providers build requests in text-completion form regardless of the wire format.
+func NewClient(config *types.ProviderConfig) Client {
+	switch config.API {
+	case types.APITypeChat:
+		client := openai.NewChatClient(config.ProviderURL, config.CompletionPath)
+		configureClient(client.Client, config)
+		return client
+	case types.APITypeFIM:
+		client := openai.NewFIMClient(config.ProviderURL, config.CompletionPath)
+		configureClient(client.Client, config)
+		return client
+	case types.APITypeOllama:
+		client := openai.NewOllamaClient(config.ProviderURL, endpointPath(config, openai.DefaultOllamaPath))
+		client.KeepAlive = config.KeepAlive
+		configureClient(client.Client, config)
+		return client
+	}
+
+	client := openai.NewClient(config.ProviderURL, config.CompletionPath)
+	configureClient(client, config)
+	return client
+}
+
+// NewInfillClient returns a client for the llama.cpp server's /infill endpoint
+func NewInfillClient(config *types.ProviderConfig) *openai.InfillClient {
+	client := openai.NewInfillClient(config.ProviderURL, endpointPath(config, openai.DefaultInfillPath))
+	configureClient(client.Client, config)
+	return client
+}
+
+// endpointPath returns config.CompletionPath, or defaultPath in place of the
+// OpenAI default for clients of other APIs
+func endpointPath(config *types.ProviderConfig, defaultPath string) string {
+	if config.CompletionPath == openai.DefaultCompletionPath {
+		return defaultPath
+	}
+	return config.CompletionPath
+}
+
+// configureClient applies the auth and resilience settings of config
+func configureClient(client *openai.Client, config *types.ProviderConfig) {
+	client.Headers = config.Headers
+	client.APIKey = config.APIKey
+	client.Retry = openai.NewRetryPolicy(config.MaxRetries)
+	client.Breaker = openai.NewBreaker(config.BreakerFailures, config.BreakerCooldown)
+	client.ExtraBody = config.ExtraBody
+}
+
+// Breakers shares circuit breakers between the providers built from one
+// config: providers sending to the same endpoint with the same settings trip
+// together when their server is down. Each config gets its own set, so a
+// reload starts fresh breakers and drops the old ones with their providers.
+type Breakers struct {
+	mu       sync.Mutex
+	breakers map[breakerKey]*openai.Breaker
+}
+
+// breakerKey identifies the providers that share a breaker
+type breakerKey struct {
+	endpoint string
+	failures int
+	cooldown time.Duration
+}
+
+// NewBreakers creates an empty set of shared breakers
+func NewBreakers() *Breakers {
+	return &Breakers{breakers: make(map[breakerKey]*openai.Breaker)}
+}
+
+// ShareBreaker replaces the circuit breaker of p's client with the one in
+// breakers for its endpoint and settings. Clients without a breaker keep none.
+func (p *Provider) ShareBreaker(breakers *Breakers) {
+	c, ok := p.Client.(sharedBreakerClient)
+	if !ok || c.CircuitBreaker() == nil {
+		return
+	}
+
+	breakers.mu.Lock()
+	defer breakers.mu.Unlock()
+
+	key := breakerKey{endpoint: c.Endpoint(), failures: p.Config.BreakerFailures, cooldown: p.Config.BreakerCooldown}
+	breaker, ok := breakers.breakers[key]
+	if !ok {
+		breaker = c.CircuitBreaker()
+		breakers.breakers[key] = breaker
+	}
+	c.SetCircuitBreaker(breaker)
+}
+
+// breakerClient is implemented by clients that go through a circuit breaker
+type breakerClient interface {
+	CircuitBreaker() *openai.Breaker
+}
+
+// sharedBreakerClient is implemented by clients whose breaker can be shared
+type sharedBreakerClient interface {
+	breakerClient
+	SetCircuitBreaker(breaker *openai.Breaker)
+	Endpoint() string
+}
+
 // Validator validates streaming content (e.g., first line anchor validation)
 // Called after receiving the first line. Return error to cancel the stream.
 type Validator func(p *Provider, ctx *Context, firstLine string) error
@@ -52,6 +159,9 @@ type Context struct {
 	EndLineInc   int // 1-indexed inclusive end line, set by AnchorTruncation (0 = not set)
 	Result       *openai.StreamResult
 
+	// Next edit location named by the model (nil if none), see cursortarget.go
+	CursorTarget *types.CursorPredictionTarget
+
 	// Streaming state
 	CompletionRequest *openai.CompletionRequest // Built request for streaming
 }
@@ -80,45 +190,230 @@ type Provider struct {
 	Validators     []Validator        // Validators run on first line during streaming
 	StopTokens     []string           // Stop tokens for streaming (provider-specific)
 	DiffBuilder    DiffHistoryBuilder // Processes diff history for the prompt
+
+	mu       sync.Mutex
+	prepared *preparedRequest // Built by CacheKey for the request sent next
+}
+
+// preparedRequest is the outcome of prepare for a request, kept from CacheKey
+// so the request that follows does not run the pipeline again
+type preparedRequest struct {
+	req  *types.CompletionRequest
+	pctx *Context
+	err  error
 }
 
 // GetCompletion implements engine.Provider
 func (p *Provider) GetCompletion(ctx context.Context, req *types.CompletionRequest) (*types.CompletionResponse, error) {
 	defer logger.Trace("Provider.GetCompletion")()
+	pctx, err := p.prepareOnce(req)
+	if err != nil {
+		if errors.Is(err, ErrSkipCompletion) {
+			return p.EmptyResponse(), nil
+		}
+		return nil, err
+	}
+
+	p.logRequest(pctx.CompletionRequest, pctx.MaxLines)
+	return p.complete(ctx, pctx)
+}
+
+// CacheKey hashes the request this provider would send for req along with
+// its trimmed window. Returns "" if the request would be skipped.
+// Implements engine.CacheKeyProvider.
+func (p *Provider) CacheKey(req *types.CompletionRequest) string {
+	pctx, err := p.prepare(req)
+	p.mu.Lock()
+	p.prepared = &preparedRequest{req: req, pctx: pctx, err: err}
+	p.mu.Unlock()
+	if err != nil {
+		return ""
+	}
+
+	kind := callCompletion
+	switch p.StreamingType {
+	case StreamingLines:
+		kind = callLineStream
+	case StreamingTokens:
+		kind = callTokenStream
+	}
+	hash := requestHash(kind, pctx.CompletionRequest, pctx.MaxLines, p.StopTokens, true)
+	return fmt.Sprintf("%s:%d-%d:%s", p.Name, pctx.WindowStart, pctx.WindowEnd, hash)
+}
+
+// prepareOnce returns what CacheKey prepared for req, or prepares it if
+// CacheKey was not called for it. The kept result is used only once.
+func (p *Provider) prepareOnce(req *types.CompletionRequest) (*Context, error) {
+	p.mu.Lock()
+	prepared := p.prepared
+	if prepared != nil && prepared.req == req {
+		p.prepared = nil
+	}
+	p.mu.Unlock()
+
+	if prepared != nil && prepared.req == req {
+		return prepared.pctx, prepared.err
+	}
+	return p.prepare(req)
+}
+
+// prepare runs preprocessors and builds the request.
+// Returns ErrSkipCompletion unwrapped when a preprocessor skips the request.
+func (p *Provider) prepare(req *types.CompletionRequest) (*Context, error) {
 	pctx := &Context{Request: req}
 
 	for _, pre := range p.Preprocessors {
 		if err := pre(p, pctx); err != nil {
 			if errors.Is(err, ErrSkipCompletion) {
-				return p.EmptyResponse(), nil
+				return pctx, ErrSkipCompletion
 			}
 			return nil, fmt.Errorf("%s: %w", p.Name, err)
 		}
 	}
 
-	completionReq := p.PromptBuilder(p, pctx)
-	p.logRequest(completionReq, pctx.MaxLines)
+	pctx.CompletionRequest = p.PromptBuilder(p, pctx)
+	if p.Config != nil {
+		pctx.CompletionRequest.Sampling = openai.Sampling(p.Config.Sampling)
+	}
+	return pctx, nil
+}
 
-	resp, err := p.Client.DoCompletion(ctx, completionReq)
+// complete sends a prepared request in batch mode and runs postprocessors
+func (p *Provider) complete(ctx context.Context, pctx *Context) (*types.CompletionResponse, error) {
+	resp, err := p.Client.DoCompletion(ctx, pctx.CompletionRequest)
 	if err != nil {
 		return nil, fmt.Errorf("%s: %w", p.Name, err)
 	}
 
-	result := &openai.StreamResult{}
-	if len(resp.Choices) > 0 {
-		result.Text = resp.Choices[0].Text
-		result.FinishReason = resp.Choices[0].FinishReason
+	if len(resp.Choices) == 0 {
+		pctx.Result = &openai.StreamResult{}
+		p.logResponse(pctx.Result)
+		return p.postprocess(pctx), nil
 	}
-	pctx.Result = result
-	p.logResponse(result)
 
+	// Each choice runs through the postprocessors on its own copy of the context
+	base := *pctx
+	candidates := make([]*types.CompletionResponse, 0, len(resp.Choices))
+	for i, choice := range resp.Choices {
+		choiceCtx := pctx
+		if i > 0 {
+			copied := base
+			choiceCtx = &copied
+		}
+		choiceCtx.Result = &openai.StreamResult{
+			Text:         choice.Text,
+			FinishReason: choice.FinishReason,
+		}
+		p.logResponse(choiceCtx.Result)
+		candidates = append(candidates, p.postprocess(choiceCtx))
+	}
+
+	return rankCandidates(candidates), nil
+}
+
+// postprocess runs the postprocessors on pctx.Result. A cursor target named by
+// the model is attached to whatever they return.
+func (p *Provider) postprocess(pctx *Context) *types.CompletionResponse {
+	p.extractCursorTarget(pctx)
+
+	resp := p.EmptyResponse()
 	for _, post := range p.Postprocessors {
-		if resp, done := post(p, pctx); done {
-			return resp, nil
+		if r, done := post(p, pctx); done {
+			resp = r
+			break
 		}
 	}
+	if resp != nil && resp.CursorTarget == nil {
+		resp.CursorTarget = pctx.CursorTarget
+	}
+	return resp
+}
+
+// rankCandidates merges the responses to the choices of one request into a
+// single response. No-op completions were already dropped by BuildCompletion;
+// duplicates are merged, and candidates produced by more choices rank first,
+// ties keeping the order of the choices. The cursor target comes from the top
+// candidate, or from the first choice that named one if none changes anything.
+// A disjoint edit cannot be cycled with others, so when it ranks first it is
+// returned on its own, and otherwise it is left out.
+func rankCandidates(responses []*types.CompletionResponse) *types.CompletionResponse {
+	type candidate struct {
+		completions  []*types.Completion // Several hunks for a disjoint edit
+		disjoint     bool
+		cursorTarget *types.CursorPredictionTarget
+		votes        int
+	}
+
+	var candidates []*candidate
+	var fallbackTarget *types.CursorPredictionTarget
+	for _, resp := range responses {
+		if resp != nil && fallbackTarget == nil {
+			fallbackTarget = resp.CursorTarget
+		}
+		if resp == nil || len(resp.Completions) == 0 {
+			continue
+		}
+		completions := resp.Completions[:1]
+		if resp.Disjoint {
+			completions = resp.Completions
+		}
 
-	return p.EmptyResponse(), nil
+		var existing *candidate
+		for _, c := range candidates {
+			if c.disjoint == resp.Disjoint && sameCompletions(c.completions, completions) {
+				existing = c
+				break
+			}
+		}
+		if existing != nil {
+			existing.votes++
+			continue
+		}
+		candidates = append(candidates, &candidate{
+			completions:  completions,
+			disjoint:     resp.Disjoint,
+			cursorTarget: resp.CursorTarget,
+			votes:        1,
+		})
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].votes > candidates[j].votes
+	})
+
+	merged := &types.CompletionResponse{Completions: []*types.Completion{}}
+	if len(candidates) > 0 && candidates[0].disjoint {
+		merged.Completions = candidates[0].completions
+		merged.Disjoint = true
+		candidates = candidates[:1]
+	} else {
+		for _, c := range candidates {
+			if !c.disjoint {
+				merged.Completions = append(merged.Completions, c.completions[0])
+			}
+		}
+	}
+	if len(candidates) > 0 {
+		merged.CursorTarget = candidates[0].cursorTarget
+	} else {
+		merged.CursorTarget = fallbackTarget
+	}
+	return merged
+}
+
+// sameCompletions reports whether a and b replace the same ranges with the
+// same text
+func sameCompletions(a, b []*types.Completion) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].StartLine != b[i].StartLine || a[i].EndLineInc != b[i].EndLineInc ||
+			!IsNoOpReplacement(a[i].Lines, b[i].Lines) {
+			return false
+		}
+	}
+	return true
 }
 
 // EmptyResponse returns an empty completion response
@@ -171,6 +466,32 @@ func (p *Provider) logResponse(result *openai.StreamResult) {
 		result.Text)
 }
 
+// Candidates returns how many completions to request. Streaming providers
+// can only show one, so they always request one.
+func (p *Provider) Candidates() int {
+	if p.StreamingType != StreamingNone || p.Config == nil {
+		return 1
+	}
+	return max(1, p.Config.ProviderCandidates)
+}
+
+// ProviderName returns the provider type and model, e.g. "fim:codestral" (implements engine.NamedProvider)
+func (p *Provider) ProviderName() string {
+	if p.Config == nil || p.Config.ProviderModel == "" {
+		return p.Name
+	}
+	return p.Name + ":" + p.Config.ProviderModel
+}
+
+// CircuitBreaker returns the circuit breaker in front of the provider's
+// server, nil if it has none
+func (p *Provider) CircuitBreaker() *openai.Breaker {
+	if c, ok := p.Client.(breakerClient); ok {
+		return c.CircuitBreaker()
+	}
+	return nil
+}
+
 // GetStreamingType returns the streaming type for this provider (implements engine.LineStreamProvider)
 // Returns 0=none, 1=lines, 2=tokens to match engine.StreamingType* constants
 func (p *Provider) GetStreamingType() int {
@@ -181,23 +502,15 @@ func (p *Provider) GetStreamingType() int {
 // Returns (stream, providerContext, error). Implements engine.LineStreamProvider.
 func (p *Provider) PrepareLineStream(ctx context.Context, req *types.CompletionRequest) (engine.LineStream, any, error) {
 	defer logger.Trace("Provider.PrepareLineStream")()
-	pctx := &Context{Request: req}
-
-	for _, pre := range p.Preprocessors {
-		if err := pre(p, pctx); err != nil {
-			if errors.Is(err, ErrSkipCompletion) {
-				return nil, pctx, ErrSkipCompletion
-			}
-			return nil, nil, fmt.Errorf("%s: %w", p.Name, err)
-		}
+	pctx, err := p.prepareOnce(req)
+	if err != nil {
+		return nil, pctx, err
 	}
 
-	completionReq := p.PromptBuilder(p, pctx)
-	pctx.CompletionRequest = completionReq
-	p.logRequest(completionReq, pctx.MaxLines)
+	p.logRequest(pctx.CompletionRequest, pctx.MaxLines)
 
-	stream := p.Client.DoLineStream(ctx, completionReq, pctx.MaxLines, p.StopTokens)
-	return stream, pctx, nil
+	stream := p.Client.DoLineStream(ctx, pctx.CompletionRequest, pctx.MaxLines, p.StopTokens)
+	return p.filterCursorTarget(ctx, pctx, stream), pctx, nil
 }
 
 // ValidateFirstLine runs validators on the first received line (implements engine.LineStreamProvider)
@@ -230,36 +543,22 @@ func (p *Provider) FinishLineStream(providerCtx any, text string, finishReason s
 	}
 	p.logResponse(pctx.Result)
 
-	for _, post := range p.Postprocessors {
-		if resp, done := post(p, pctx); done {
-			return resp, nil
-		}
-	}
-
-	return p.EmptyResponse(), nil
+	return p.postprocess(pctx), nil
 }
 
 // PrepareTokenStream runs preprocessors, builds the prompt, and returns a token stream.
 // Returns (stream, providerContext, error). Implements engine.TokenStreamProvider.
 func (p *Provider) PrepareTokenStream(ctx context.Context, req *types.CompletionRequest) (engine.LineStream, any, error) {
 	defer logger.Trace("Provider.PrepareTokenStream")()
-	pctx := &Context{Request: req}
-
-	for _, pre := range p.Preprocessors {
-		if err := pre(p, pctx); err != nil {
-			if errors.Is(err, ErrSkipCompletion) {
-				return nil, pctx, ErrSkipCompletion
-			}
-			return nil, nil, fmt.Errorf("%s: %w", p.Name, err)
-		}
+	pctx, err := p.prepareOnce(req)
+	if err != nil {
+		return nil, pctx, err
 	}
 
-	completionReq := p.PromptBuilder(p, pctx)
-	pctx.CompletionRequest = completionReq
-	p.logRequest(completionReq, 0) // maxLines=0 for token streaming
+	p.logRequest(pctx.CompletionRequest, 0) // maxLines=0 for token streaming
 
 	// DoTokenStream uses StopTokens and no maxChars limit (0)
-	stream := p.Client.DoTokenStream(ctx, completionReq, 0, p.StopTokens)
+	stream := p.Client.DoTokenStream(ctx, pctx.CompletionRequest, 0, p.StopTokens)
 	return stream, pctx, nil
 }
 
@@ -277,11 +576,5 @@ func (p *Provider) FinishTokenStream(providerCtx any, text string) (*types.Compl
 	}
 	p.logResponse(pctx.Result)
 
-	for _, post := range p.Postprocessors {
-		if resp, done := post(p, pctx); done {
-			return resp, nil
-		}
-	}
-
-	return p.EmptyResponse(), nil
+	return p.postprocess(pctx), nil
 }
@@ -1,8 +1,16 @@
 package provider
 
 import (
+	"context"
 	"cursortab/assert"
+	"cursortab/client/openai"
+	"cursortab/types"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 )
 
 // TestContext_TrimmedContextInterface verifies that Context implements
@@ -34,3 +42,176 @@ func TestContext_EmptyTrimmedLines(t *testing.T) {
 	lines := ctx.GetTrimmedLines()
 	assert.Nil(t, lines, "GetTrimmedLines should be nil")
 }
+
+// TestNewClient_SelectsWireFormat verifies that config.API picks the client implementation.
+func TestNewClient_SelectsWireFormat(t *testing.T) {
+	completions := NewClient(&types.ProviderConfig{API: types.APITypeCompletions})
+	_, ok := completions.(*openai.Client)
+	assert.True(t, ok, "completions API should use openai.Client")
+
+	chat := NewClient(&types.ProviderConfig{API: types.APITypeChat})
+	_, ok = chat.(*openai.ChatClient)
+	assert.True(t, ok, "chat API should use openai.ChatClient")
+
+	fim := NewClient(&types.ProviderConfig{API: types.APITypeFIM})
+	_, ok = fim.(*openai.FIMClient)
+	assert.True(t, ok, "fim API should use openai.FIMClient")
+
+	ollama := NewClient(&types.ProviderConfig{API: types.APITypeOllama, CompletionPath: openai.DefaultCompletionPath})
+	ollamaClient, ok := ollama.(*openai.OllamaClient)
+	assert.True(t, ok, "ollama API should use openai.OllamaClient")
+	assert.Equal(t, openai.DefaultOllamaPath, ollamaClient.CompletionPath, "default path swapped for /api/generate")
+}
+
+func TestCandidates(t *testing.T) {
+	p := &Provider{StreamingType: StreamingLines, Config: &types.ProviderConfig{ProviderCandidates: 3}}
+	assert.Equal(t, 1, p.Candidates(), "streaming providers request one")
+
+	p.StreamingType = StreamingNone
+	assert.Equal(t, 3, p.Candidates(), "batch providers request the configured number")
+
+	p.Config.ProviderCandidates = 0
+	assert.Equal(t, 1, p.Candidates(), "unset requests one")
+}
+
+func TestGetCompletion_RanksCandidates(t *testing.T) {
+	var requested int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req openai.CompletionRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		requested = req.N
+
+		var choices []map[string]any
+		for i, text := range []string{"old", "alpha", "beta", "beta  ", "alpha", "beta"} {
+			choices = append(choices, map[string]any{"index": i, "text": text, "finish_reason": "stop"})
+		}
+		body, _ := json.Marshal(map[string]any{"choices": choices})
+		w.Write(body)
+	}))
+	t.Cleanup(server.Close)
+
+	p := newTestMember("test", server.URL, StreamingNone)
+	p.Config.ProviderCandidates = 6
+	p.PromptBuilder = func(p *Provider, ctx *Context) *openai.CompletionRequest {
+		return &openai.CompletionRequest{Prompt: "prompt", N: p.Candidates()}
+	}
+
+	resp, err := p.GetCompletion(context.Background(), &types.CompletionRequest{
+		Lines:     []string{"old"},
+		CursorRow: 1,
+	})
+
+	assert.NoError(t, err, "GetCompletion")
+	assert.Equal(t, 6, requested, "n sent to the server")
+	assert.Len(t, 2, resp.Completions, "no-op dropped and duplicates merged")
+	assert.Equal(t, []string{"beta"}, resp.Completions[0].Lines, "most frequent candidate first")
+	assert.Equal(t, []string{"alpha"}, resp.Completions[1].Lines, "second candidate")
+}
+
+func TestRankCandidates_Disjoint(t *testing.T) {
+	hunks := func(a, b string) *types.CompletionResponse {
+		return &types.CompletionResponse{
+			Completions: []*types.Completion{
+				{StartLine: 1, EndLineInc: 1, Lines: []string{a}},
+				{StartLine: 5, EndLineInc: 5, Lines: []string{b}},
+			},
+			Disjoint: true,
+		}
+	}
+	single := &types.CompletionResponse{Completions: []*types.Completion{{StartLine: 2, EndLineInc: 2, Lines: []string{"x"}}}}
+
+	resp := rankCandidates([]*types.CompletionResponse{hunks("a", "b"), single, hunks("a", "b")})
+	assert.True(t, resp.Disjoint, "top disjoint edit kept")
+	assert.Len(t, 2, resp.Completions, "all of its hunks, no other candidates")
+
+	resp = rankCandidates([]*types.CompletionResponse{single, hunks("a", "b"), single})
+	assert.False(t, resp.Disjoint, "candidates")
+	assert.Len(t, 1, resp.Completions, "disjoint edit left out of the candidates")
+}
+
+func TestCacheKey(t *testing.T) {
+	p := newTestMember("test", "", StreamingNone, TrimContent())
+	p.PromptBuilder = func(p *Provider, ctx *Context) *openai.CompletionRequest {
+		return &openai.CompletionRequest{Prompt: strings.Join(ctx.TrimmedLines, "\n")}
+	}
+	req := &types.CompletionRequest{Lines: []string{"a", "b"}, CursorRow: 1}
+
+	key := p.CacheKey(req)
+	assert.NotEqual(t, "", key, "key for a request that would be sent")
+	assert.Equal(t, key, p.CacheKey(&types.CompletionRequest{Lines: []string{"a", "b"}, CursorRow: 1}), "same prompt and window")
+	assert.NotEqual(t, key, p.CacheKey(&types.CompletionRequest{Lines: []string{"a", "c"}, CursorRow: 1}), "different prompt")
+
+	p.Preprocessors = append(p.Preprocessors, skipAll())
+	assert.Equal(t, "", p.CacheKey(req), "skipped request")
+
+	chain := NewChain([]*Provider{p, newTestMember("fallback", "", StreamingNone)}, 0)
+	assert.NotEqual(t, "", chain.CacheKey(req), "chain uses the member that would send the request")
+}
+
+func TestPrepare_AppliesSampling(t *testing.T) {
+	p := newTestMember("test", "", StreamingNone)
+	penalty := 1.1
+	p.Config.Sampling = types.SamplingConfig{RepetitionPenalty: &penalty, Grammar: "root ::= \"a\""}
+
+	pctx, err := p.prepare(&types.CompletionRequest{Lines: []string{"a"}})
+
+	assert.NoError(t, err, "prepare")
+	assert.Equal(t, &penalty, pctx.CompletionRequest.RepetitionPenalty, "repetition penalty")
+	assert.Equal(t, "root ::= \"a\"", pctx.CompletionRequest.Grammar, "grammar")
+}
+
+func TestCacheKey_PreparesOnce(t *testing.T) {
+	server, _ := newTestServer(t, "done")
+	runs := 0
+	count := func(p *Provider, ctx *Context) error {
+		runs++
+		return nil
+	}
+	p := newTestMember("test", server.URL, StreamingNone, count)
+	req := &types.CompletionRequest{Lines: []string{"a"}, CursorRow: 1}
+
+	assert.NotEqual(t, "", p.CacheKey(req), "key")
+	_, err := p.GetCompletion(context.Background(), req)
+	assert.NoError(t, err, "GetCompletion")
+	assert.Equal(t, 1, runs, "pipeline run once for the key and the request")
+
+	_, err = p.GetCompletion(context.Background(), req)
+	assert.NoError(t, err, "GetCompletion")
+	assert.Equal(t, 2, runs, "prepared request used only once")
+
+	chain := NewChain([]*Provider{newTestMember("skip", "", StreamingNone, skipAll()), p}, 0)
+	req = &types.CompletionRequest{Lines: []string{"a"}, CursorRow: 1}
+	assert.NotEqual(t, "", chain.CacheKey(req), "chain key")
+	_, err = chain.GetCompletion(context.Background(), req)
+	assert.NoError(t, err, "chain GetCompletion")
+	assert.Equal(t, 3, runs, "chain member prepared once")
+}
+
+func TestShareBreaker(t *testing.T) {
+	config := types.ProviderConfig{
+		ProviderURL:     "http://gpu-box:8000",
+		CompletionPath:  "/v1/completions",
+		BreakerFailures: 3,
+		BreakerCooldown: time.Second,
+	}
+	newProvider := func(config types.ProviderConfig, breakers *Breakers) *openai.Breaker {
+		p := &Provider{Config: &config, Client: NewClient(&config)}
+		p.ShareBreaker(breakers)
+		return p.CircuitBreaker()
+	}
+
+	breakers := NewBreakers()
+	main := newProvider(config, breakers)
+	assert.NotNil(t, main, "breaker")
+	assert.True(t, main == newProvider(config, breakers), "same server shares a breaker")
+
+	other := config
+	other.ProviderURL = "http://other-box:8000"
+	assert.True(t, main != newProvider(other, breakers), "other server has its own")
+
+	assert.True(t, main != newProvider(config, NewBreakers()), "reloaded config starts a fresh breaker")
+
+	off := config
+	off.BreakerFailures = 0
+	assert.Nil(t, newProvider(off, breakers), "breaker turned off")
+}
@@ -0,0 +1,372 @@
+package provider
+
+import (
+	"context"
+	"crypto/sha256"
+	"cursortab/client/openai"
+	"cursortab/logger"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+)
+
+// detachGrace is how long an upstream call keeps running after its last
+// caller went away, so the request the next keystroke triggers can still
+// attach to it
+const detachGrace = 250 * time.Millisecond
+
+// callKind tells apart the ways a request can be sent upstream
+type callKind string
+
+const (
+	callCompletion  callKind = "completion"
+	callLineStream  callKind = "lines"
+	callTokenStream callKind = "tokens"
+)
+
+// Scheduler implements Client in front of another client. Requests with the
+// same content hash share one upstream call; callers attaching late are
+// replayed what it has produced so far. At most maxConcurrent upstream calls
+// run at once, and calls nobody waits on give up their slot to queued ones.
+//
+// A token stream request whose prompt extends an in-flight call's prompt
+// with text typed since also attaches to it, as long as the call's output
+// starts with that text, and gets the output with the typed text cut off.
+type Scheduler struct {
+	client      Client
+	slots       chan struct{} // One per running upstream call (nil = no limit)
+	detachGrace time.Duration
+
+	mu    sync.Mutex
+	calls map[string]*call // In-flight calls by request hash
+}
+
+// NewScheduler wraps client; maxConcurrent <= 0 means no limit
+func NewScheduler(client Client, maxConcurrent int) *Scheduler {
+	s := &Scheduler{
+		client:      client,
+		detachGrace: detachGrace,
+		calls:       make(map[string]*call),
+	}
+	if maxConcurrent > 0 {
+		s.slots = make(chan struct{}, maxConcurrent)
+	}
+	return s
+}
+
+// call is one upstream request and the output it has produced so far.
+// Fields below cancel are guarded by Scheduler.mu.
+type call struct {
+	key    string // Hash of the whole request
+	params string // Hash of the request without its prompt
+	prompt string
+	cancel context.CancelFunc
+
+	callers int
+	outputs []string // Lines, or cumulative texts for token streams
+	resp    *openai.CompletionResponse
+	err     error
+	result  openai.StreamResult
+	done    bool
+	updated chan struct{} // Closed and replaced whenever the call makes progress
+}
+
+// CircuitBreaker returns the wrapped client's circuit breaker, nil if it has none
+func (s *Scheduler) CircuitBreaker() *openai.Breaker {
+	if c, ok := s.client.(breakerClient); ok {
+		return c.CircuitBreaker()
+	}
+	return nil
+}
+
+// requestHash hashes everything about a request that shapes its output,
+// leaving the prompt out unless withPrompt is set
+func requestHash(kind callKind, req *openai.CompletionRequest, limit int, stopTokens []string, withPrompt bool) string {
+	body := *req
+	body.Stream = false
+	if !withPrompt {
+		body.Prompt = ""
+	}
+	data, _ := json.Marshal(struct {
+		Kind       callKind
+		Request    openai.CompletionRequest
+		Limit      int
+		StopTokens []string
+	}{kind, body, limit, stopTokens})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// DoCompletion sends a batch request, sharing the response with identical
+// requests in flight (implements Client)
+func (s *Scheduler) DoCompletion(ctx context.Context, req *openai.CompletionRequest) (*openai.CompletionResponse, error) {
+	c, _ := s.attach(callCompletion, req, 0, nil, false, func(ctx context.Context, c *call) {
+		resp, err := s.client.DoCompletion(ctx, req)
+		s.update(c, func() { c.resp, c.err = resp, err })
+	})
+	defer s.detach(c)
+
+	for {
+		s.mu.Lock()
+		done, resp, err, updated := c.done, c.resp, c.err, c.updated
+		s.mu.Unlock()
+		if done {
+			return resp, err
+		}
+		select {
+		case <-updated:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// DoLineStream streams a request's lines, sharing the upstream stream with
+// identical requests in flight (implements Client)
+func (s *Scheduler) DoLineStream(ctx context.Context, req *openai.CompletionRequest, maxLines int, stopTokens []string) *openai.LineStream {
+	c, _ := s.attach(callLineStream, req, maxLines, stopTokens, false, func(ctx context.Context, c *call) {
+		s.relay(c, s.client.DoLineStream(ctx, req, maxLines, stopTokens))
+	})
+	return s.follow(ctx, c, "", nil)
+}
+
+// DoTokenStream streams a request's cumulative text, sharing the upstream
+// stream with identical requests in flight or with one whose prompt the
+// request's prompt extends (implements Client)
+func (s *Scheduler) DoTokenStream(ctx context.Context, req *openai.CompletionRequest, maxChars int, stopTokens []string) *openai.LineStream {
+	start := func(ctx context.Context, c *call) {
+		s.relay(c, s.client.DoTokenStream(ctx, req, maxChars, stopTokens))
+	}
+	c, skip := s.attach(callTokenStream, req, maxChars, stopTokens, true, start)
+	return s.follow(ctx, c, skip, func() *call {
+		c, _ := s.attach(callTokenStream, req, maxChars, stopTokens, false, start)
+		return c
+	})
+}
+
+// attach joins the in-flight call for a request or starts one. With extend
+// set, a call whose prompt the request's prompt extends is joined too, and
+// the text the request's prompt adds is returned as skip.
+func (s *Scheduler) attach(kind callKind, req *openai.CompletionRequest, limit int, stopTokens []string, extend bool, start func(context.Context, *call)) (c *call, skip string) {
+	key := requestHash(kind, req, limit, stopTokens, true)
+	params := requestHash(kind, req, limit, stopTokens, false)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if c := s.calls[key]; c != nil {
+		c.callers++
+		logger.Debug("scheduler: joined in-flight %s request", kind)
+		return c, ""
+	}
+
+	if extend {
+		var best *call
+		for _, c := range s.calls {
+			if c.params == params && len(req.Prompt) > len(c.prompt) && strings.HasPrefix(req.Prompt, c.prompt) &&
+				(best == nil || len(c.prompt) > len(best.prompt)) {
+				best = c
+			}
+		}
+		if best != nil {
+			best.callers++
+			skip = req.Prompt[len(best.prompt):]
+			logger.Debug("scheduler: joined in-flight %s request, %d chars typed since", kind, len(skip))
+			return best, skip
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c = &call{
+		key:     key,
+		params:  params,
+		prompt:  req.Prompt,
+		cancel:  cancel,
+		callers: 1,
+		updated: make(chan struct{}),
+	}
+	s.calls[key] = c
+	go s.run(ctx, c, start)
+	return c, ""
+}
+
+// run makes a call's upstream request once a slot is free
+func (s *Scheduler) run(ctx context.Context, c *call, start func(context.Context, *call)) {
+	defer s.finish(c)
+	defer c.cancel()
+
+	if !s.acquire(ctx) {
+		s.update(c, func() {
+			c.err = ctx.Err()
+			c.result = openai.StreamResult{FinishReason: "cancelled"}
+		})
+		return
+	}
+	defer s.release()
+
+	start(ctx, c)
+}
+
+// acquire waits for a free upstream slot. Returns false if ctx ends first.
+func (s *Scheduler) acquire(ctx context.Context) bool {
+	if s.slots == nil {
+		return true
+	}
+	select {
+	case s.slots <- struct{}{}:
+		return true
+	default:
+	}
+
+	// Calls kept alive only in case a caller attaches give up their slot
+	s.mu.Lock()
+	for _, c := range s.calls {
+		if c.callers == 0 {
+			s.drop(c)
+		}
+	}
+	s.mu.Unlock()
+
+	select {
+	case s.slots <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (s *Scheduler) release() {
+	if s.slots != nil {
+		<-s.slots
+	}
+}
+
+// relay records an upstream stream's output on its call
+func (s *Scheduler) relay(c *call, stream *openai.LineStream) {
+	for output := range stream.LinesChan() {
+		s.update(c, func() { c.outputs = append(c.outputs, output) })
+	}
+	result := <-stream.DoneChan()
+	s.update(c, func() { c.result = result })
+}
+
+// update changes a call under the lock and wakes its callers
+func (s *Scheduler) update(c *call, change func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	change()
+	close(c.updated)
+	c.updated = make(chan struct{})
+}
+
+// finish marks a call done, so no caller attaches to it any more
+func (s *Scheduler) finish(c *call) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c.done = true
+	if s.calls[c.key] == c {
+		delete(s.calls, c.key)
+	}
+	close(c.updated)
+}
+
+// detach drops a caller from a call. A call left without callers is
+// cancelled after the grace period unless another caller attaches first.
+func (s *Scheduler) detach(c *call) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c.callers--
+	if c.callers > 0 || c.done {
+		return
+	}
+	time.AfterFunc(s.detachGrace, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if c.callers == 0 {
+			s.drop(c)
+		}
+	})
+}
+
+// drop cancels a call and forgets it. Must be called with s.mu held.
+func (s *Scheduler) drop(c *call) {
+	c.cancel()
+	if s.calls[c.key] == c {
+		delete(s.calls, c.key)
+	}
+}
+
+// follow streams a call's output to a caller, starting with what the call
+// produced before the caller attached. skip is text typed since the call's
+// prompt, cut off the front of token stream output; if the output does not
+// start with it, the caller moves to the call restart returns.
+func (s *Scheduler) follow(ctx context.Context, c *call, skip string, restart func() *call) *openai.LineStream {
+	ctx, cancel := context.WithCancel(ctx)
+	lines := make(chan string, 100)
+	done := make(chan openai.StreamResult, 1)
+
+	go func() {
+		defer close(lines)
+		defer close(done)
+		defer func() { s.detach(c) }()
+
+		sent := 0
+		matched := skip == ""
+		for {
+			s.mu.Lock()
+			outputs, finished, result, updated := c.outputs[sent:], c.done, c.result, c.updated
+			s.mu.Unlock()
+
+			diverged := false
+			for _, output := range outputs {
+				sent++
+				if !matched {
+					if !strings.HasPrefix(output, skip) {
+						// Output shorter than the typed text may still reach it
+						diverged = !strings.HasPrefix(skip, output)
+						if diverged {
+							break
+						}
+						continue
+					}
+					matched = true
+				}
+				output = strings.TrimPrefix(output, skip)
+				if output == "" {
+					continue
+				}
+				select {
+				case lines <- output:
+				case <-ctx.Done():
+					done <- openai.StreamResult{FinishReason: "cancelled"}
+					return
+				}
+			}
+
+			if diverged || (finished && !matched) {
+				logger.Debug("scheduler: in-flight output does not continue the typed text, requesting anew")
+				s.detach(c)
+				c = restart()
+				skip, sent, matched = "", 0, true
+				continue
+			}
+
+			if finished {
+				result.Text = strings.TrimPrefix(result.Text, skip)
+				done <- result
+				return
+			}
+
+			select {
+			case <-updated:
+			case <-ctx.Done():
+				done <- openai.StreamResult{FinishReason: "cancelled"}
+				return
+			}
+		}
+	}()
+
+	return openai.NewLineStream(lines, done, cancel)
+}
@@ -0,0 +1,261 @@
+package provider
+
+import (
+	"context"
+	"cursortab/assert"
+	"cursortab/client/openai"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClient serves every request from outputs fed by the test. Upstream
+// calls block until release is closed.
+type fakeClient struct {
+	mu      sync.Mutex
+	calls   int
+	running int
+	maxRun  int
+	prompts []string
+	release chan struct{}
+	outputs map[string][]string // Stream outputs by prompt
+}
+
+func newFakeClient() *fakeClient {
+	return &fakeClient{release: make(chan struct{}), outputs: make(map[string][]string)}
+}
+
+func (c *fakeClient) begin(req *openai.CompletionRequest) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls++
+	c.running++
+	c.maxRun = max(c.maxRun, c.running)
+	c.prompts = append(c.prompts, req.Prompt)
+}
+
+func (c *fakeClient) end() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.running--
+}
+
+func (c *fakeClient) callCount() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.calls
+}
+
+func (c *fakeClient) DoCompletion(ctx context.Context, req *openai.CompletionRequest) (*openai.CompletionResponse, error) {
+	c.begin(req)
+	defer c.end()
+	select {
+	case <-c.release:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	resp := &openai.CompletionResponse{Model: req.Prompt}
+	return resp, nil
+}
+
+func (c *fakeClient) stream(ctx context.Context, req *openai.CompletionRequest) *openai.LineStream {
+	c.begin(req)
+	lines := make(chan string, 100)
+	done := make(chan openai.StreamResult, 1)
+	go func() {
+		defer close(lines)
+		defer close(done)
+		defer c.end()
+		outputs := c.outputs[req.Prompt]
+		for i, output := range outputs {
+			if i == len(outputs)-1 {
+				// The last output waits for the test
+				select {
+				case <-c.release:
+				case <-ctx.Done():
+					done <- openai.StreamResult{FinishReason: "cancelled"}
+					return
+				}
+			}
+			lines <- output
+		}
+		text := ""
+		if len(outputs) > 0 {
+			text = outputs[len(outputs)-1]
+		}
+		done <- openai.StreamResult{Text: text, FinishReason: "stop"}
+	}()
+	return openai.NewLineStream(lines, done, func() {})
+}
+
+func (c *fakeClient) DoLineStream(ctx context.Context, req *openai.CompletionRequest, maxLines int, stopTokens []string) *openai.LineStream {
+	return c.stream(ctx, req)
+}
+
+func (c *fakeClient) DoTokenStream(ctx context.Context, req *openai.CompletionRequest, maxChars int, stopTokens []string) *openai.LineStream {
+	return c.stream(ctx, req)
+}
+
+// collectResult drains a stream and returns its lines and final result
+func collectResult(t *testing.T, stream *openai.LineStream) ([]string, openai.StreamResult) {
+	t.Helper()
+	var lines []string
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case line, ok := <-stream.LinesChan():
+			if !ok {
+				return lines, <-stream.DoneChan()
+			}
+			lines = append(lines, line)
+		case <-timeout:
+			t.Fatal("timed out reading stream")
+		}
+	}
+}
+
+// waitFor polls until cond holds
+func waitFor(t *testing.T, cond func() bool, label string) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", label)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestScheduler_DedupesCompletions(t *testing.T) {
+	client := newFakeClient()
+	s := NewScheduler(client, 0)
+
+	var wg sync.WaitGroup
+	results := make([]*openai.CompletionResponse, 2)
+	for i := range results {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], _ = s.DoCompletion(context.Background(), &openai.CompletionRequest{Prompt: "p"})
+		}()
+	}
+	waitFor(t, func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for _, c := range s.calls {
+			return c.callers == 2
+		}
+		return false
+	}, "both callers")
+	close(client.release)
+	wg.Wait()
+
+	assert.Equal(t, 1, client.callCount(), "one upstream call")
+	assert.NotNil(t, results[0], "first result")
+	assert.True(t, results[0] == results[1], "response shared")
+}
+
+func TestScheduler_LateCallerReplayed(t *testing.T) {
+	client := newFakeClient()
+	client.outputs["p"] = []string{"a", "b", "c"}
+	s := NewScheduler(client, 0)
+
+	first := s.DoLineStream(context.Background(), &openai.CompletionRequest{Prompt: "p"}, 0, nil)
+	assert.Equal(t, "a", <-first.LinesChan(), "first line")
+	second := s.DoLineStream(context.Background(), &openai.CompletionRequest{Prompt: "p"}, 0, nil)
+	close(client.release)
+
+	firstLines, _ := collectResult(t, first)
+	secondLines, result := collectResult(t, second)
+	assert.Equal(t, []string{"b", "c"}, firstLines, "rest of first stream")
+	assert.Equal(t, []string{"a", "b", "c"}, secondLines, "second stream replayed from the start")
+	assert.Equal(t, "stop", result.FinishReason, "finish reason")
+	assert.Equal(t, 1, client.callCount(), "one upstream call")
+}
+
+func TestScheduler_DifferentRequestsNotShared(t *testing.T) {
+	client := newFakeClient()
+	close(client.release)
+	s := NewScheduler(client, 0)
+
+	s.DoCompletion(context.Background(), &openai.CompletionRequest{Prompt: "p", Temperature: 0})
+	s.DoCompletion(context.Background(), &openai.CompletionRequest{Prompt: "p", Temperature: 1})
+	s.DoCompletion(context.Background(), &openai.CompletionRequest{Prompt: "p", Temperature: 1})
+
+	assert.Equal(t, 3, client.callCount(), "finished calls are not reused")
+}
+
+func TestScheduler_TokenStreamExtendsPrompt(t *testing.T) {
+	client := newFakeClient()
+	client.outputs["fo"] = []string{"o", "o(x", "o(x)"}
+	s := NewScheduler(client, 0)
+
+	first := s.DoTokenStream(context.Background(), &openai.CompletionRequest{Prompt: "fo"}, 0, nil)
+	assert.Equal(t, "o", <-first.LinesChan(), "first output")
+	// The user typed "o(" after the first request went out
+	second := s.DoTokenStream(context.Background(), &openai.CompletionRequest{Prompt: "foo("}, 0, nil)
+	close(client.release)
+
+	lines, result := collectResult(t, second)
+	collectResult(t, first)
+	assert.Equal(t, []string{"x", "x)"}, lines, "output after the typed text")
+	assert.Equal(t, "x)", result.Text, "result text")
+	assert.Equal(t, 1, client.callCount(), "one upstream call")
+}
+
+func TestScheduler_TokenStreamDivergesRequestsAnew(t *testing.T) {
+	client := newFakeClient()
+	client.outputs["fo"] = []string{"o", "o(x)"}
+	client.outputs["fox"] = []string{"y"}
+	s := NewScheduler(client, 0)
+
+	first := s.DoTokenStream(context.Background(), &openai.CompletionRequest{Prompt: "fo"}, 0, nil)
+	assert.Equal(t, "o", <-first.LinesChan(), "first output")
+	second := s.DoTokenStream(context.Background(), &openai.CompletionRequest{Prompt: "fox"}, 0, nil)
+	close(client.release)
+
+	lines, _ := collectResult(t, second)
+	assert.Equal(t, []string{"y"}, lines, "own request's output")
+	assert.Equal(t, []string{"fo", "fox"}, client.prompts, "upstream prompts")
+}
+
+func TestScheduler_LimitsConcurrency(t *testing.T) {
+	client := newFakeClient()
+	s := NewScheduler(client, 1)
+
+	var wg sync.WaitGroup
+	for _, prompt := range []string{"a", "b", "c"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.DoCompletion(context.Background(), &openai.CompletionRequest{Prompt: prompt})
+		}()
+	}
+	waitFor(t, func() bool { return client.callCount() == 1 }, "first call")
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, 1, client.callCount(), "others queued")
+
+	close(client.release)
+	wg.Wait()
+	assert.Equal(t, 3, client.callCount(), "all calls made")
+	assert.Equal(t, 1, client.maxRun, "one call at a time")
+}
+
+func TestScheduler_AbandonedCallCancelled(t *testing.T) {
+	client := newFakeClient()
+	client.outputs["p"] = []string{"a", "b"}
+	s := NewScheduler(client, 0)
+	s.detachGrace = 0
+
+	stream := s.DoLineStream(context.Background(), &openai.CompletionRequest{Prompt: "p"}, 0, nil)
+	assert.Equal(t, "a", <-stream.LinesChan(), "first line")
+	stream.Cancel()
+
+	waitFor(t, func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return len(s.calls) == 0
+	}, "call dropped")
+	_, result := collectResult(t, stream)
+	assert.Equal(t, "cancelled", result.FinishReason, "caller result")
+}
@@ -12,7 +12,7 @@ func NewProvider(config *types.ProviderConfig) *provider.Provider {
 	return &provider.Provider{
 		Name:          "sweep",
 		Config:        config,
-		Client:        openai.NewClient(config.ProviderURL, config.CompletionPath),
+		Client:        provider.NewClient(config),
 		StreamingType: provider.StreamingLines,
 		Preprocessors: []provider.Preprocessor{
 			provider.TrimContent(),
@@ -54,7 +54,7 @@ func buildPrompt(p *provider.Provider, ctx *provider.Context) *openai.Completion
 			MaxTokens:   p.Config.ProviderMaxTokens,
 			TopK:        p.Config.ProviderTopK,
 			Stop:        []string{"<|file_sep|>", "</s>"},
-			N:           1,
+			N:           p.Candidates(),
 			Echo:        false,
 		}
 	}
@@ -92,7 +92,7 @@ func buildPrompt(p *provider.Provider, ctx *provider.Context) *openai.Completion
 		MaxTokens:   p.Config.ProviderMaxTokens,
 		TopK:        p.Config.ProviderTopK,
 		Stop:        []string{"<|file_sep|>", "</s>"},
-		N:           1,
+		N:           p.Candidates(),
 		Echo:        false,
 	}
 }
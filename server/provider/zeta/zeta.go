@@ -13,7 +13,7 @@ func NewProvider(config *types.ProviderConfig) *provider.Provider {
 	return &provider.Provider{
 		Name:          "zeta",
 		Config:        config,
-		Client:        openai.NewClient(config.ProviderURL, config.CompletionPath),
+		Client:        provider.NewClient(config),
 		StreamingType: provider.StreamingLines,
 		Preprocessors: []provider.Preprocessor{
 			provider.TrimContent(),
@@ -56,7 +56,7 @@ func buildPrompt(p *provider.Provider, ctx *provider.Context) *openai.Completion
 		MaxTokens:   p.Config.ProviderMaxTokens,
 		TopK:        p.Config.ProviderTopK,
 		Stop:        []string{"\n<|editable_region_end|>"},
-		N:           1,
+		N:           p.Candidates(),
 		Echo:        false,
 	}
 }
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+
+	"cursortab/buffer"
+	"cursortab/engine"
+	"cursortab/logger"
+
+	"github.com/neovim/go-client/nvim"
+)
+
+// session holds the state of one connected Neovim instance. Each session has
+// its own buffer, engine state, file state store and workspace; the provider
+// (and its HTTP client) is shared by all sessions of the daemon.
+type session struct {
+	id     int64
+	buffer *buffer.NvimBuffer
+	engine *engine.Engine
+}
+
+// newSession creates the buffer and engine for a connection. The engine is
+// not started yet. The buffer starts out in the namespace of the daemon's
+// config until the client's handshake names its own (see setNamespace).
+func newSession(id int64, n *nvim.Nvim, prov engine.Provider, config Config, engineConfig engine.EngineConfig) (*session, error) {
+	buf := buffer.New(buffer.Config{
+		NsID: config.NsID,
+	})
+	buf.SetClient(n)
+
+	eng, err := engine.NewEngine(prov, buf, engineConfig, engine.SystemClock)
+	if err != nil {
+		return nil, err
+	}
+
+	return &session{
+		id:     id,
+		buffer: buf,
+		engine: eng,
+	}, nil
+}
+
+// setNamespace switches the session's buffer to the extmark namespace of its
+// client. Namespace ids are allocated per Neovim instance, so each client
+// sends its own.
+func (s *session) setNamespace(nsID int) {
+	s.buffer.SetNamespace(nsID)
+	logger.Debug("session %d: namespace %d", s.id, nsID)
+}
+
+// detectWorkspace asks the client for its working directory and uses it as
+// the session's fallback workspace, for buffers the client reports no root
+// for. Must be called while the connection is served.
+func (s *session) detectWorkspace(n *nvim.Nvim) {
+	var cwd string
+	var pid int
+	b := n.NewBatch()
+	b.Call("getcwd", &cwd)
+	b.Call("getpid", &pid)
+	if err := b.Execute(); err != nil {
+		logger.Warn("session %d: could not get client workspace: %v", s.id, err)
+		return
+	}
+
+	s.engine.SetWorkspace(cwd, fmt.Sprintf("%s-%d", cwd, pid))
+	logger.Info("session %d: workspace %s", s.id, cwd)
+}
@@ -407,6 +407,11 @@ func (b *IncrementalStageBuilder) computeCurrentBufferLine(lineNum int) int {
 	return lineNum + b.BaseLineOffset - 1
 }
 
+// Stages returns the stages finalized so far, in stream order
+func (b *IncrementalStageBuilder) Stages() []*Stage {
+	return b.finalizedStages
+}
+
 // Finalize completes the build and returns all stages sorted by cursor distance.
 // Call this when the stream completes.
 func (b *IncrementalStageBuilder) Finalize() *StagingResult {
@@ -0,0 +1,168 @@
+package text
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// AcceptUnit is how much of a completion a partial accept applies
+type AcceptUnit int
+
+const (
+	AcceptWord AcceptUnit = iota
+	AcceptLine
+)
+
+// TypedPrefix reports whether lines are what typing the start of target
+// gives: no more lines than target, each a prefix of the target line at the
+// same index. If so, it also returns where the rest of target continues (the
+// 0-indexed line and byte column of the first missing text), which is
+// (len(lines), 0) when only whole target lines are missing and
+// (len(target), 0) when nothing is.
+func TypedPrefix(lines, target []string) (int, int, bool) {
+	if len(lines) > len(target) {
+		return 0, 0, false
+	}
+	for i, line := range lines {
+		if !strings.HasPrefix(target[i], line) {
+			return 0, 0, false
+		}
+	}
+	for i, line := range lines {
+		if line != target[i] {
+			return i, len(line), true
+		}
+	}
+	return len(lines), 0, true
+}
+
+// PartialAccept applies the first change from oldLines to newLines up to the
+// end of its next word or line and returns the resulting lines. The returned
+// position is right after the accepted text (1-indexed line relative to the
+// result, 0-indexed byte column). When oldLines are a TypedPrefix of
+// newLines, the completion continues where typing it would. Otherwise text
+// replaced by the change is only dropped on the lines the accepted text
+// reaches, so the rest of the change still applies on top of the result.
+func PartialAccept(oldLines, newLines []string, unit AcceptUnit) ([]string, int, int) {
+	if line, col, ok := TypedPrefix(oldLines, newLines); ok && line < len(newLines) {
+		result := slices.Clone(oldLines)
+		if line == len(result) {
+			// Every line is complete, so the next target line is added
+			result = append(result, "")
+		}
+		accepted := nextChunk(newLines[line][col:], unit, col > 0)
+		result[line] += accepted
+		return result, line + 1, col + len(accepted)
+	}
+
+	dmp := diffmatchpatch.New()
+	diffs := dmp.DiffMain(JoinLines(oldLines), JoinLines(newLines), false)
+	diffs = dmp.DiffCleanupSemantic(diffs)
+
+	// Unchanged text before the first change
+	var result strings.Builder
+	i := 0
+	for ; i < len(diffs) && diffs[i].Type == diffmatchpatch.DiffEqual; i++ {
+		result.WriteString(diffs[i].Text)
+	}
+
+	// The first change: consecutive deletions and insertions
+	var deleted, inserted strings.Builder
+	for ; i < len(diffs) && diffs[i].Type != diffmatchpatch.DiffEqual; i++ {
+		if diffs[i].Type == diffmatchpatch.DiffDelete {
+			deleted.WriteString(diffs[i].Text)
+		} else {
+			inserted.WriteString(diffs[i].Text)
+		}
+	}
+
+	// Old text after the first change
+	var rest strings.Builder
+	for ; i < len(diffs); i++ {
+		if diffs[i].Type != diffmatchpatch.DiffInsert {
+			rest.WriteString(diffs[i].Text)
+		}
+	}
+
+	prefix := result.String()
+	midLine := prefix != "" && !strings.HasSuffix(prefix, "\n")
+	accepted := nextChunk(inserted.String(), unit, midLine)
+
+	remaining := rest.String()
+	if accepted != inserted.String() {
+		// Keep the replaced text beyond the line the accepted text ends on
+		del := deleted.String()
+		cut := strings.IndexByte(del, '\n')
+		if cut < 0 {
+			cut = len(del)
+		} else if strings.HasSuffix(accepted, "\n") {
+			cut++
+		}
+		remaining = del[cut:] + remaining
+
+		// Part of an inserted line still ends its line
+		if !midLine && accepted != "" && !strings.HasSuffix(accepted, "\n") &&
+			strings.Contains(inserted.String(), "\n") && !strings.HasPrefix(remaining, "\n") {
+			remaining = "\n" + remaining
+		}
+	}
+	result.WriteString(accepted)
+
+	// Cursor goes after the accepted text, staying on its last line
+	head := strings.TrimSuffix(result.String(), "\n")
+	if accepted == "" {
+		head = prefix
+	}
+	line := strings.Count(head, "\n") + 1
+	col := len(head) - (strings.LastIndexByte(head, '\n') + 1)
+
+	result.WriteString(remaining)
+	return splitLines(result.String()), line, col
+}
+
+// nextChunk returns the prefix of s a partial accept takes. A word is any
+// leading whitespace followed by a run of word or punctuation characters. A
+// line completes the current line when midLine, otherwise it is one whole
+// line including its newline.
+func nextChunk(s string, unit AcceptUnit, midLine bool) string {
+	if unit == AcceptLine {
+		start := 0
+		if midLine && strings.HasPrefix(s, "\n") {
+			start = 1 // Skip to the new line instead of accepting nothing
+		}
+		end := strings.IndexByte(s[start:], '\n')
+		if end < 0 {
+			return s
+		}
+		end += start
+		if !midLine {
+			end++
+		}
+		return s[:end]
+	}
+
+	i := 0
+	for i < len(s) && isSpace(s[i]) {
+		i++
+	}
+	if i < len(s) {
+		word := isWordChar(s[i])
+		for i < len(s) && !isSpace(s[i]) && isWordChar(s[i]) == word {
+			i++
+		}
+	}
+	return s[:i]
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+// isWordChar treats all non-ASCII bytes as word characters so multi-byte
+// characters are never split
+func isWordChar(c byte) bool {
+	return c == '_' || c >= 0x80 ||
+		(c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
@@ -0,0 +1,189 @@
+package text
+
+import (
+	"cursortab/assert"
+	"testing"
+)
+
+func TestPartialAccept(t *testing.T) {
+	tests := []struct {
+		name     string
+		old      []string
+		new      []string
+		unit     AcceptUnit
+		expected []string
+		line     int
+		col      int
+	}{
+		{
+			name:     "word appended to line",
+			old:      []string{"x := foo"},
+			new:      []string{"x := foo.Bar(baz)"},
+			unit:     AcceptWord,
+			expected: []string{"x := foo."},
+			line:     1,
+			col:      9,
+		},
+		{
+			name:     "word with leading space",
+			old:      []string{"return"},
+			new:      []string{"return err"},
+			unit:     AcceptWord,
+			expected: []string{"return err"},
+			line:     1,
+			col:      10,
+		},
+		{
+			name:     "word replaces changed text",
+			old:      []string{"call(a)"},
+			new:      []string{"call(bar, c)"},
+			unit:     AcceptWord,
+			expected: []string{"call(bar)"},
+			line:     1,
+			col:      8,
+		},
+		{
+			name:     "word on a new line",
+			old:      []string{"if err != nil {", "}"},
+			new:      []string{"if err != nil {", "\treturn err", "}"},
+			unit:     AcceptWord,
+			expected: []string{"if err != nil {", "\treturn", "}"},
+			line:     2,
+			col:      7,
+		},
+		{
+			name:     "line completes current line",
+			old:      []string{"a"},
+			new:      []string{"afoo", "bar"},
+			unit:     AcceptLine,
+			expected: []string{"afoo"},
+			line:     1,
+			col:      4,
+		},
+		{
+			name:     "line after end of line",
+			old:      []string{"a"},
+			new:      []string{"a", "b", "c"},
+			unit:     AcceptLine,
+			expected: []string{"a", "b"},
+			line:     2,
+			col:      1,
+		},
+		{
+			name:     "line inserted before existing line",
+			old:      []string{"x", "z"},
+			new:      []string{"x", "y1", "y2", "z"},
+			unit:     AcceptLine,
+			expected: []string{"x", "y1", "z"},
+			line:     2,
+			col:      2,
+		},
+		{
+			name:     "line replaces one line of a block",
+			old:      []string{"start", "old one", "old two", "end"},
+			new:      []string{"start", "new first", "new second", "end"},
+			unit:     AcceptLine,
+			expected: []string{"start", "new first", "old two", "end"},
+			line:     2,
+			col:      9,
+		},
+		{
+			name:     "deletion accepted whole",
+			old:      []string{"a", "b", "c"},
+			new:      []string{"a", "c"},
+			unit:     AcceptWord,
+			expected: []string{"a", "c"},
+			line:     2,
+			col:      0,
+		},
+		{
+			name:     "last chunk gives the full completion",
+			old:      []string{"foo"},
+			new:      []string{"foo()"},
+			unit:     AcceptLine,
+			expected: []string{"foo()"},
+			line:     1,
+			col:      5,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines, line, col := PartialAccept(tt.old, tt.new, tt.unit)
+			assert.Equal(t, tt.expected, lines, "lines")
+			assert.Equal(t, tt.line, line, "line")
+			assert.Equal(t, tt.col, col, "col")
+		})
+	}
+}
+
+func TestPartialAccept_ConvergesOnTarget(t *testing.T) {
+	old := []string{"func f() {", "}"}
+	target := []string{"func f(a int) {", "\treturn a * 2", "}"}
+
+	for _, unit := range []AcceptUnit{AcceptWord, AcceptLine} {
+		lines := old
+		for i := 0; i < 20 && !equalLines(lines, target); i++ {
+			lines, _, _ = PartialAccept(lines, target, unit)
+		}
+		assert.Equal(t, target, lines, "repeated partial accepts reach the target")
+	}
+}
+
+func TestTypedPrefix(t *testing.T) {
+	target := []string{"if err != nil {", "\treturn err", "}"}
+	tests := []struct {
+		name  string
+		lines []string
+		line  int
+		col   int
+		ok    bool
+	}{
+		{"partial first line", []string{"if err"}, 0, 6, true},
+		{"partial later line", []string{"if err != nil {", "\tret"}, 1, 4, true},
+		{"earlier line incomplete", []string{"if err", "\treturn err"}, 0, 6, true},
+		{"whole lines missing", []string{"if err != nil {"}, 1, 0, true},
+		{"complete", target, 3, 0, true},
+		{"diverges", []string{"if err == nil {"}, 0, 0, false},
+		{"too many lines", append(target, ""), 0, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			line, col, ok := TypedPrefix(tt.lines, target)
+			assert.Equal(t, tt.ok, ok, "ok")
+			assert.Equal(t, tt.line, line, "line")
+			assert.Equal(t, tt.col, col, "col")
+		})
+	}
+}
+
+func TestPartialAccept_ContinuesTypedPrefix(t *testing.T) {
+	target := []string{"result := compute(a, b)", "\treturn result"}
+
+	for _, unit := range []AcceptUnit{AcceptWord, AcceptLine} {
+		lines := []string{"result := comp"}
+		for i := 0; i < 20 && !equalLines(lines, target); i++ {
+			prevLine, prevCol, _ := TypedPrefix(lines, target)
+			lines, _, _ = PartialAccept(lines, target, unit)
+
+			// Typing checks still see the result as progress toward target
+			line, col, ok := TypedPrefix(lines, target)
+			assert.True(t, ok, "result is a typed prefix of the target")
+			assert.True(t, line > prevLine || col > prevCol, "accept moves forward")
+		}
+		assert.Equal(t, target, lines, "repeated partial accepts reach the target")
+	}
+}
+
+func equalLines(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,99 @@
+package text
+
+// RenamedIdentifier reports whether newLines differ from oldLines only by one
+// identifier being renamed, and returns its old and new names. Every changed
+// line must be its old line with each whole-word occurrence of the old name
+// replaced by the new one.
+func RenamedIdentifier(oldLines, newLines []string) (string, string, bool) {
+	if len(oldLines) != len(newLines) {
+		return "", "", false
+	}
+
+	var oldName, newName string
+	for i := range oldLines {
+		if oldLines[i] == newLines[i] {
+			continue
+		}
+		if oldName == "" {
+			var ok bool
+			if oldName, newName, ok = changedWord(oldLines[i], newLines[i]); !ok {
+				return "", "", false
+			}
+		}
+		if replaceWord(oldLines[i], oldName, newName) != newLines[i] {
+			return "", "", false
+		}
+	}
+	return oldName, newName, oldName != ""
+}
+
+// changedWord returns the whole words that differ between two lines that
+// differ in one place only
+func changedWord(a, b string) (string, string, bool) {
+	start := 0
+	for start < len(a) && start < len(b) && a[start] == b[start] {
+		start++
+	}
+	endA, endB := len(a), len(b)
+	for endA > start && endB > start && a[endA-1] == b[endB-1] {
+		endA--
+		endB--
+	}
+
+	// Widen to whole words on both sides
+	for start > 0 && isWordChar(a[start-1]) {
+		start--
+	}
+	for endA < len(a) && isWordChar(a[endA]) {
+		endA++
+		endB++
+	}
+
+	oldWord, newWord := a[start:endA], b[start:endB]
+	if !isIdentifier(oldWord) || !isIdentifier(newWord) {
+		return "", "", false
+	}
+	return oldWord, newWord, true
+}
+
+func isIdentifier(s string) bool {
+	if s == "" || (s[0] >= '0' && s[0] <= '9') {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !isWordChar(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// IndexWord returns the byte offset of the first whole-word occurrence of word
+// in s, or -1 if there is none
+func IndexWord(s, word string) int {
+	if word == "" {
+		return -1
+	}
+	for i := 0; i+len(word) <= len(s); i++ {
+		if s[i:i+len(word)] != word {
+			continue
+		}
+		if (i == 0 || !isWordChar(s[i-1])) && (i+len(word) == len(s) || !isWordChar(s[i+len(word)])) {
+			return i
+		}
+	}
+	return -1
+}
+
+// replaceWord replaces every whole-word occurrence of old in s with new
+func replaceWord(s, old, new string) string {
+	var result []byte
+	for {
+		i := IndexWord(s, old)
+		if i < 0 {
+			return string(append(result, s...))
+		}
+		result = append(append(result, s[:i]...), new...)
+		s = s[i+len(old):]
+	}
+}
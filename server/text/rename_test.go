@@ -0,0 +1,70 @@
+package text
+
+import (
+	"cursortab/assert"
+	"testing"
+)
+
+func TestRenamedIdentifier(t *testing.T) {
+	tests := []struct {
+		name    string
+		old     []string
+		new     []string
+		oldName string
+		newName string
+		ok      bool
+	}{
+		{
+			name:    "renamed definition",
+			old:     []string{"func fetchUser(id int) error {"},
+			new:     []string{"func loadUser(id int) error {"},
+			oldName: "fetchUser",
+			newName: "loadUser",
+			ok:      true,
+		},
+		{
+			name:    "renamed on several lines",
+			old:     []string{"count := 0", "x := 1", "count++"},
+			new:     []string{"total := 0", "x := 1", "total++"},
+			oldName: "count",
+			newName: "total",
+			ok:      true,
+		},
+		{
+			name: "other change on another line",
+			old:  []string{"count := 0", "x := 1"},
+			new:  []string{"total := 0", "x := 2"},
+		},
+		{
+			name: "not an identifier",
+			old:  []string{"f(a)"},
+			new:  []string{"f(a, b)"},
+		},
+		{
+			name: "line count changed",
+			old:  []string{"a"},
+			new:  []string{"b", "c"},
+		},
+		{
+			name: "no change",
+			old:  []string{"a"},
+			new:  []string{"a"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			oldName, newName, ok := RenamedIdentifier(tt.old, tt.new)
+			assert.Equal(t, tt.ok, ok, "rename detected")
+			assert.Equal(t, tt.oldName, oldName, "old name")
+			assert.Equal(t, tt.newName, newName, "new name")
+		})
+	}
+}
+
+func TestIndexWord(t *testing.T) {
+	assert.Equal(t, 5, IndexWord("err2 err", "err"), "skips partial words")
+	assert.Equal(t, 0, IndexWord("err.Error()", "err"), "word at start")
+	assert.Equal(t, -1, IndexWord("errors", "err"), "no whole word")
+	assert.Equal(t, -1, IndexWord("x", ""), "empty word")
+}
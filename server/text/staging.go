@@ -318,9 +318,7 @@ func getStageNewLineRange(stage *Stage) (int, int) {
 // It extracts content, remaps changes to relative line numbers, computes groups,
 // and sets cursor targets based on sort order.
 func finalizeStages(stages []*Stage, newLines []string, filePath string, baseLineOffset int, diff *DiffResult) {
-	for i, stage := range stages {
-		isLastStage := i == len(stages)-1
-
+	for _, stage := range stages {
 		// Get buffer line mappings for this stage
 		lineNumToBufferLine := make(map[int]int)
 		getStageBufferRange(stage, baseLineOffset, diff, lineNumToBufferLine)
@@ -396,34 +394,77 @@ func finalizeStages(stages []*Stage, newLines []string, filePath string, baseLin
 
 		cursorLine, cursorCol := CalculateCursorPosition(remappedChanges, stageLines)
 
-		// Create cursor target
-		var cursorTarget *types.CursorPredictionTarget
-		if isLastStage {
-			cursorTarget = &types.CursorPredictionTarget{
+		// Populate the stage's exported fields
+		stage.Lines = stageLines
+		stage.Changes = remappedChanges
+		stage.Groups = groups
+		stage.CursorLine = cursorLine
+		stage.CursorCol = cursorCol
+
+		// Clear rawChanges (no longer needed)
+		stage.rawChanges = nil
+	}
+
+	linkStages(stages, filePath)
+}
+
+// linkStages sets the cursor target of each stage to the start of the next
+// one, and the last stage's to its own end with a retrigger
+func linkStages(stages []*Stage, filePath string) {
+	for i, stage := range stages {
+		stage.IsLastStage = i == len(stages)-1
+		if stage.IsLastStage {
+			stage.CursorTarget = &types.CursorPredictionTarget{
 				RelativePath:    filePath,
 				LineNumber:      int32(stage.BufferEnd),
 				ShouldRetrigger: true,
 			}
 		} else {
-			nextStage := stages[i+1]
-			cursorTarget = &types.CursorPredictionTarget{
+			stage.CursorTarget = &types.CursorPredictionTarget{
 				RelativePath:    filePath,
-				LineNumber:      int32(nextStage.BufferStart),
+				LineNumber:      int32(stages[i+1].BufferStart),
 				ShouldRetrigger: false,
 			}
 		}
+	}
+}
 
-		// Populate the stage's exported fields
-		stage.Lines = stageLines
-		stage.Changes = remappedChanges
-		stage.Groups = groups
-		stage.CursorLine = cursorLine
-		stage.CursorCol = cursorCol
-		stage.CursorTarget = cursorTarget
-		stage.IsLastStage = isLastStage
+// MergeStages combines the staging results of several disjoint hunks of one
+// edit into a single result. Stages are ordered by distance from the cursor,
+// as within a single hunk, and their cursor targets are chained again.
+// Returns nil if no hunk has any stage.
+func MergeStages(
+	results []*StagingResult,
+	cursorRow int,
+	viewportTop, viewportBottom int,
+	proximityThreshold int,
+	filePath string,
+) *StagingResult {
+	var allStages []*Stage
+	for _, result := range results {
+		if result != nil {
+			allStages = append(allStages, result.Stages...)
+		}
+	}
+	if len(allStages) == 0 {
+		return nil
+	}
 
-		// Clear rawChanges (no longer needed)
-		stage.rawChanges = nil
+	sort.SliceStable(allStages, func(i, j int) bool {
+		distI := stageDistanceFromCursor(allStages[i], cursorRow)
+		distJ := stageDistanceFromCursor(allStages[j], cursorRow)
+		if distI != distJ {
+			return distI < distJ
+		}
+		return allStages[i].BufferStart < allStages[j].BufferStart
+	})
+	linkStages(allStages, filePath)
+
+	return &StagingResult{
+		Stages: allStages,
+		FirstNeedsNavigation: StageNeedsNavigation(
+			allStages[0], cursorRow, viewportTop, viewportBottom, proximityThreshold,
+		),
 	}
 }
 
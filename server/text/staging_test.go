@@ -257,6 +257,32 @@ func TestCreateStages_CursorDistanceSorting(t *testing.T) {
 	assert.Equal(t, 20, result.Stages[0].BufferStart, "first stage should be closest cluster (20-21)")
 }
 
+func TestMergeStages(t *testing.T) {
+	hunk := func(line int) *StagingResult {
+		diff := &DiffResult{
+			Changes: map[int]LineChange{
+				1: {Type: ChangeModification, OldLineNum: 1, NewLineNum: 1, Content: "new", OldContent: "old"},
+			},
+		}
+		return CreateStages(diff, line, 0, 0, line, 3, "test.go", []string{"new"}, []string{"old"})
+	}
+
+	result := MergeStages([]*StagingResult{hunk(5), hunk(30), nil, hunk(12)}, 14, 1, 20, 3, "test.go")
+
+	assert.NotNil(t, result, "result")
+	assert.Len(t, 3, result.Stages, "stages of all hunks")
+	assert.Equal(t, 12, result.Stages[0].BufferStart, "closest hunk first")
+	assert.Equal(t, 5, result.Stages[1].BufferStart, "second closest")
+	assert.Equal(t, 30, result.Stages[2].BufferStart, "farthest last")
+	assert.Equal(t, int32(5), result.Stages[0].CursorTarget.LineNumber, "targets chained to next stage")
+	assert.False(t, result.Stages[0].IsLastStage, "first stage not last")
+	assert.True(t, result.Stages[2].IsLastStage, "last stage")
+	assert.True(t, result.Stages[2].CursorTarget.ShouldRetrigger, "last stage retriggers")
+	assert.False(t, result.FirstNeedsNavigation, "first stage near cursor")
+
+	assert.Nil(t, MergeStages([]*StagingResult{nil}, 1, 0, 0, 3, "test.go"), "no stages")
+}
+
 func TestCreateStages_ViewportPartitioning(t *testing.T) {
 	// Changes at lines 10 (in viewport) and 100 (out of viewport)
 	// Viewport is 1-50
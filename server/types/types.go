@@ -1,5 +1,7 @@
 package types
 
+import "time"
+
 // Completion represents a code completion with line range and content
 type Completion struct {
 	StartLine  int // 1-indexed
@@ -38,12 +40,15 @@ type CompletionRequest struct {
 	WorkspaceID   string
 	// File context
 	FilePath string
+	FileType string // Neovim filetype of the buffer
 	Lines    []string
 	Version  int
 	// PreviousLines is the file content before the most recent edit
 	PreviousLines []string
 	// Multi-file diff histories in the same workspace
 	FileDiffHistories []*FileDiffHistory
+	// Text of other open buffers, most recently used first (empty unless enabled)
+	ContextBuffers []*FileChunk
 	// Cursor position
 	CursorRow int // 1-indexed
 	CursorCol int // 0-indexed
@@ -57,6 +62,9 @@ type CompletionRequest struct {
 type CompletionResponse struct {
 	Completions  []*Completion
 	CursorTarget *CursorPredictionTarget // Optional, from cursor_prediction_target
+	// Disjoint marks Completions as non-overlapping hunks of one edit, shown
+	// and accepted together, rather than alternative candidates
+	Disjoint bool
 }
 
 // LinterErrors represents linter error information for the current file
@@ -80,6 +88,12 @@ type FileDiffHistory struct {
 	DiffHistory []*DiffEntry
 }
 
+// FileChunk is a range of lines from another file, sent as context
+type FileChunk struct {
+	FileName string // Relative to the workspace when inside it
+	Text     string
+}
+
 // DiffEntry represents a single diff operation with structured before/after content
 // This allows providers to format the diff in their required format
 type DiffEntry struct {
@@ -107,10 +121,30 @@ type CursorRange struct {
 type ProviderType string
 
 const (
-	ProviderTypeInline ProviderType = "inline"
-	ProviderTypeFIM    ProviderType = "fim"
-	ProviderTypeSweep  ProviderType = "sweep"
-	ProviderTypeZeta   ProviderType = "zeta"
+	ProviderTypeInline   ProviderType = "inline"
+	ProviderTypeFIM      ProviderType = "fim"
+	ProviderTypeInfill   ProviderType = "infill"
+	ProviderTypeSweep    ProviderType = "sweep"
+	ProviderTypeZeta     ProviderType = "zeta"
+	ProviderTypeNextEdit ProviderType = "nextedit"
+)
+
+// APIType represents the wire format spoken by the provider server
+type APIType string
+
+const (
+	APITypeCompletions APIType = "completions" // OpenAI /v1/completions (prompt in, choices[].text out)
+	APITypeChat        APIType = "chat"        // OpenAI /v1/chat/completions (messages in, choices[].delta.content out)
+	APITypeFIM         APIType = "fim"         // Mistral /v1/fim/completions (prompt and suffix in, choices[].delta.content out)
+	APITypeOllama      APIType = "ollama"      // Ollama /api/generate (raw prompt in, NDJSON response out)
+)
+
+// FIMMode is how the fim provider sends the text around the cursor
+type FIMMode string
+
+const (
+	FIMModeTokens FIMMode = "tokens" // One prompt joined with the configured FIM tokens
+	FIMModeServer FIMMode = "server" // Prompt and suffix as separate fields; the server applies its FIM tokens
 )
 
 // FIMTokenConfig holds FIM (Fill-in-the-Middle) token configuration
@@ -122,11 +156,34 @@ type FIMTokenConfig struct {
 
 // ProviderConfig holds configuration for providers
 type ProviderConfig struct {
-	ProviderURL         string         // URL of the provider server (e.g., "http://localhost:8000")
-	ProviderModel       string         // Model name
-	ProviderTemperature float64        // Sampling temperature
-	ProviderMaxTokens   int            // Max tokens to generate (also drives input trimming)
-	ProviderTopK        int            // Top-k sampling (used by some providers)
-	CompletionPath      string         // API endpoint path (e.g., "/v1/completions")
-	FIMTokens           FIMTokenConfig // FIM tokens configuration
+	ProviderURL         string            // URL of the provider server (e.g., "http://localhost:8000")
+	ProviderModel       string            // Model name
+	ProviderTemperature float64           // Sampling temperature
+	ProviderMaxTokens   int               // Max tokens to generate (also drives input trimming)
+	ProviderTopK        int               // Top-k sampling (used by some providers)
+	ProviderCandidates  int               // Completions requested per request (<= 1 = one)
+	CompletionPath      string            // API endpoint path (e.g., "/v1/completions")
+	API                 APIType           // Wire format of the endpoint at CompletionPath
+	Headers             map[string]string // Extra HTTP headers (e.g., org or tenant IDs)
+	APIKey              string            // Bearer token, resolved from env or key file (never from config JSON)
+	FIMTokens           FIMTokenConfig    // FIM tokens configuration
+	FIMMode             FIMMode           // How the fim provider sends prefix and suffix
+	CursorTargetMarker  string            // Prefix of the output line naming the next edit location ("" = off)
+	MaxRetries          int               // Retries of requests the server turns away with 429 or 503
+	BreakerFailures     int               // Consecutive failures that stop requests to the server (0 = never)
+	BreakerCooldown     time.Duration     // Time requests stay stopped before the server is probed
+	KeepAlive           string            // How long Ollama keeps the model loaded ("" = server default)
+	Sampling            SamplingConfig    // Extra sampling parameters, sent by every API but fim
+	ExtraBody           map[string]any    // Fields merged into every request body, overriding the built ones
+}
+
+// SamplingConfig holds optional sampling parameters. Nil or empty fields are
+// not sent, leaving the server default.
+type SamplingConfig struct {
+	MinP              *float64           // Drop tokens below this fraction of the top token's probability
+	RepetitionPenalty *float64           // Penalty on repeated tokens (> 1 discourages loops)
+	PresencePenalty   *float64           // Penalty on tokens already present in the output
+	Seed              *int64             // Seed for reproducible sampling
+	Grammar           string             // GBNF grammar constraining the output (llama.cpp)
+	LogitBias         map[string]float64 // Bias added to the logits of token IDs
 }